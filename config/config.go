@@ -0,0 +1,524 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "******"
+
+type Config struct {
+	Port              int         `yaml:"port"`
+	AuthToken         string      `yaml:"auth_token"`
+	ValidateResponses bool        `yaml:"validate_responses"`
+	Database          Database    `yaml:"database"`
+	Validation        Validation  `yaml:"validation"`
+	Alerts            Alerts      `yaml:"alerts"`
+	Webhooks          Webhooks    `yaml:"webhooks"`
+	Outbox            Outbox      `yaml:"outbox"`
+	Recurring         Recurring   `yaml:"recurring"`
+	Attachments       Attachments `yaml:"attachments"`
+	Shutdown          Shutdown    `yaml:"shutdown"`
+}
+
+type Database struct {
+	Driver          string        `yaml:"driver"`
+	URL             string        `yaml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	ConnectRetries  int           `yaml:"connect_retries"`
+	ConnectBackoff  time.Duration `yaml:"connect_backoff"`
+	QueryTimeout    time.Duration `yaml:"query_timeout"`
+}
+
+// Validation holds the expense business rules that vary per deployment.
+type Validation struct {
+	MaxTitleLength int     `yaml:"max_title_length"`
+	MaxNoteLength  int     `yaml:"max_note_length"`
+	MaxAmount      float64 `yaml:"max_amount"`
+	MaxTags        int     `yaml:"max_tags"`
+	TagPattern     string  `yaml:"tag_pattern"`
+}
+
+// Alerts configures where budget threshold alerts are delivered. Without a
+// webhook URL alerts are only stored; otherwise the outbox dispatcher posts
+// them, retrying until the webhook accepts.
+type Alerts struct {
+	WebhookURL string        `yaml:"webhook_url"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// Webhooks configures delivery to webhook subscriptions. A failed delivery is
+// retried by the outbox dispatcher MaxAttempts times in total, waiting
+// Backoff, then twice as long after each further failure.
+type Webhooks struct {
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxAttempts int           `yaml:"max_attempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+}
+
+// Outbox configures the dispatcher that publishes events written to the
+// outbox. Sinks is a comma separated list of webhook, stdout and file.
+type Outbox struct {
+	Sinks        string        `yaml:"sinks"`
+	File         string        `yaml:"file"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size"`
+}
+
+// SinkNames returns the configured sinks without blanks.
+func (o Outbox) SinkNames() []string {
+	var names []string
+	for _, name := range strings.Split(o.Sinks, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Recurring configures the scheduler that creates recurring expenses.
+type Recurring struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Attachments configures receipt uploads. Store is local, which keeps files
+// under Dir, or s3. ContentTypes is a comma separated list of the types an
+// upload may have and MaxSize is in bytes.
+type Attachments struct {
+	Store        string `yaml:"store"`
+	Dir          string `yaml:"dir"`
+	MaxSize      int    `yaml:"max_size"`
+	ContentTypes string `yaml:"content_types"`
+	S3           S3     `yaml:"s3"`
+}
+
+// AllowedContentTypes returns the configured content types without blanks.
+func (a Attachments) AllowedContentTypes() []string {
+	var types []string
+	for _, t := range strings.Split(a.ContentTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// S3 locates the bucket of an S3-compatible service, such as MinIO, that
+// attachments are stored in.
+type S3 struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// Shutdown configures how the server stops. Readiness fails for DrainDelay
+// before the server stops accepting requests, so load balancers have time to
+// take the instance out of rotation.
+type Shutdown struct {
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+type field struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  interface{}
+}
+
+// Errors collects every problem found while loading or validating a config so
+// they can be reported together instead of one at a time.
+type Errors []string
+
+func (e Errors) Error() string {
+	return "invalid config: " + strings.Join(e, "; ")
+}
+
+func Default() Config {
+	return Config{
+		Port: 2565,
+		Database: Database{
+			Driver:          "postgres",
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+			ConnectRetries:  5,
+			ConnectBackoff:  time.Second,
+			QueryTimeout:    5 * time.Second,
+		},
+		Validation: Validation{
+			MaxTitleLength: 200,
+			MaxNoteLength:  1000,
+			MaxAmount:      10000000,
+			MaxTags:        10,
+			TagPattern:     `^[\p{L}\p{M}\p{N}][\p{L}\p{M}\p{N} &_.-]{0,29}$`,
+		},
+		Alerts: Alerts{
+			Timeout: 5 * time.Second,
+		},
+		Webhooks: Webhooks{
+			Timeout:     5 * time.Second,
+			MaxAttempts: 5,
+			Backoff:     time.Second,
+		},
+		Outbox: Outbox{
+			Sinks:        "webhook",
+			PollInterval: time.Second,
+			BatchSize:    100,
+		},
+		Recurring: Recurring{
+			Interval: time.Minute,
+		},
+		Attachments: Attachments{
+			Store:        "local",
+			Dir:          "attachments",
+			MaxSize:      10 << 20,
+			ContentTypes: "image/jpeg,image/png,image/webp,application/pdf",
+			S3: S3{
+				Region: "us-east-1",
+			},
+		},
+		Shutdown: Shutdown{
+			DrainDelay: 5 * time.Second,
+		},
+	}
+}
+
+func (c *Config) fields() []field {
+	return []field{
+		{key: "port", env: "PORT", usage: "api server port", value: &c.Port},
+		{key: "auth-token", env: "AUTH_TOKEN", usage: "token required in the Authorization header", secret: true, value: &c.AuthToken},
+		{key: "validate-responses", env: "VALIDATE_RESPONSES", usage: "check responses against the openapi spec, for tests", value: &c.ValidateResponses},
+		{key: "database-driver", env: "DATABASE_DRIVER", usage: "database/sql driver name", value: &c.Database.Driver},
+		{key: "database-url", env: "DATABASE_URL", usage: "database connection url", secret: true, value: &c.Database.URL},
+		{key: "database-max-open-conns", env: "DATABASE_MAX_OPEN_CONNS", usage: "maximum open connections, 0 for unlimited", value: &c.Database.MaxOpenConns},
+		{key: "database-max-idle-conns", env: "DATABASE_MAX_IDLE_CONNS", usage: "maximum idle connections", value: &c.Database.MaxIdleConns},
+		{key: "database-conn-max-lifetime", env: "DATABASE_CONN_MAX_LIFETIME", usage: "maximum lifetime of a connection", value: &c.Database.ConnMaxLifetime},
+		{key: "database-conn-max-idle-time", env: "DATABASE_CONN_MAX_IDLE_TIME", usage: "maximum idle time of a connection", value: &c.Database.ConnMaxIdleTime},
+		{key: "database-connect-retries", env: "DATABASE_CONNECT_RETRIES", usage: "connection attempts retried at startup", value: &c.Database.ConnectRetries},
+		{key: "database-connect-backoff", env: "DATABASE_CONNECT_BACKOFF", usage: "initial delay between startup connection attempts", value: &c.Database.ConnectBackoff},
+		{key: "database-query-timeout", env: "DATABASE_QUERY_TIMEOUT", usage: "timeout applied to every query", value: &c.Database.QueryTimeout},
+		{key: "validation-max-title-length", env: "VALIDATION_MAX_TITLE_LENGTH", usage: "maximum expense title length in characters", value: &c.Validation.MaxTitleLength},
+		{key: "validation-max-note-length", env: "VALIDATION_MAX_NOTE_LENGTH", usage: "maximum expense note length in characters", value: &c.Validation.MaxNoteLength},
+		{key: "validation-max-amount", env: "VALIDATION_MAX_AMOUNT", usage: "maximum expense amount", value: &c.Validation.MaxAmount},
+		{key: "validation-max-tags", env: "VALIDATION_MAX_TAGS", usage: "maximum number of tags per expense", value: &c.Validation.MaxTags},
+		{key: "validation-tag-pattern", env: "VALIDATION_TAG_PATTERN", usage: "regular expression every tag must match", value: &c.Validation.TagPattern},
+		{key: "alerts-webhook-url", env: "ALERTS_WEBHOOK_URL", usage: "url budget threshold alerts are posted to", secret: true, value: &c.Alerts.WebhookURL},
+		{key: "alerts-timeout", env: "ALERTS_TIMEOUT", usage: "timeout for delivering an alert", value: &c.Alerts.Timeout},
+		{key: "webhooks-timeout", env: "WEBHOOKS_TIMEOUT", usage: "timeout for a single webhook delivery attempt", value: &c.Webhooks.Timeout},
+		{key: "webhooks-max-attempts", env: "WEBHOOKS_MAX_ATTEMPTS", usage: "delivery attempts before an event is dead-lettered", value: &c.Webhooks.MaxAttempts},
+		{key: "webhooks-backoff", env: "WEBHOOKS_BACKOFF", usage: "wait before the first webhook retry, doubled after each failure", value: &c.Webhooks.Backoff},
+		{key: "outbox-sinks", env: "OUTBOX_SINKS", usage: "comma separated sinks outbox events are published to: webhook, stdout, file", value: &c.Outbox.Sinks},
+		{key: "outbox-file", env: "OUTBOX_FILE", usage: "file the file sink appends events to", value: &c.Outbox.File},
+		{key: "outbox-poll-interval", env: "OUTBOX_POLL_INTERVAL", usage: "how often the dispatcher checks the outbox", value: &c.Outbox.PollInterval},
+		{key: "outbox-batch-size", env: "OUTBOX_BATCH_SIZE", usage: "events claimed by the dispatcher at a time", value: &c.Outbox.BatchSize},
+		{key: "recurring-interval", env: "RECURRING_INTERVAL", usage: "how often the scheduler creates due recurring expenses", value: &c.Recurring.Interval},
+		{key: "attachments-store", env: "ATTACHMENTS_STORE", usage: "where attachments are stored: local or s3", value: &c.Attachments.Store},
+		{key: "attachments-dir", env: "ATTACHMENTS_DIR", usage: "directory the local store keeps attachments in", value: &c.Attachments.Dir},
+		{key: "attachments-max-size", env: "ATTACHMENTS_MAX_SIZE", usage: "maximum attachment size in bytes", value: &c.Attachments.MaxSize},
+		{key: "attachments-content-types", env: "ATTACHMENTS_CONTENT_TYPES", usage: "comma separated content types an attachment may have", value: &c.Attachments.ContentTypes},
+		{key: "attachments-s3-endpoint", env: "ATTACHMENTS_S3_ENDPOINT", usage: "url of the S3-compatible service", value: &c.Attachments.S3.Endpoint},
+		{key: "attachments-s3-region", env: "ATTACHMENTS_S3_REGION", usage: "region requests to the S3 service are signed for", value: &c.Attachments.S3.Region},
+		{key: "attachments-s3-bucket", env: "ATTACHMENTS_S3_BUCKET", usage: "bucket attachments are stored in", value: &c.Attachments.S3.Bucket},
+		{key: "attachments-s3-access-key", env: "ATTACHMENTS_S3_ACCESS_KEY", usage: "access key for the S3 service", secret: true, value: &c.Attachments.S3.AccessKey},
+		{key: "attachments-s3-secret-key", env: "ATTACHMENTS_S3_SECRET_KEY", usage: "secret key for the S3 service", secret: true, value: &c.Attachments.S3.SecretKey},
+		{key: "shutdown-drain-delay", env: "SHUTDOWN_DRAIN_DELAY", usage: "how long readiness fails before the server stops accepting requests", value: &c.Shutdown.DrainDelay},
+	}
+}
+
+// Load resolves the config from defaults, an optional YAML file, environment
+// variables and command line flags, each layer overriding the previous one.
+func Load(args []string) (Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("assessment", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flags := map[string]*string{}
+	for _, f := range cfg.fields() {
+		flags[f.key] = fs.String(f.key, "", f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, Errors{err.Error()}
+	}
+
+	if *path != "" {
+		if err := loadFile(&cfg, *path); err != nil {
+			return cfg, Errors{err.Error()}
+		}
+	}
+
+	var errs Errors
+	for _, f := range cfg.fields() {
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := set(f.value, v); err != nil {
+				errs = append(errs, fmt.Sprintf("env %s: %v", f.env, err))
+			}
+		}
+	}
+
+	fields := cfg.fields()
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.key == fl.Name {
+				if err := set(f.value, *flags[f.key]); err != nil {
+					errs = append(errs, fmt.Sprintf("flag -%s: %v", f.key, err))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func loadFile(cfg *Config, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func set(ptr interface{}, v string) error {
+	switch p := ptr.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*p = f
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", v)
+		}
+		*p = d
+	default:
+		return fmt.Errorf("unsupported config type %T", ptr)
+	}
+	return nil
+}
+
+func (c Config) Validate() error {
+	var errs Errors
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.AuthToken == "" {
+		errs = append(errs, "auth token is required (AUTH_TOKEN)")
+	}
+	if c.Database.Driver == "" {
+		errs = append(errs, "database driver is required (DATABASE_DRIVER)")
+	}
+	if c.Database.URL == "" {
+		errs = append(errs, "database url is required (DATABASE_URL)")
+	}
+	if c.Database.MaxOpenConns < 0 {
+		errs = append(errs, "database max open conns must not be negative")
+	}
+	if c.Database.MaxIdleConns < 0 {
+		errs = append(errs, "database max idle conns must not be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, "database max idle conns must not exceed max open conns")
+	}
+	if c.Database.ConnectRetries < 0 {
+		errs = append(errs, "database connect retries must not be negative")
+	}
+	if c.Database.QueryTimeout <= 0 {
+		errs = append(errs, "database query timeout must be greater than 0")
+	}
+	if c.Validation.MaxTitleLength <= 0 {
+		errs = append(errs, "validation max title length must be greater than 0")
+	}
+	if c.Validation.MaxNoteLength <= 0 {
+		errs = append(errs, "validation max note length must be greater than 0")
+	}
+	if c.Validation.MaxAmount <= 0 {
+		errs = append(errs, "validation max amount must be greater than 0")
+	}
+	if c.Validation.MaxTags <= 0 {
+		errs = append(errs, "validation max tags must be greater than 0")
+	}
+	if _, err := regexp.Compile(c.Validation.TagPattern); err != nil {
+		errs = append(errs, fmt.Sprintf("validation tag pattern is not a valid regular expression: %v", err))
+	}
+	if c.Alerts.WebhookURL != "" {
+		if u, err := url.Parse(c.Alerts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, "alerts webhook url must be an absolute http or https url")
+		}
+	}
+	if c.Alerts.Timeout <= 0 {
+		errs = append(errs, "alerts timeout must be greater than 0")
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, "webhooks timeout must be greater than 0")
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, "webhooks max attempts must be at least 1")
+	}
+	if c.Webhooks.Backoff < 0 {
+		errs = append(errs, "webhooks backoff must not be negative")
+	}
+	for _, sink := range c.Outbox.SinkNames() {
+		switch sink {
+		case "webhook", "stdout":
+		case "file":
+			if c.Outbox.File == "" {
+				errs = append(errs, "outbox file is required for the file sink (OUTBOX_FILE)")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("outbox sink must be one of webhook, stdout or file, got %q", sink))
+		}
+	}
+	if c.Outbox.PollInterval <= 0 {
+		errs = append(errs, "outbox poll interval must be greater than 0")
+	}
+	if c.Outbox.BatchSize < 1 {
+		errs = append(errs, "outbox batch size must be at least 1")
+	}
+	if c.Recurring.Interval <= 0 {
+		errs = append(errs, "recurring interval must be greater than 0")
+	}
+	switch c.Attachments.Store {
+	case "local":
+		if c.Attachments.Dir == "" {
+			errs = append(errs, "attachments dir is required for the local store (ATTACHMENTS_DIR)")
+		}
+	case "s3":
+		if u, err := url.Parse(c.Attachments.S3.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, "attachments s3 endpoint must be an absolute url (ATTACHMENTS_S3_ENDPOINT)")
+		}
+		if c.Attachments.S3.Bucket == "" {
+			errs = append(errs, "attachments s3 bucket is required (ATTACHMENTS_S3_BUCKET)")
+		}
+		if c.Attachments.S3.AccessKey == "" || c.Attachments.S3.SecretKey == "" {
+			errs = append(errs, "attachments s3 access key and secret key are required")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("attachments store must be local or s3, got %q", c.Attachments.Store))
+	}
+	if c.Attachments.MaxSize < 1 {
+		errs = append(errs, "attachments max size must be at least 1")
+	}
+	if len(c.Attachments.AllowedContentTypes()) == 0 {
+		errs = append(errs, "attachments content types must not be empty")
+	}
+	if c.Shutdown.DrainDelay < 0 {
+		errs = append(errs, "shutdown drain delay must not be negative")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c Config) Address() string {
+	return fmt.Sprintf(":%d", c.Port)
+}
+
+func (c Config) Redacted() Config {
+	r := c
+	for _, f := range r.fields() {
+		p, ok := f.value.(*string)
+		if !f.secret || !ok || *p == "" {
+			continue
+		}
+		*p = redact(*p)
+	}
+	return r
+}
+
+// secretQueryParams hold credentials in the query of a connection URL.
+var secretQueryParams = map[string]bool{"password": true, "sslpassword": true}
+
+// redact masks the credentials of a URL and keeps the rest of it readable.
+// A value with no credentials where they can be masked, such as a webhook URL
+// with its token in the path, is redacted whole.
+func redact(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+		return redacted
+	}
+	var user string
+	if u.User != nil {
+		user = url.User(u.User.Username()).String()
+	}
+	pw, _ := u.User.Password()
+	masked := pw != ""
+	u.User = nil
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			key, _, _ := strings.Cut(p, "=")
+			name, err := url.QueryUnescape(key)
+			if err != nil {
+				return redacted
+			}
+			if secretQueryParams[strings.ToLower(name)] {
+				params[i] = key + "=" + redacted
+				masked = true
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	if !masked {
+		return redacted
+	}
+	rest := strings.TrimPrefix(u.String(), u.Scheme+"://")
+	switch {
+	case pw != "":
+		return u.Scheme + "://" + user + ":" + redacted + "@" + rest
+	case user != "":
+		return u.Scheme + "://" + user + "@" + rest
+	}
+	return u.Scheme + "://" + rest
+}
+
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
@@ -0,0 +1,158 @@
+//go:build unit
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func clearEnv(t *testing.T) {
+	for _, key := range []string{"CONFIG_FILE", "PORT", "AUTH_TOKEN", "DATABASE_DRIVER", "DATABASE_URL"} {
+		if v, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			t.Cleanup(func() { os.Setenv(key, v) })
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("Test case for layering file, env and flags over defaults", func(t *testing.T) {
+		clearEnv(t)
+		path := writeFile(t, "port: 8080\nauth_token: file-token\ndatabase:\n  url: postgres://file\n")
+		t.Setenv("AUTH_TOKEN", "env-token")
+		t.Setenv("DATABASE_URL", "postgres://env")
+
+		cfg, err := Load([]string{"-config", path, "-database-url", "postgres://flag"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 8080, cfg.Port)
+		assert.Equal(t, "env-token", cfg.AuthToken)
+		assert.Equal(t, "postgres", cfg.Database.Driver)
+		assert.Equal(t, "postgres://flag", cfg.Database.URL)
+	})
+
+	t.Run("Test case for reporting every missing value", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-port", "0"})
+
+		assert.EqualError(t, err, "invalid config: port must be between 1 and 65535, got 0; auth token is required (AUTH_TOKEN); database url is required (DATABASE_URL)")
+	})
+
+	t.Run("Test case for malformed env value", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("PORT", "abc")
+
+		_, err := Load(nil)
+
+		assert.EqualError(t, err, `invalid config: env PORT: "abc" is not an integer`)
+	})
+
+	t.Run("Test case for relative alerts webhook url", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-auth-token", "token", "-database-url", "postgres://flag", "-alerts-webhook-url", "/alerts"})
+
+		assert.EqualError(t, err, "invalid config: alerts webhook url must be an absolute http or https url")
+	})
+
+	t.Run("Test case for file sink without a file", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-auth-token", "token", "-database-url", "postgres://flag", "-outbox-sinks", "stdout,file"})
+
+		assert.EqualError(t, err, "invalid config: outbox file is required for the file sink (OUTBOX_FILE)")
+	})
+
+	t.Run("Test case for s3 store without a bucket", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-auth-token", "token", "-database-url", "postgres://flag", "-attachments-store", "s3",
+			"-attachments-s3-endpoint", "http://minio:9000", "-attachments-s3-access-key", "minio", "-attachments-s3-secret-key", "minio123"})
+
+		assert.EqualError(t, err, "invalid config: attachments s3 bucket is required (ATTACHMENTS_S3_BUCKET)")
+	})
+
+	t.Run("Test case for negative drain delay", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-auth-token", "token", "-database-url", "postgres://flag", "-shutdown-drain-delay", "-1s"})
+
+		assert.EqualError(t, err, "invalid config: shutdown drain delay must not be negative")
+	})
+
+	t.Run("Test case for unknown key in config file", func(t *testing.T) {
+		clearEnv(t)
+		path := writeFile(t, "prot: 8080\n")
+
+		_, err := Load([]string{"-config", path})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "field prot not found")
+	})
+}
+
+func TestPrint(t *testing.T) {
+	cfg := Default()
+	cfg.AuthToken = "November 10, 2009"
+	cfg.Database.URL = "postgres://root:hunter2@db/assessment-db?sslmode=disable"
+	cfg.Attachments.S3.SecretKey = "minio123"
+	var buf bytes.Buffer
+
+	err := cfg.Print(&buf)
+
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "hunter2")
+	assert.NotContains(t, buf.String(), "November")
+	assert.NotContains(t, buf.String(), "minio123")
+	assert.Contains(t, buf.String(), "auth_token: '******'")
+	assert.Contains(t, buf.String(), "root:******@db/assessment-db")
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{
+			name:  "Test case for a password in the user info",
+			value: "postgres://root:hunter2@db/x?sslmode=disable",
+			want:  "postgres://root:******@db/x?sslmode=disable",
+		},
+		{
+			name:  "Test case for a password in the query",
+			value: "postgres://root@db/x?sslmode=disable&password=hunter2",
+			want:  "postgres://root@db/x?sslmode=disable&password=******",
+		},
+		{
+			name:  "Test case for a token in the path",
+			value: "https://hooks.slack.com/services/T000/B000/XXXXXXXX",
+			want:  "******",
+		},
+		{
+			name:  "Test case for a key and value connection string",
+			value: "host=db user=root password=hunter2",
+			want:  "******",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, redact(test.value))
+		})
+	}
+}
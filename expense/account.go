@@ -0,0 +1,295 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const (
+	AccountCreditCard = "credit_card"
+	AccountDebit      = "debit"
+	AccountCash       = "cash"
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Account is where the money for an expense came from. Its balance starts
+// at OpeningBalance, every expense paid from it or transferred out is taken
+// off and income and transfers in are added, so a credit card runs negative
+// by what is owed on it.
+type Account struct {
+	ID             int     `json:"id"`
+	Name           string  `json:"name"`
+	Type           string  `json:"type"`
+	Currency       string  `json:"currency"`
+	OpeningBalance float64 `json:"opening_balance"`
+}
+
+const accountColumns = "id, name, type, currency, opening_balance"
+
+func scanAccount(s scanner, a *Account) error {
+	return s.Scan(&a.ID, &a.Name, &a.Type, &a.Currency, &a.OpeningBalance)
+}
+
+func (a *Account) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "account."+field+"."+rule, params))
+	}
+
+	if a.Name == "" {
+		add("/name", "name", "required", nil)
+	}
+	switch a.Type {
+	case AccountCreditCard, AccountDebit, AccountCash:
+	default:
+		add("/type", "type", "enum", nil)
+	}
+	if !currencyPattern.MatchString(a.Currency) {
+		add("/currency", "currency", "pattern", nil)
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidAccount.WithFields(fields...)
+	}
+	return nil
+}
+
+func bindAccount(c echo.Context) (Account, error) {
+	a := Account{}
+	if err := c.Bind(&a); err != nil {
+		c.Logger().Error("invalid request binding to struct account error: ", err)
+		return a, ErrInvalidRequest.Wrap(err)
+	}
+	a.Name = strings.TrimSpace(a.Name)
+	a.Currency = strings.ToUpper(strings.TrimSpace(a.Currency))
+	return a, a.Validate()
+}
+
+// accountWriteError maps constraint violations to client errors.
+func accountWriteError(err error) error {
+	if pqErrorCode(err) == uniqueViolation {
+		return ErrAccountConflict.WithDetailKey("detail.account.conflict", nil).Wrap(err)
+	}
+	return ErrAccountUpdate.Wrap(err)
+}
+
+func CreateAccountHandler(c echo.Context) error {
+	a, err := bindAccount(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, "INSERT INTO accounts (name, type, currency, opening_balance) VALUES ($1, $2, $3, $4) RETURNING id",
+		a.Name, a.Type, a.Currency, a.OpeningBalance).Scan(&a.ID)
+	if err != nil {
+		c.Logger().Error("insert account error: ", err)
+		return accountWriteError(err)
+	}
+
+	return c.JSON(http.StatusCreated, a)
+}
+
+func GetAccountsHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+accountColumns+" FROM accounts ORDER BY name, id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrAccountQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	as := []Account{}
+	for rows.Next() {
+		a := Account{}
+		if err = scanAccount(rows, &a); err != nil {
+			c.Logger().Error("scan account error: ", err)
+			return ErrAccountQuery.Wrap(err)
+		}
+		as = append(as, a)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate accounts error: ", err)
+		return ErrAccountQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, as)
+}
+
+func getAccount(c echo.Context) (Account, error) {
+	a := Account{}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return a, ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = scanAccount(db.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM accounts WHERE id = $1", id), &a)
+	if err == sql.ErrNoRows {
+		return a, ErrAccountNotFound.WithDetailKey("detail.account.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan account error: ", err)
+		return a, ErrAccountQuery.Wrap(err)
+	}
+	return a, nil
+}
+
+func GetAccountHandler(c echo.Context) error {
+	a, err := getAccount(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+func UpdateAccountHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	a, err := bindAccount(c)
+	if err != nil {
+		return err
+	}
+	a.ID = id
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "UPDATE accounts SET name = $2, type = $3, currency = $4, opening_balance = $5 WHERE id = $1",
+		id, a.Name, a.Type, a.Currency, a.OpeningBalance)
+	if err != nil {
+		c.Logger().Error("update account error: ", err)
+		return accountWriteError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrAccountUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrAccountNotFound.WithDetailKey("detail.account.notFound", i18n.Params{"id": id})
+	}
+
+	return c.JSON(http.StatusOK, a)
+}
+
+// DeleteAccountHandler refuses to delete an account with transactions, as
+// that would rewrite their history.
+func DeleteAccountHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM accounts WHERE id = $1", id)
+	if pqErrorCode(err) == foreignKeyViolation {
+		return ErrAccountInUse.WithDetailKey("detail.account.inUse", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("delete account error: ", err)
+		return ErrAccountUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrAccountUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrAccountNotFound.WithDetailKey("detail.account.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AccountBalance is the balance of an account through To, with how it moved
+// on each day with transactions from From on.
+type AccountBalance struct {
+	AccountID int                 `json:"account_id"`
+	Currency  string              `json:"currency"`
+	Opening   float64             `json:"opening_balance"`
+	Balance   float64             `json:"balance"`
+	Days      []AccountBalanceDay `json:"days"`
+}
+
+// AccountBalanceDay splits the money that left the account, as expenses and
+// transfers out, from the money that came in.
+type AccountBalanceDay struct {
+	Date     string  `json:"date"`
+	Spent    float64 `json:"spent"`
+	Received float64 `json:"received"`
+	Balance  float64 `json:"balance"`
+}
+
+// accountBalanceSQL sums what left and what came into an account by day. The
+// leg of a transfer on the receiving account is money in.
+const accountBalanceSQL = `SELECT to_char(e.spent_on, 'YYYY-MM-DD'),
+	COALESCE(SUM(e.amount) FILTER (WHERE e.kind = 'expense' OR t.from_account_id = e.account_id), 0),
+	COALESCE(SUM(e.amount) FILTER (WHERE e.kind = 'income' OR t.to_account_id = e.account_id), 0)
+	FROM expenses e LEFT JOIN transfers t ON t.id = e.transfer_id
+	WHERE e.account_id = $1 AND ($2::date IS NULL OR e.spent_on <= $2::date) GROUP BY e.spent_on ORDER BY e.spent_on`
+
+// GetAccountBalanceHandler derives the running balance of an account from
+// its transactions. ?to= gives the balance as it stood at the end of that day
+// and ?from= leaves out the days before it, though they still count towards
+// the balance.
+func GetAccountBalanceHandler(c echo.Context) error {
+	from, to := c.QueryParam("from"), c.QueryParam("to")
+	for _, day := range []string{from, to} {
+		if _, err := time.Parse(dateLayout, day); day != "" && err != nil {
+			return ErrInvalidRequest.WithDetailKey("detail.dateRange.format", nil).Wrap(err)
+		}
+	}
+
+	a, err := getAccount(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, accountBalanceSQL, a.ID, nullIfEmpty(to))
+	if err != nil {
+		c.Logger().Error("query account balance error: ", err)
+		return ErrAccountQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	b := AccountBalance{AccountID: a.ID, Currency: a.Currency, Opening: a.OpeningBalance, Days: []AccountBalanceDay{}}
+	balance := cents(a.OpeningBalance)
+	for rows.Next() {
+		d := AccountBalanceDay{}
+		if err = rows.Scan(&d.Date, &d.Spent, &d.Received); err != nil {
+			c.Logger().Error("scan account balance error: ", err)
+			return ErrAccountQuery.Wrap(err)
+		}
+		balance += cents(d.Received) - cents(d.Spent)
+		if d.Date < from {
+			continue
+		}
+		d.Spent = float64(cents(d.Spent)) / 100
+		d.Received = float64(cents(d.Received)) / 100
+		d.Balance = float64(balance) / 100
+		b.Days = append(b.Days, d)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate account balance error: ", err)
+		return ErrAccountQuery.Wrap(err)
+	}
+	b.Balance = float64(balance) / 100
+
+	return c.JSON(http.StatusOK, b)
+}
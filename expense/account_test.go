@@ -0,0 +1,159 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+var accountRowColumns = []string{"id", "name", "type", "currency", "opening_balance"}
+
+func TestAccountValidate(t *testing.T) {
+	a := Account{Type: "bank", Currency: "BAHT"}
+
+	err := a.Validate()
+
+	var pe *problem.Error
+	if assert.ErrorAs(t, err, &pe) {
+		var got []string
+		for _, f := range pe.Fields {
+			got = append(got, f.Pointer+" "+f.Rule)
+		}
+		assert.Equal(t, []string{"/name required", "/type enum", "/currency pattern"}, got)
+	}
+}
+
+func TestCreateAccountHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/accounts", strings.NewReader(`{"name":" wallet ","type":"cash","currency":"thb","opening_balance":500}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO accounts (name, type, currency, opening_balance) VALUES ($1, $2, $3, $4) RETURNING id")).
+		WithArgs("wallet", "cash", "THB", 500.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	err = CreateAccountHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"name":"wallet","type":"cash","currency":"THB","opening_balance":500}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteAccountHandlerInUse(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "/accounts/1", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM accounts WHERE id = $1")).WithArgs(1).WillReturnError(&pq.Error{Code: foreignKeyViolation})
+
+	err = DeleteAccountHandler(c)
+
+	assert.ErrorIs(t, err, ErrAccountInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAccountBalanceHandler(t *testing.T) {
+	t.Run("Test case for a running balance", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/accounts/1/balance?from=2023-01-10&to=2023-01-31", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + accountColumns + " FROM accounts WHERE id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(accountRowColumns).AddRow(1, "wallet", "cash", "THB", 1000.0))
+		mock.ExpectQuery(regexp.QuoteMeta(accountBalanceSQL)).WithArgs(1, "2023-01-31").
+			WillReturnRows(sqlmock.NewRows([]string{"date", "spent", "received"}).
+				AddRow("2023-01-05", 100.1, 0.0).
+				AddRow("2023-01-12", 200.2, 50.0).
+				AddRow("2023-01-20", 0.3, 0.0))
+
+		err = GetAccountBalanceHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `{"account_id":1,"currency":"THB","opening_balance":1000,"balance":749.4,`+
+				`"days":[{"date":"2023-01-12","spent":200.2,"received":50,"balance":749.7},{"date":"2023-01-20","spent":0.3,"received":0,"balance":749.4}]}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a date that is not YYYY-MM-DD", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/accounts/1/balance?to=31-01-2023", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		err := GetAccountBalanceHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestGetExpensesHandlerFiltersByAccount(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/expenses?account=wallet", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+expenseColumns+" FROM expenses WHERE account_id IN (SELECT id FROM accounts WHERE CASE WHEN $1 ~ '^[0-9]+$' THEN id::text = $1 ELSE lower(name) = lower($1) END) AND kind = $2")).
+		WithArgs("wallet", KindExpense).
+		WillReturnRows(sqlmock.NewRows(expenseRowColumns).AddRow(1, "noodles", 60.0, "lunch", pq.Array([]string{"food"}), nil, "2023-01-15", nil, nil, 1))
+
+	err = GetExpensesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `[{"id":1,"title":"noodles","amount":60,"note":"lunch","tags":["food"],"date":"2023-01-15","account_id":1}]`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateExpenseHandlerWithUnknownAccount(t *testing.T) {
+	err := expenseWriteError(&pq.Error{Code: foreignKeyViolation, Constraint: "expenses_account_id_fkey"}, ErrCreate)
+
+	var pe *problem.Error
+	if assert.ErrorAs(t, err, &pe) {
+		assert.Equal(t, "/account_id", pe.Fields[0].Pointer)
+		assert.Equal(t, "expense.account_id.exists", pe.Fields[0].Key)
+	}
+}
@@ -0,0 +1,229 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+)
+
+const AlertEventBudgetThreshold = "budget.threshold_crossed"
+
+// Alert records that spending in a budget period reached one of the budget's
+// thresholds. It is stored once per budget, threshold and period.
+type Alert struct {
+	ID          int        `json:"id"`
+	BudgetID    int        `json:"budget_id"`
+	Threshold   float64    `json:"threshold"`
+	PeriodStart string     `json:"period_start"`
+	PeriodEnd   string     `json:"period_end"`
+	Spent       float64    `json:"spent"`
+	Amount      float64    `json:"amount"`
+	PercentUsed float64    `json:"percent_used"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+type AlertNotifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+var (
+	alertNotifier AlertNotifier
+	alertTimeout  = 5 * time.Second
+)
+
+// SetAlertWebhook makes new alerts be posted to the configured webhook URL.
+func SetAlertWebhook(cfg config.Alerts) {
+	alertTimeout = cfg.Timeout
+	if cfg.WebhookURL == "" {
+		alertNotifier = nil
+		return
+	}
+	alertNotifier = &webhookNotifier{url: cfg.WebhookURL, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, a Alert) error {
+	b, err := json.Marshal(map[string]interface{}{"event": AlertEventBudgetThreshold, "alert": a})
+	if err != nil {
+		return err
+	}
+
+	return postJSON(ctx, n.client, n.url, b, nil)
+}
+
+// matchingBudgetsSQL selects the budgets with thresholds that count an
+// expense with tags $1 in category $2, including budgets on an ancestor
+// category.
+const matchingBudgetsSQL = `SELECT ` + budgetColumns + ` FROM budgets WHERE cardinality(thresholds) > 0 AND (
+	scope = 'all'
+	OR (scope = 'tag' AND tag = ANY($1))
+	OR (scope = 'category' AND category_id IN (WITH RECURSIVE up AS (
+		SELECT id, parent_id FROM categories WHERE id = $2
+		UNION
+		SELECT c.id, c.parent_id FROM categories c JOIN up u ON c.id = u.parent_id
+	) SELECT id FROM up))
+)`
+
+// checkBudgetAlerts evaluates the thresholds of every budget counting e in the
+// period of e's date. Crossed thresholds are stored along with an outbox
+// event, which the dispatcher delivers through alertSink.
+func checkBudgetAlerts(ctx context.Context, e Expense) error {
+	day, err := time.Parse(dateLayout, e.Date)
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, matchingBudgetsSQL, pq.Array(e.Tags), e.CategoryID)
+	if err != nil {
+		return err
+	}
+	var bs []Budget
+	for rows.Next() {
+		b := Budget{}
+		if err = scanBudget(rows, &b); err != nil {
+			rows.Close()
+			return err
+		}
+		bs = append(bs, b)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, b := range bs {
+		if start, _ := time.Parse(dateLayout, b.Start); day.Before(start) {
+			continue
+		}
+		if _, end := b.period(day); day.After(end) {
+			continue
+		}
+
+		st, err := budgetStatus(ctx, b, day, "")
+		if err != nil {
+			return err
+		}
+		sort.Float64s(b.Thresholds)
+		for _, threshold := range b.Thresholds {
+			if st.PercentUsed < threshold {
+				break
+			}
+			if _, err := recordAlert(ctx, st, threshold); err != nil && err != sql.ErrNoRows {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recordAlert stores an alert and the event delivering it, unless one
+// already exists for the threshold in this period, in which case it returns
+// sql.ErrNoRows.
+func recordAlert(ctx context.Context, st BudgetStatus, threshold float64) (Alert, error) {
+	a := Alert{
+		BudgetID:    st.BudgetID,
+		Threshold:   threshold,
+		PeriodStart: st.PeriodStart,
+		PeriodEnd:   st.PeriodEnd,
+		Spent:       st.Spent,
+		Amount:      st.Amount,
+		PercentUsed: st.PercentUsed,
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO budget_alerts (budget_id, threshold, period_start, period_end, spent, amount, percent_used)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT (budget_id, threshold, period_start) DO NOTHING RETURNING id, created_at`,
+		a.BudgetID, a.Threshold, a.PeriodStart, a.PeriodEnd, a.Spent, a.Amount, a.PercentUsed).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	if err = enqueueEvent(ctx, tx, AlertEventBudgetThreshold, a); err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// alertSink posts the budget alerts in the outbox to the alerts webhook and
+// marks them delivered. The dispatcher retries an alert the webhook rejects.
+// Without a webhook URL alerts are only stored.
+type alertSink struct{}
+
+func (alertSink) Publish(ctx context.Context, m OutboxMessage) error {
+	if m.Type != AlertEventBudgetThreshold || alertNotifier == nil {
+		return nil
+	}
+	var ev struct {
+		Data Alert `json:"data"`
+	}
+	if err := json.Unmarshal(m.Payload, &ev); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	return deliverAlert(ctx, ev.Data)
+}
+
+func (alertSink) Close() error {
+	return nil
+}
+
+func deliverAlert(ctx context.Context, a Alert) error {
+	if err := alertNotifier.Notify(ctx, a); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "UPDATE budget_alerts SET delivered_at = now() WHERE id = $1", a.ID)
+	return err
+}
+
+func GetBudgetAlertsHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, `SELECT id, budget_id, threshold, to_char(period_start, 'YYYY-MM-DD'), to_char(period_end, 'YYYY-MM-DD'),
+		spent, amount, percent_used, created_at, delivered_at FROM budget_alerts WHERE budget_id = $1 ORDER BY id`, id)
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrBudgetQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	as := []Alert{}
+	for rows.Next() {
+		a := Alert{}
+		err = rows.Scan(&a.ID, &a.BudgetID, &a.Threshold, &a.PeriodStart, &a.PeriodEnd, &a.Spent, &a.Amount, &a.PercentUsed, &a.CreatedAt, &a.DeliveredAt)
+		if err != nil {
+			c.Logger().Error("scan alert error: ", err)
+			return ErrBudgetQuery.Wrap(err)
+		}
+		as = append(as, a)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate alerts error: ", err)
+		return ErrBudgetQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, as)
+}
@@ -0,0 +1,142 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBudgetAlerts(t *testing.T) {
+	budgetRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "scope", "tag", "category_id", "amount", "period", "start", "end", "rollover", "thresholds"}).
+			AddRow(1, "food", ScopeTag, "food", nil, 5000.0, PeriodMonthly, "2023-01-01", "", false, "{100,80}")
+	}
+	spentSQL := "SELECT COALESCE(SUM(amount), 0) FROM expenses WHERE tags @> ARRAY[$1] AND spent_on >= $2::date AND spent_on <= $3::date AND kind = $4"
+	insertSQL := "INSERT INTO budget_alerts"
+	e := Expense{ID: 7, Title: "lunch", Amount: 500, Tags: []string{"food"}, Date: "2023-04-15"}
+
+	t.Run("Test case for recording a crossed threshold", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(matchingBudgetsSQL)).WithArgs(pq.Array([]string{"food"}), nil).WillReturnRows(budgetRows())
+		mock.ExpectQuery(regexp.QuoteMeta(spentSQL)).WithArgs("food", "2023-04-01", "2023-04-30", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(4500.0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WithArgs(1, 80.0, "2023-04-01", "2023-04-30", 4500.0, 5000.0, 90.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Now()))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WithArgs(sqlmock.AnyArg(), AlertEventBudgetThreshold, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = checkBudgetAlerts(context.Background(), e)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a threshold already alerted this period", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(matchingBudgetsSQL)).WithArgs(pq.Array([]string{"food"}), nil).WillReturnRows(budgetRows())
+		mock.ExpectQuery(regexp.QuoteMeta(spentSQL)).WithArgs("food", "2023-04-01", "2023-04-30", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(5200.0))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WithArgs(1, 80.0, "2023-04-01", "2023-04-30", 5200.0, 5000.0, 104.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WithArgs(1, 100.0, "2023-04-01", "2023-04-30", 5200.0, 5000.0, 104.0).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(4, time.Now()))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WithArgs(sqlmock.AnyArg(), AlertEventBudgetThreshold, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = checkBudgetAlerts(context.Background(), e)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an expense before the budget starts", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(matchingBudgetsSQL)).WillReturnRows(budgetRows())
+
+		err = checkBudgetAlerts(context.Background(), Expense{Tags: []string{"food"}, Date: "2022-12-31"})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeliverAlert(t *testing.T) {
+	defer func() { alertNotifier = nil }()
+
+	var got struct {
+		Event string `json:"event"`
+		Alert Alert  `json:"alert"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	alertNotifier = &webhookNotifier{url: srv.URL, client: srv.Client()}
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE budget_alerts SET delivered_at = now() WHERE id = $1")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	payload, _ := json.Marshal(Event{Type: AlertEventBudgetThreshold, Data: Alert{ID: 3, BudgetID: 1, Threshold: 80, PeriodStart: "2023-04-01", PeriodEnd: "2023-04-30"}})
+
+	err = alertSink{}.Publish(context.Background(), OutboxMessage{ID: 1, Type: AlertEventBudgetThreshold, Payload: payload})
+
+	assert.NoError(t, err)
+	assert.Equal(t, AlertEventBudgetThreshold, got.Event)
+	assert.Equal(t, 80.0, got.Alert.Threshold)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	t.Run("Test case for an event that is not an alert", func(t *testing.T) {
+		err := alertSink{}.Publish(context.Background(), OutboxMessage{ID: 2, Type: EventExpenseCreated, Payload: []byte(`{}`)})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("Test case for a webhook rejecting the alert", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer srv.Close()
+		alertNotifier = &webhookNotifier{url: srv.URL, client: srv.Client()}
+
+		err := deliverAlert(context.Background(), Alert{ID: 3})
+
+		assert.EqualError(t, err, "webhook responded with status 502")
+	})
+}
@@ -0,0 +1,289 @@
+package expense
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+// multipartOverhead is allowed on top of the maximum attachment size for the
+// boundaries and part headers of an upload.
+const multipartOverhead = 1 << 20
+
+// Attachment describes a file attached to an expense, usually a receipt.
+// Checksum is the hex SHA-256 of the contents.
+type Attachment struct {
+	ID          int       `json:"id"`
+	ExpenseID   int       `json:"expense_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+	CreatedAt   time.Time `json:"created_at"`
+	key         string
+}
+
+const attachmentColumns = "id, expense_id, filename, content_type, size, checksum, created_at, storage_key"
+
+func scanAttachment(s scanner, a *Attachment) error {
+	return s.Scan(&a.ID, &a.ExpenseID, &a.Filename, &a.ContentType, &a.Size, &a.Checksum, &a.CreatedAt, &a.key)
+}
+
+var (
+	blobs             BlobStore
+	attachmentLimits  = config.Default().Attachments
+	attachmentTimeout = time.Minute
+)
+
+// SetAttachmentStore opens the blob store and applies the upload limits.
+func SetAttachmentStore(cfg config.Attachments) error {
+	s, err := NewBlobStore(cfg)
+	if err != nil {
+		return err
+	}
+	blobs = s
+	attachmentLimits = cfg
+	return nil
+}
+
+func newBlobKey(expenseID int) string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "expenses/" + strconv.Itoa(expenseID) + "/" + hex.EncodeToString(b)
+}
+
+func allowedContentType(t string) bool {
+	for _, allowed := range attachmentLimits.AllowedContentTypes() {
+		if t == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAttachmentHandler stores the "file" part of a multipart upload. The
+// content type is sniffed from the contents rather than trusted from the
+// client.
+func CreateAttachmentHandler(c echo.Context) error {
+	expenseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, int64(attachmentLimits.MaxSize)+multipartOverhead)
+	fh, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrAttachmentTooLarge.WithDetailKey("detail.attachment.tooLarge", i18n.Params{"max": attachmentLimits.MaxSize}).Wrap(err)
+	} else if err != nil {
+		return ErrInvalidRequest.WithDetailKey("detail.file.required", nil).Wrap(err)
+	}
+	if fh.Size > int64(attachmentLimits.MaxSize) {
+		return ErrAttachmentTooLarge.WithDetailKey("detail.attachment.tooLarge", i18n.Params{"max": attachmentLimits.MaxSize})
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ErrInvalidRequest.Wrap(err)
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !allowedContentType(contentType) {
+		return ErrAttachmentType.WithDetailKey("detail.attachment.type", i18n.Params{"type": contentType})
+	}
+
+	a := Attachment{ExpenseID: expenseID, Filename: filepath.Base(fh.Filename), ContentType: contentType, Size: fh.Size, key: newBlobKey(expenseID)}
+
+	ctx, cancel := context.WithTimeout(req.Context(), attachmentTimeout)
+	defer cancel()
+
+	sum := sha256.New()
+	body := io.TeeReader(io.MultiReader(bytes.NewReader(head), f), sum)
+	if err = blobs.Put(ctx, a.key, body, a.Size, a.ContentType); err != nil {
+		c.Logger().Error("store attachment error: ", err)
+		return ErrAttachmentUpdate.Wrap(err)
+	}
+	a.Checksum = hex.EncodeToString(sum.Sum(nil))
+
+	err = db.QueryRowContext(ctx, "INSERT INTO attachments (expense_id, filename, content_type, size, checksum, storage_key) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at",
+		a.ExpenseID, a.Filename, a.ContentType, a.Size, a.Checksum, a.key).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		deleteBlobs(ctx, c.Logger(), a.key)
+		if pqErrorCode(err) == foreignKeyViolation {
+			return ErrNotFound.WithDetailKey("detail.expense.notFound", i18n.Params{"id": expenseID}).Wrap(err)
+		}
+		c.Logger().Error("insert attachment error: ", err)
+		return ErrAttachmentUpdate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, a)
+}
+
+// expenseAttachments lists the attachments of an expense, oldest first.
+func expenseAttachments(ctx context.Context, expenseID int) ([]Attachment, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE expense_id = $1 ORDER BY id", expenseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	as := []Attachment{}
+	for rows.Next() {
+		a := Attachment{}
+		if err = scanAttachment(rows, &a); err != nil {
+			return nil, err
+		}
+		as = append(as, a)
+	}
+	return as, rows.Err()
+}
+
+func GetAttachmentsHandler(c echo.Context) error {
+	expenseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	var exists bool
+	if err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM expenses WHERE id = $1)", expenseID).Scan(&exists); err != nil {
+		c.Logger().Error("query expense error: ", err)
+		return ErrAttachmentQuery.Wrap(err)
+	} else if !exists {
+		return ErrNotFound.WithDetailKey("detail.expense.notFound", i18n.Params{"id": expenseID})
+	}
+
+	as, err := expenseAttachments(ctx, expenseID)
+	if err != nil {
+		c.Logger().Error("query attachments error: ", err)
+		return ErrAttachmentQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, as)
+}
+
+func attachmentParams(c echo.Context) (int, int, error) {
+	expenseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, 0, ErrInvalidRequest.Wrap(err)
+	}
+	id, err := strconv.Atoi(c.Param("attachment_id"))
+	if err != nil {
+		return 0, 0, ErrInvalidRequest.Wrap(err)
+	}
+	return expenseID, id, nil
+}
+
+// GetAttachmentHandler sends the contents of an attachment.
+func GetAttachmentHandler(c echo.Context) error {
+	expenseID, id, err := attachmentParams(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), attachmentTimeout)
+	defer cancel()
+
+	a := Attachment{}
+	err = scanAttachment(db.QueryRowContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id = $1 AND expense_id = $2", id, expenseID), &a)
+	if err == sql.ErrNoRows {
+		return ErrAttachmentNotFound.WithDetailKey("detail.attachment.notFound", i18n.Params{"expense": expenseID, "id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan attachment error: ", err)
+		return ErrAttachmentQuery.Wrap(err)
+	}
+
+	r, err := blobs.Get(ctx, a.key)
+	if errors.Is(err, ErrBlobNotFound) {
+		return ErrAttachmentNotFound.WithDetailKey("detail.attachment.contentMissing", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("read attachment error: ", err)
+		return ErrAttachmentQuery.Wrap(err)
+	}
+	defer r.Close()
+
+	h := c.Response().Header()
+	h.Set(echo.HeaderContentLength, strconv.FormatInt(a.Size, 10))
+	h.Set(echo.HeaderContentDisposition, mime.FormatMediaType("inline", map[string]string{"filename": a.Filename}))
+	h.Set("ETag", strconv.Quote(a.Checksum))
+	return c.Stream(http.StatusOK, a.ContentType, r)
+}
+
+func DeleteAttachmentHandler(c echo.Context) error {
+	expenseID, id, err := attachmentParams(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	var key string
+	err = db.QueryRowContext(ctx, "DELETE FROM attachments WHERE id = $1 AND expense_id = $2 RETURNING storage_key", id, expenseID).Scan(&key)
+	if err == sql.ErrNoRows {
+		return ErrAttachmentNotFound.WithDetailKey("detail.attachment.notFound", i18n.Params{"expense": expenseID, "id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("delete attachment error: ", err)
+		return ErrAttachmentUpdate.Wrap(err)
+	}
+
+	deleteBlobs(ctx, c.Logger(), key)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// attachmentKeys returns the blobs of the attachments on the expenses where
+// matches, with arg as $1. The rows go with the expenses when they are
+// deleted; the blobs are removed after the commit.
+func attachmentKeys(ctx context.Context, tx *sql.Tx, where string, arg interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT storage_key FROM attachments WHERE expense_id IN (SELECT id FROM expenses WHERE "+where+")", arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// deleteBlobs removes attachment contents that no row refers to. A failure
+// only leaves an orphaned blob, so it is logged rather than returned.
+func deleteBlobs(ctx context.Context, logger echo.Logger, keys ...string) {
+	for _, key := range keys {
+		if err := blobs.Delete(ctx, key); err != nil {
+			logger.Error("delete attachment blob error: ", err)
+		}
+	}
+}
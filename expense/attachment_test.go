@@ -0,0 +1,171 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// pngHeader is enough of a PNG for content sniffing.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+var attachmentRowColumns = []string{"id", "expense_id", "filename", "content_type", "size", "checksum", "created_at", "storage_key"}
+
+func uploadContext(t *testing.T, filename string, content []byte) (echo.Context, *httptest.ResponseRecorder) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/expenses/1/attachments", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	return c, rec
+}
+
+func TestCreateAttachmentHandler(t *testing.T) {
+	insertSQL := "INSERT INTO attachments (expense_id, filename, content_type, size, checksum, storage_key)"
+	sum := sha256.Sum256(pngHeader)
+	checksum := hex.EncodeToString(sum[:])
+
+	t.Run("Test case for uploading a receipt", func(t *testing.T) {
+		store := &localStore{dir: t.TempDir()}
+		blobs = store
+		c, rec := uploadContext(t, "receipt.png", pngHeader)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).
+			WithArgs(1, "receipt.png", "image/png", int64(len(pngHeader)), checksum, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, time.Date(2023, 1, 15, 9, 0, 0, 0, time.UTC)))
+
+		err = CreateAttachmentHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, `{"id":3,"expense_id":1,"filename":"receipt.png","content_type":"image/png","size":16,"checksum":"`+checksum+`","created_at":"2023-01-15T09:00:00Z"}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a disallowed content type", func(t *testing.T) {
+		c, _ := uploadContext(t, "receipt.png", []byte("just some text"))
+
+		err := CreateAttachmentHandler(c)
+
+		assert.ErrorIs(t, err, ErrAttachmentType)
+		assert.EqualError(t, err, "text/plain attachments are not allowed")
+	})
+
+	t.Run("Test case for a file over the size limit", func(t *testing.T) {
+		defer func(max int) { attachmentLimits.MaxSize = max }(attachmentLimits.MaxSize)
+		attachmentLimits.MaxSize = 10
+		c, _ := uploadContext(t, "receipt.png", pngHeader)
+
+		err := CreateAttachmentHandler(c)
+
+		assert.ErrorIs(t, err, ErrAttachmentTooLarge)
+	})
+
+	t.Run("Test case for a missing expense", func(t *testing.T) {
+		store := &localStore{dir: t.TempDir()}
+		blobs = store
+		c, _ := uploadContext(t, "receipt.png", pngHeader)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WillReturnError(&pq.Error{Code: foreignKeyViolation})
+
+		err = CreateAttachmentHandler(c)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestGetAttachmentHandler(t *testing.T) {
+	store := &localStore{dir: t.TempDir()}
+	blobs = store
+	assert.NoError(t, store.Put(context.Background(), "expenses/1/abc", bytes.NewReader(pngHeader), int64(len(pngHeader)), "image/png"))
+
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1/attachments/3", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id", "attachment_id")
+	c.SetParamValues("1", "3")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT "+attachmentColumns+" FROM attachments WHERE id = $1 AND expense_id = $2")).WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(attachmentRowColumns).AddRow(3, 1, "receipt.png", "image/png", len(pngHeader), "abc123", time.Now(), "expenses/1/abc"))
+
+	err = GetAttachmentHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `inline; filename=receipt.png`, rec.Header().Get(echo.HeaderContentDisposition))
+		assert.Equal(t, `"abc123"`, rec.Header().Get("ETag"))
+		assert.Equal(t, pngHeader, rec.Body.Bytes())
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetExpenseHandlerEmbedsAttachments(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/expenses/1?embed=attachments", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectPrepare(regexp.QuoteMeta("SELECT " + expenseColumns + " FROM expenses WHERE id = $1 AND kind = 'expense'")).ExpectQuery().WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).AddRow(1, "title", 100.0, "note", "{tag1}", nil, "2023-01-15", nil, nil, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + attachmentColumns + " FROM attachments WHERE expense_id = ANY($1) ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows(attachmentRowColumns).AddRow(3, 1, "receipt.png", "image/png", 20, "abc123", time.Date(2023, 1, 15, 9, 0, 0, 0, time.UTC), "expenses/1/abc"))
+
+	err = GetExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["tag1"],"date":"2023-01-15","attachments":[{"id":3,"expense_id":1,"filename":"receipt.png","content_type":"image/png","size":20,"checksum":"abc123","created_at":"2023-01-15T09:00:00Z"}]}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
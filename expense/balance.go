@@ -0,0 +1,264 @@
+package expense
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+// Settlement records money paid back from one participant to another.
+type Settlement struct {
+	ID     int     `json:"id"`
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+	Date   string  `json:"date,omitempty"`
+	Note   string  `json:"note,omitempty"`
+}
+
+func (s *Settlement) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "settlement."+field+"."+rule, nil))
+	}
+
+	if s.From == "" {
+		add("/from", "from", "required")
+	}
+	switch {
+	case s.To == "":
+		add("/to", "to", "required")
+	case s.To == s.From:
+		add("/to", "to", "distinct")
+	}
+	if cents(s.Amount) <= 0 {
+		add("/amount", "amount", "exclusiveMinimum")
+	}
+	if s.Date != "" {
+		if _, err := time.Parse(dateLayout, s.Date); err != nil {
+			add("/date", "date", "format")
+		}
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidSettlement.WithFields(fields...)
+	}
+	return nil
+}
+
+func CreateSettlementHandler(c echo.Context) error {
+	s := Settlement{}
+	if err := c.Bind(&s); err != nil {
+		c.Logger().Error("invalid request binding to struct settlement error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	s.From = strings.TrimSpace(s.From)
+	s.To = strings.TrimSpace(s.To)
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err := db.QueryRowContext(ctx, "INSERT INTO settlements (payer, payee, amount, settled_on, note) VALUES ($1, $2, $3, COALESCE($4::date, CURRENT_DATE), $5) RETURNING id, to_char(settled_on, 'YYYY-MM-DD')",
+		s.From, s.To, s.Amount, nullIfEmpty(s.Date), s.Note).Scan(&s.ID, &s.Date)
+	if err != nil {
+		c.Logger().Error("insert settlement error: ", err)
+		return ErrSettlementUpdate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, s)
+}
+
+// Balance is what a participant is owed, or owes when negative, once every
+// split expense and settlement is counted.
+type Balance struct {
+	Participant string  `json:"participant"`
+	Balance     float64 `json:"balance"`
+}
+
+type Transfer struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Amount float64 `json:"amount"`
+}
+
+type Balances struct {
+	Balances  []Balance  `json:"balances"`
+	Transfers []Transfer `json:"transfers"`
+}
+
+// balancesSQL credits the payer of a split expense with every share and
+// debits each participant with their own, then applies settlements.
+const balancesSQL = `SELECT participant, SUM(amount) FROM (
+	SELECT split->>'paid_by', (s->>'amount')::float FROM expenses, jsonb_array_elements(split->'shares') s WHERE split IS NOT NULL
+	UNION ALL
+	SELECT s->>'participant', -(s->>'amount')::float FROM expenses, jsonb_array_elements(split->'shares') s WHERE split IS NOT NULL
+	UNION ALL
+	SELECT payer, amount FROM settlements
+	UNION ALL
+	SELECT payee, -amount FROM settlements
+) AS ledger (participant, amount) GROUP BY participant ORDER BY participant`
+
+// GetBalancesHandler reports the balance of every participant together with
+// the fewest transfers that settle them all.
+func GetBalancesHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, balancesSQL)
+	if err != nil {
+		c.Logger().Error("query balances error: ", err)
+		return ErrBalanceQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	bs := Balances{Balances: []Balance{}}
+	for rows.Next() {
+		b := Balance{}
+		if err = rows.Scan(&b.Participant, &b.Balance); err != nil {
+			c.Logger().Error("scan balance error: ", err)
+			return ErrBalanceQuery.Wrap(err)
+		}
+		b.Balance = float64(cents(b.Balance)) / 100
+		bs.Balances = append(bs.Balances, b)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate balances error: ", err)
+		return ErrBalanceQuery.Wrap(err)
+	}
+
+	bs.Transfers = simplifyDebts(bs.Balances)
+	return c.JSON(http.StatusOK, bs)
+}
+
+// maxExactParticipants bounds the search in simplifyDebts; it is exponential
+// in the number of participants with a balance.
+const maxExactParticipants = 16
+
+// simplifyDebts settles balances with as few transfers as possible. Every
+// group of participants whose balances add up to zero can settle among
+// itself in one transfer fewer than its size, so the participants are
+// partitioned into as many such groups as possible and each group is settled
+// greedily, largest debtor paying largest creditor. Beyond
+// maxExactParticipants everyone is settled as a single group, which still
+// needs at most one transfer fewer than there are participants.
+func simplifyDebts(balances []Balance) []Transfer {
+	var names []string
+	var amounts []int64
+	for _, b := range balances {
+		if c := cents(b.Balance); c != 0 {
+			names = append(names, b.Participant)
+			amounts = append(amounts, c)
+		}
+	}
+
+	var groups [][]int
+	if len(amounts) <= maxExactParticipants {
+		groups = zeroSumGroups(amounts)
+	} else {
+		all := make([]int, len(amounts))
+		for i := range all {
+			all[i] = i
+		}
+		groups = append(groups, all)
+	}
+
+	transfers := []Transfer{}
+	for _, group := range groups {
+		var creditors, debtors []int
+		left := map[int]int64{}
+		for _, i := range group {
+			left[i] = amounts[i]
+			if amounts[i] > 0 {
+				creditors = append(creditors, i)
+			} else {
+				debtors = append(debtors, i)
+			}
+		}
+		sort.SliceStable(creditors, func(a, b int) bool { return amounts[creditors[a]] > amounts[creditors[b]] })
+		sort.SliceStable(debtors, func(a, b int) bool { return amounts[debtors[a]] < amounts[debtors[b]] })
+
+		for ci, di := 0, 0; ci < len(creditors) && di < len(debtors); {
+			cr, de := creditors[ci], debtors[di]
+			amount := left[cr]
+			if -left[de] < amount {
+				amount = -left[de]
+			}
+			transfers = append(transfers, Transfer{From: names[de], To: names[cr], Amount: float64(amount) / 100})
+			left[cr] -= amount
+			left[de] += amount
+			if left[cr] == 0 {
+				ci++
+			}
+			if left[de] == 0 {
+				di++
+			}
+		}
+	}
+	sort.SliceStable(transfers, func(a, b int) bool {
+		if transfers[a].From != transfers[b].From {
+			return transfers[a].From < transfers[b].From
+		}
+		return transfers[a].To < transfers[b].To
+	})
+	return transfers
+}
+
+// zeroSumGroups partitions the indexes of amounts into as many groups adding
+// up to zero as possible. Any remainder that does not add up to zero ends up
+// in the last group.
+func zeroSumGroups(amounts []int64) [][]int {
+	n := len(amounts)
+	if n == 0 {
+		return nil
+	}
+	full := 1<<n - 1
+	sum := make([]int64, full+1)
+	most := make([]int, full+1)
+	last := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := 0
+		for mask&(1<<low) == 0 {
+			low++
+		}
+		sum[mask] = sum[mask&(mask-1)] + amounts[low]
+
+		most[mask] = -1
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && most[mask^(1<<i)] > most[mask] {
+				most[mask], last[mask] = most[mask^(1<<i)], i
+			}
+		}
+		if sum[mask] == 0 {
+			most[mask]++
+		}
+	}
+
+	// Walking back from the full set gives the order in which participants
+	// join; the set closes a group whenever it adds up to zero.
+	order := make([]int, 0, n)
+	for mask := full; mask != 0; mask ^= 1 << last[mask] {
+		order = append(order, last[mask])
+	}
+	var groups [][]int
+	var group []int
+	var running int64
+	for i := len(order) - 1; i >= 0; i-- {
+		group = append(group, order[i])
+		running += amounts[order[i]]
+		if running == 0 {
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
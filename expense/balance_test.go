@@ -0,0 +1,137 @@
+//go:build unit
+
+package expense
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimplifyDebts(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances []Balance
+		want     []Transfer
+	}{
+		{
+			name:     "Test case for everyone settled",
+			balances: []Balance{{"alice", 0}, {"bob", 0}},
+			want:     []Transfer{},
+		},
+		{
+			name:     "Test case for one creditor",
+			balances: []Balance{{"alice", 60}, {"bob", -30}, {"carol", -30}},
+			want:     []Transfer{{"bob", "alice", 30}, {"carol", "alice", 30}},
+		},
+		{
+			name:     "Test case for pairs that settle among themselves",
+			balances: []Balance{{"alice", 50}, {"bob", 20}, {"carol", -20}, {"dave", -50}},
+			want:     []Transfer{{"carol", "bob", 20}, {"dave", "alice", 50}},
+		},
+		{
+			name:     "Test case for a chain of debts",
+			balances: []Balance{{"alice", 10.5}, {"bob", 0}, {"carol", -10.5}},
+			want:     []Transfer{{"carol", "alice", 10.5}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.ElementsMatch(t, test.want, simplifyDebts(test.balances))
+		})
+	}
+
+	t.Run("Test case for beating largest-first matching", func(t *testing.T) {
+		// Matching the largest creditor with the largest debtor takes four
+		// transfers here.
+		balances := []Balance{{"a", 5}, {"b", 4}, {"c", -4}, {"d", -3}, {"e", -2}}
+
+		assert.ElementsMatch(t, []Transfer{{"c", "b", 4}, {"d", "a", 3}, {"e", "a", 2}}, simplifyDebts(balances))
+	})
+}
+
+func TestGetBalancesHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/balances", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(balancesSQL)).WillReturnRows(sqlmock.NewRows([]string{"participant", "sum"}).
+		AddRow("alice", 60.000000001).AddRow("bob", -30.0).AddRow("carol", -30.0))
+
+	err = GetBalancesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"balances":[{"participant":"alice","balance":60},{"participant":"bob","balance":-30},{"participant":"carol","balance":-30}],"transfers":[{"from":"bob","to":"alice","amount":30},{"from":"carol","to":"alice","amount":30}]}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateSettlementHandler(t *testing.T) {
+	insertSQL := "INSERT INTO settlements (payer, payee, amount, settled_on, note)"
+
+	t.Run("Test case for recording a settlement", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/settlements", strings.NewReader(`{"from":"bob","to":"alice","amount":30}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WithArgs("bob", "alice", 30.0, nil, "").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-15"))
+
+		err = CreateSettlementHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, `{"id":1,"from":"bob","to":"alice","amount":30,"date":"2023-01-15"}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for paying oneself", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/settlements", strings.NewReader(`{"from":"bob","to":"bob","amount":0.001}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := CreateSettlementHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidSettlement)
+	})
+
+	t.Run("Test case for a failed insert", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/settlements", strings.NewReader(`{"from":"bob","to":"alice","amount":30}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(insertSQL)).WillReturnError(errors.New("database error"))
+
+		err = CreateSettlementHandler(c)
+
+		assert.ErrorIs(t, err, ErrSettlementUpdate)
+	})
+}
@@ -0,0 +1,104 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 stands in for MinIO, keeping objects in memory and checking that
+// requests are signed.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string
+	types   map[string]string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") ||
+		r.Header.Get("X-Amz-Content-Sha256") != unsignedPayload || r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = string(b)
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		b, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, b)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func testBlobStore(t *testing.T, s BlobStore) {
+	ctx := context.Background()
+
+	assert.NoError(t, s.Put(ctx, "expenses/1/abc", strings.NewReader("receipt"), 7, "image/png"))
+
+	r, err := s.Get(ctx, "expenses/1/abc")
+	if assert.NoError(t, err) {
+		b, _ := io.ReadAll(r)
+		r.Close()
+		assert.Equal(t, "receipt", string(b))
+	}
+
+	assert.NoError(t, s.Delete(ctx, "expenses/1/abc"))
+	_, err = s.Get(ctx, "expenses/1/abc")
+	assert.ErrorIs(t, err, ErrBlobNotFound)
+	assert.NoError(t, s.Delete(ctx, "expenses/1/abc"), "deleting a missing blob is not an error")
+}
+
+func TestLocalStore(t *testing.T) {
+	s, err := NewBlobStore(config.Attachments{Store: "local", Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBlobStore(t, s)
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: map[string]string{}, types: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	s, err := NewBlobStore(config.Attachments{Store: "s3", S3: config.S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "receipts", AccessKey: "minio", SecretKey: "minio123"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Test case for storing, reading and deleting an object", func(t *testing.T) {
+		assert.NoError(t, s.Put(context.Background(), "expenses/2/def", strings.NewReader("pdf"), 3, "application/pdf"))
+		assert.Equal(t, "application/pdf", fake.types["/receipts/expenses/2/def"])
+
+		testBlobStore(t, s)
+	})
+
+	t.Run("Test case for a rejected request", func(t *testing.T) {
+		bad, _ := NewBlobStore(config.Attachments{Store: "s3", S3: config.S3{Endpoint: srv.URL, Bucket: "receipts", AccessKey: "other", SecretKey: "x"}})
+
+		err := bad.Put(context.Background(), "expenses/3/ghi", strings.NewReader("x"), 1, "image/png")
+
+		assert.EqualError(t, err, "s3 PUT expenses/3/ghi responded with status 403: ")
+	})
+}
@@ -0,0 +1,285 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const dateLayout = "2006-01-02"
+
+const (
+	ScopeAll      = "all"
+	ScopeTag      = "tag"
+	ScopeCategory = "category"
+
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+	PeriodCustom  = "custom"
+)
+
+// Budget caps spending on a scope of expenses per period. Weekly periods run
+// Monday to Sunday, monthly periods follow the calendar and a custom budget
+// has a single period from Start to End. Start also marks when rollover
+// begins accumulating.
+type Budget struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Scope      string  `json:"scope"`
+	Tag        string  `json:"tag,omitempty"`
+	CategoryID *int    `json:"category_id,omitempty"`
+	Amount     float64 `json:"amount"`
+	Period     string  `json:"period"`
+	Start      string  `json:"start,omitempty"`
+	End        string  `json:"end,omitempty"`
+	Rollover   bool    `json:"rollover"`
+	// Thresholds are the percentages of the amount that raise an alert when
+	// reached. They default to 80 and 100.
+	Thresholds []float64 `json:"thresholds"`
+}
+
+const budgetColumns = "id, name, scope, COALESCE(tag, ''), category_id, amount, period, to_char(start_date, 'YYYY-MM-DD'), COALESCE(to_char(end_date, 'YYYY-MM-DD'), ''), rollover, thresholds"
+
+func scanBudget(s scanner, b *Budget) error {
+	return s.Scan(&b.ID, &b.Name, &b.Scope, &b.Tag, &b.CategoryID, &b.Amount, &b.Period, &b.Start, &b.End, &b.Rollover, pq.Array(&b.Thresholds))
+}
+
+func (b *Budget) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "budget."+field+"."+rule, params))
+	}
+
+	if b.Name == "" {
+		add("/name", "name", "required", nil)
+	}
+	if b.Amount <= 0 {
+		add("/amount", "amount", "exclusiveMinimum", nil)
+	}
+
+	switch b.Scope {
+	case ScopeAll:
+	case ScopeTag:
+		if b.Tag == "" {
+			add("/tag", "tag", "required", nil)
+		}
+	case ScopeCategory:
+		if b.CategoryID == nil {
+			add("/category_id", "category_id", "required", nil)
+		}
+	default:
+		add("/scope", "scope", "enum", nil)
+	}
+
+	for i, threshold := range b.Thresholds {
+		if threshold <= 0 {
+			add(fmt.Sprintf("/thresholds/%d", i), "thresholds", "exclusiveMinimum", nil)
+		}
+	}
+
+	var start, end time.Time
+	var err error
+	if b.Start != "" {
+		if start, err = time.Parse(dateLayout, b.Start); err != nil {
+			add("/start", "start", "format", nil)
+		}
+	}
+	if b.End != "" {
+		if end, err = time.Parse(dateLayout, b.End); err != nil {
+			add("/end", "end", "format", nil)
+		}
+	}
+
+	switch b.Period {
+	case PeriodWeekly, PeriodMonthly:
+	case PeriodCustom:
+		if b.Start == "" || b.End == "" {
+			add("/end", "period", "customRange", nil)
+		} else if end.Before(start) {
+			add("/end", "end", "beforeStart", nil)
+		}
+		if b.Rollover {
+			add("/rollover", "rollover", "custom", nil)
+		}
+	default:
+		add("/period", "period", "enum", nil)
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidBudget.WithFields(fields...)
+	}
+	return nil
+}
+
+// filter selects the expenses a budget counts, regardless of dates.
+func (b *Budget) filter() Filter {
+	switch b.Scope {
+	case ScopeTag:
+		return Filter{Tag: b.Tag}
+	case ScopeCategory:
+		return Filter{Category: strconv.Itoa(*b.CategoryID)}
+	}
+	return Filter{}
+}
+
+func bindBudget(c echo.Context) (Budget, error) {
+	b := Budget{}
+	if err := c.Bind(&b); err != nil {
+		c.Logger().Error("invalid request binding to struct budget error: ", err)
+		return b, ErrInvalidRequest.Wrap(err)
+	}
+	b.Name = strings.TrimSpace(b.Name)
+	b.Tag = NormalizeTag(b.Tag)
+	if b.Scope != ScopeTag {
+		b.Tag = ""
+	}
+	if b.Scope != ScopeCategory {
+		b.CategoryID = nil
+	}
+	if b.Thresholds == nil {
+		b.Thresholds = []float64{80, 100}
+	}
+	return b, b.Validate()
+}
+
+func budgetWriteError(err error, fallback *problem.Error) error {
+	if pqErrorCode(err) == foreignKeyViolation {
+		return ErrInvalidBudget.WithFields(problem.NewFieldError("body", "/category_id", "exists", "expense.category_id.exists", nil)).Wrap(err)
+	}
+	return fallback.Wrap(err)
+}
+
+func CreateBudgetHandler(c echo.Context) error {
+	b, err := bindBudget(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, `INSERT INTO budgets (name, scope, tag, category_id, amount, period, start_date, end_date, rollover, thresholds)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE($7::date, CURRENT_DATE), $8, $9, $10) RETURNING id, to_char(start_date, 'YYYY-MM-DD')`,
+		b.Name, b.Scope, nullIfEmpty(b.Tag), b.CategoryID, b.Amount, b.Period, nullIfEmpty(b.Start), nullIfEmpty(b.End), b.Rollover, pq.Array(b.Thresholds)).Scan(&b.ID, &b.Start)
+	if err != nil {
+		c.Logger().Error("insert budget error: ", err)
+		return budgetWriteError(err, ErrBudgetUpdate)
+	}
+
+	return c.JSON(http.StatusCreated, b)
+}
+
+func GetBudgetsHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+budgetColumns+" FROM budgets ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrBudgetQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	bs := []Budget{}
+	for rows.Next() {
+		b := Budget{}
+		if err = scanBudget(rows, &b); err != nil {
+			c.Logger().Error("scan budget error: ", err)
+			return ErrBudgetQuery.Wrap(err)
+		}
+		bs = append(bs, b)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate budgets error: ", err)
+		return ErrBudgetQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, bs)
+}
+
+func getBudget(c echo.Context) (Budget, error) {
+	b := Budget{}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return b, ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = scanBudget(db.QueryRowContext(ctx, "SELECT "+budgetColumns+" FROM budgets WHERE id = $1", id), &b)
+	if err == sql.ErrNoRows {
+		return b, ErrBudgetNotFound.WithDetailKey("detail.budget.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan budget error: ", err)
+		return b, ErrBudgetQuery.Wrap(err)
+	}
+	return b, nil
+}
+
+func GetBudgetHandler(c echo.Context) error {
+	b, err := getBudget(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, b)
+}
+
+func UpdateBudgetHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	b, err := bindBudget(c)
+	if err != nil {
+		return err
+	}
+	b.ID = id
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, `UPDATE budgets SET name = $2, scope = $3, tag = $4, category_id = $5, amount = $6, period = $7,
+		start_date = COALESCE($8::date, start_date), end_date = $9, rollover = $10, thresholds = $11 WHERE id = $1 RETURNING to_char(start_date, 'YYYY-MM-DD')`,
+		id, b.Name, b.Scope, nullIfEmpty(b.Tag), b.CategoryID, b.Amount, b.Period, nullIfEmpty(b.Start), nullIfEmpty(b.End), b.Rollover, pq.Array(b.Thresholds)).Scan(&b.Start)
+	if err == sql.ErrNoRows {
+		return ErrBudgetNotFound.WithDetailKey("detail.budget.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("update budget error: ", err)
+		return budgetWriteError(err, ErrBudgetUpdate)
+	}
+
+	return c.JSON(http.StatusOK, b)
+}
+
+func DeleteBudgetHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM budgets WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete budget error: ", err)
+		return ErrBudgetUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrBudgetUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrBudgetNotFound.WithDetailKey("detail.budget.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,148 @@
+package expense
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// now is replaced in tests to pin the current date.
+var now = time.Now
+
+type BudgetStatus struct {
+	BudgetID    int     `json:"budget_id"`
+	PeriodStart string  `json:"period_start"`
+	PeriodEnd   string  `json:"period_end"`
+	Amount      float64 `json:"amount"`
+	Rollover    float64 `json:"rollover"`
+	Spent       float64 `json:"spent"`
+	Remaining   float64 `json:"remaining"`
+	PercentUsed float64 `json:"percent_used"`
+	Projected   float64 `json:"projected"`
+}
+
+func today() time.Time {
+	y, m, d := now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// period returns the first and last day of the budget period containing day.
+func (b *Budget) period(day time.Time) (time.Time, time.Time) {
+	switch b.Period {
+	case PeriodWeekly:
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.AddDate(0, 0, 6)
+	case PeriodMonthly:
+		start := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, -1)
+	}
+	start, _ := time.Parse(dateLayout, b.Start)
+	end, _ := time.Parse(dateLayout, b.End)
+	return start, end
+}
+
+// periodsBetween counts the whole periods from the period starting at from to
+// the one starting at to.
+func (b *Budget) periodsBetween(from, to time.Time) int {
+	switch b.Period {
+	case PeriodWeekly:
+		return int(to.Sub(from).Hours() / 24 / 7)
+	case PeriodMonthly:
+		return (to.Year()-from.Year())*12 + int(to.Month()) - int(from.Month())
+	}
+	return 0
+}
+
+// budgetStatus reports how the budget is doing in the period containing day,
+// counting only what was spent from account unless it is empty. With
+// rollover, whatever was left or overspent in earlier periods since the
+// budget started is added to the amount of this one.
+func budgetStatus(ctx context.Context, b Budget, day time.Time, account string) (BudgetStatus, error) {
+	start, end := b.period(day)
+	st := BudgetStatus{
+		BudgetID:    b.ID,
+		PeriodStart: start.Format(dateLayout),
+		PeriodEnd:   end.Format(dateLayout),
+		Amount:      b.Amount,
+	}
+
+	// Spending before the budget started does not count against it.
+	from := start
+	budgetStart, _ := time.Parse(dateLayout, b.Start)
+	if budgetStart.After(from) {
+		from = budgetStart
+	}
+
+	f := b.filter()
+	f.Account = account
+
+	var err error
+	if st.Spent, err = spentBetween(ctx, f, from, end); err != nil {
+		return st, err
+	}
+
+	if b.Rollover {
+		first, _ := b.period(budgetStart)
+		if n := b.periodsBetween(first, start); n > 0 {
+			before, err := spentBetween(ctx, f, budgetStart, start.AddDate(0, 0, -1))
+			if err != nil {
+				return st, err
+			}
+			st.Rollover = round(float64(n)*b.Amount - before)
+			st.Amount += st.Rollover
+		}
+	}
+
+	st.Remaining = round(st.Amount - st.Spent)
+	switch {
+	case st.Amount > 0:
+		st.PercentUsed = round(st.Spent / st.Amount * 100)
+	case st.Spent > 0:
+		st.PercentUsed = 100
+	}
+
+	days := end.Sub(start).Hours()/24 + 1
+	elapsed := math.Min(math.Max(day.Sub(start).Hours()/24+1, 0), days)
+	st.Projected = st.Spent
+	if elapsed > 0 {
+		st.Projected = round(st.Spent / elapsed * days)
+	}
+	return st, nil
+}
+
+func spentBetween(ctx context.Context, f Filter, from, to time.Time) (float64, error) {
+	f.From, f.To = from.Format(dateLayout), to.Format(dateLayout)
+	q := &query{}
+	f.apply(q)
+
+	var spent float64
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM expenses"+q.where(), q.args...).Scan(&spent)
+	return spent, err
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// GetBudgetStatusHandler reports on the current period, narrowed to the
+// spending from one account by ?account=.
+func GetBudgetStatusHandler(c echo.Context) error {
+	b, err := getBudget(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	st, err := budgetStatus(ctx, b, today(), c.QueryParam("account"))
+	if err != nil {
+		c.Logger().Error("query budget status error: ", err)
+		return ErrBudgetQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, st)
+}
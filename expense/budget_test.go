@@ -0,0 +1,226 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	d, err := time.Parse(dateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestBudgetValidate(t *testing.T) {
+	categoryID := 2
+	tests := []struct {
+		name string
+		b    Budget
+		err  string
+	}{
+		{
+			name: "Test case for valid tag budget",
+			b:    Budget{Name: "food", Scope: ScopeTag, Tag: "food", Amount: 5000, Period: PeriodMonthly},
+		},
+		{
+			name: "Test case for valid category budget",
+			b:    Budget{Name: "food", Scope: ScopeCategory, CategoryID: &categoryID, Amount: 5000, Period: PeriodWeekly, Rollover: true},
+		},
+		{
+			name: "Test case for tag budget without tag",
+			b:    Budget{Name: "food", Scope: ScopeTag, Amount: 5000, Period: PeriodMonthly},
+			err:  "tag is required for a tag budget",
+		},
+		{
+			name: "Test case for custom budget without end",
+			b:    Budget{Name: "trip", Scope: ScopeAll, Amount: 5000, Period: PeriodCustom, Start: "2023-01-01"},
+			err:  "a custom budget needs both start and end",
+		},
+		{
+			name: "Test case for custom budget ending before it starts",
+			b:    Budget{Name: "trip", Scope: ScopeAll, Amount: 5000, Period: PeriodCustom, Start: "2023-01-10", End: "2023-01-01"},
+			err:  "end must not be before start",
+		},
+		{
+			name: "Test case for collecting every violation",
+			b:    Budget{Scope: "merchant", Amount: 0, Period: "daily"},
+			err:  "budget name is required; budget amount must be greater than 0; scope must be one of all, tag or category; period must be one of weekly, monthly or custom",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.b.Validate()
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else if assert.ErrorIs(t, err, ErrInvalidBudget) {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestBudgetPeriod(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     Budget
+		day   string
+		start string
+		end   string
+	}{
+		{name: "Test case for weekly period starting on monday", b: Budget{Period: PeriodWeekly}, day: "2023-01-15", start: "2023-01-09", end: "2023-01-15"},
+		{name: "Test case for weekly period on a monday", b: Budget{Period: PeriodWeekly}, day: "2023-01-16", start: "2023-01-16", end: "2023-01-22"},
+		{name: "Test case for monthly period", b: Budget{Period: PeriodMonthly}, day: "2024-02-10", start: "2024-02-01", end: "2024-02-29"},
+		{name: "Test case for custom period", b: Budget{Period: PeriodCustom, Start: "2023-03-01", End: "2023-03-10"}, day: "2023-03-05", start: "2023-03-01", end: "2023-03-10"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := test.b.period(date(test.day))
+
+			assert.Equal(t, test.start, start.Format(dateLayout))
+			assert.Equal(t, test.end, end.Format(dateLayout))
+		})
+	}
+
+	t.Run("Test case for counting periods", func(t *testing.T) {
+		assert.Equal(t, 3, (&Budget{Period: PeriodWeekly}).periodsBetween(date("2023-01-02"), date("2023-01-23")))
+		assert.Equal(t, 14, (&Budget{Period: PeriodMonthly}).periodsBetween(date("2022-11-01"), date("2024-01-01")))
+	})
+}
+
+func TestBudgetStatus(t *testing.T) {
+	spentSQL := "SELECT COALESCE(SUM(amount), 0) FROM expenses WHERE tags @> ARRAY[$1] AND spent_on >= $2::date AND spent_on <= $3::date AND kind = $4"
+
+	t.Run("Test case for monthly budget halfway through the month", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(spentSQL)).WithArgs("food", "2023-04-01", "2023-04-30", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(3000.0))
+
+		b := Budget{ID: 1, Scope: ScopeTag, Tag: "food", Amount: 5000, Period: PeriodMonthly, Start: "2023-01-01"}
+		st, err := budgetStatus(context.Background(), b, date("2023-04-15"), "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, BudgetStatus{
+			BudgetID:    1,
+			PeriodStart: "2023-04-01",
+			PeriodEnd:   "2023-04-30",
+			Amount:      5000,
+			Spent:       3000,
+			Remaining:   2000,
+			PercentUsed: 60,
+			Projected:   6000,
+		}, st)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for rolling over earlier periods", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(spentSQL)).WithArgs("food", "2023-03-01", "2023-03-31", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(0.0))
+		mock.ExpectQuery(regexp.QuoteMeta(spentSQL)).WithArgs("food", "2023-01-10", "2023-02-28", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(9000.0))
+
+		b := Budget{ID: 1, Scope: ScopeTag, Tag: "food", Amount: 5000, Period: PeriodMonthly, Start: "2023-01-10", Rollover: true}
+		st, err := budgetStatus(context.Background(), b, date("2023-03-01"), "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1000.0, st.Rollover)
+		assert.Equal(t, 6000.0, st.Amount)
+		assert.Equal(t, 6000.0, st.Remaining)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateBudgetHandler(t *testing.T) {
+	t.Run("Test case for creating a tag budget", func(t *testing.T) {
+		body := `{"name":"food","scope":"tag","tag":"Food","amount":5000,"period":"monthly","rollover":false}`
+		req := httptest.NewRequest(http.MethodPost, "/budgets", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO budgets")).
+			WithArgs("food", ScopeTag, "food", nil, 5000.0, PeriodMonthly, nil, nil, false, pq.Array([]float64{80, 100})).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "start"}).AddRow(1, "2023-01-15"))
+
+		err = CreateBudgetHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, `{"id":1,"name":"food","scope":"tag","tag":"food","amount":5000,"period":"monthly","start":"2023-01-15","rollover":false,"thresholds":[80,100]}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for invalid budget", func(t *testing.T) {
+		body := `{"name":"food","scope":"category","amount":5000,"period":"monthly"}`
+		req := httptest.NewRequest(http.MethodPost, "/budgets", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := CreateBudgetHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidBudget)
+	})
+}
+
+func TestGetBudgetStatusHandler(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2023, 1, 11, 9, 0, 0, 0, time.Local) }
+
+	req := httptest.NewRequest(http.MethodGet, "/budgets/1/status?account=cash", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetPath("/budgets/:id/status")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + budgetColumns + " FROM budgets WHERE id = $1")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "scope", "tag", "category_id", "amount", "period", "start", "end", "rollover", "thresholds"}).
+			AddRow(1, "all", ScopeAll, "", nil, 700.0, PeriodWeekly, "2023-01-01", "", false, "{80,100}"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(SUM(amount), 0) FROM expenses WHERE account_id IN (")+".*"+
+		regexp.QuoteMeta("AND spent_on >= $2::date AND spent_on <= $3::date AND kind = $4")).
+		WithArgs("cash", "2023-01-09", "2023-01-15", KindExpense).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(300.0))
+
+	err = GetBudgetStatusHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"budget_id":1,"period_start":"2023-01-09","period_end":"2023-01-15","amount":700,"rollover":0,"spent":300,"remaining":400,"percent_used":42.86,"projected":700}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,219 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const maxCategoryNameLength = 50
+
+// Category is a node of the category tree, stored as an adjacency list.
+type Category struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	ParentID *int   `json:"parent_id"`
+}
+
+func (cat *Category) Validate() error {
+	var fields []problem.FieldError
+	switch {
+	case cat.Name == "":
+		fields = append(fields, problem.NewFieldError("body", "/name", "required", "category.name.required", nil))
+	case utf8.RuneCountInString(cat.Name) > maxCategoryNameLength:
+		fields = append(fields, problem.NewFieldError("body", "/name", "maxLength", "category.name.maxLength", i18n.Params{"max": maxCategoryNameLength}))
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidCategory.WithFields(fields...)
+	}
+	return nil
+}
+
+func bindCategory(c echo.Context) (Category, error) {
+	cat := Category{}
+	if err := c.Bind(&cat); err != nil {
+		c.Logger().Error("invalid request binding to struct category error: ", err)
+		return cat, ErrInvalidRequest.Wrap(err)
+	}
+	cat.Name = strings.TrimSpace(cat.Name)
+	return cat, nil
+}
+
+// categoryWriteError maps constraint violations to client errors.
+func categoryWriteError(err error) error {
+	switch pqErrorCode(err) {
+	case foreignKeyViolation:
+		return ErrInvalidCategory.WithFields(problem.NewFieldError("body", "/parent_id", "exists", "category.parent_id.exists", nil)).Wrap(err)
+	case uniqueViolation:
+		return ErrCategoryConflict.WithDetailKey("detail.category.conflict", nil).Wrap(err)
+	}
+	return ErrCategoryUpdate.Wrap(err)
+}
+
+func CreateCategoryHandler(c echo.Context) error {
+	cat, err := bindCategory(c)
+	if err != nil {
+		return err
+	}
+	if err := cat.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, "INSERT INTO categories (name, parent_id) VALUES ($1, $2) RETURNING id", cat.Name, cat.ParentID).Scan(&cat.ID)
+	if err != nil {
+		c.Logger().Error("insert category error: ", err)
+		return categoryWriteError(err)
+	}
+
+	return c.JSON(http.StatusCreated, cat)
+}
+
+func GetCategoriesHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, name, parent_id FROM categories ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrCategoryQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	cats := []Category{}
+	for rows.Next() {
+		cat := Category{}
+		if err = rows.Scan(&cat.ID, &cat.Name, &cat.ParentID); err != nil {
+			c.Logger().Error("scan category error: ", err)
+			return ErrCategoryQuery.Wrap(err)
+		}
+		cats = append(cats, cat)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate categories error: ", err)
+		return ErrCategoryQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, cats)
+}
+
+func GetCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	cat := Category{}
+	err = db.QueryRowContext(ctx, "SELECT id, name, parent_id FROM categories WHERE id = $1", id).Scan(&cat.ID, &cat.Name, &cat.ParentID)
+	if err == sql.ErrNoRows {
+		return ErrCategoryNotFound.WithDetailKey("detail.category.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan category error: ", err)
+		return ErrCategoryQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, cat)
+}
+
+// UpdateCategoryHandler renames or moves a category. Moving a category below
+// one of its own descendants is rejected, so the tree stays acyclic.
+func UpdateCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	cat, err := bindCategory(c)
+	if err != nil {
+		return err
+	}
+	cat.ID = id
+	if err := cat.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrCategoryUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	if cat.ParentID != nil {
+		var cycle bool
+		err = tx.QueryRowContext(ctx, "SELECT $2 IN ("+categoryDescendantsSQL+")", id, *cat.ParentID).Scan(&cycle)
+		if err != nil {
+			c.Logger().Error("query category tree error: ", err)
+			return ErrCategoryUpdate.Wrap(err)
+		}
+		if cycle {
+			return ErrInvalidCategory.WithFields(problem.NewFieldError("body", "/parent_id", "cycle", "category.parent_id.cycle", nil))
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE categories SET name = $2, parent_id = $3 WHERE id = $1", id, cat.Name, cat.ParentID)
+	if err != nil {
+		c.Logger().Error("update category error: ", err)
+		return categoryWriteError(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrCategoryUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrCategoryNotFound.WithDetailKey("detail.category.notFound", i18n.Params{"id": id})
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrCategoryUpdate.Wrap(err)
+	}
+	return c.JSON(http.StatusOK, cat)
+}
+
+// categoryDescendantsSQL selects category $1 and all of its descendants.
+const categoryDescendantsSQL = `WITH RECURSIVE tree AS (
+	SELECT id FROM categories WHERE id = $1
+	UNION
+	SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+) SELECT id FROM tree`
+
+// DeleteCategoryHandler deletes a leaf category. Its expenses become
+// uncategorized; categories with subcategories cannot be deleted.
+func DeleteCategoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", id)
+	if pqErrorCode(err) == foreignKeyViolation {
+		return ErrCategoryConflict.WithDetailKey("detail.category.hasChildren", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("delete category error: ", err)
+		return ErrCategoryUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrCategoryUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrCategoryNotFound.WithDetailKey("detail.category.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,256 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCategoryContext(method, id, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/categories", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if id != "" {
+		c.SetPath("/categories/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	return c, rec
+}
+
+func TestCreateCategoryHandler(t *testing.T) {
+	t.Run("Test case for creating a subcategory", func(t *testing.T) {
+		c, rec := newCategoryContext(http.MethodPost, "", `{"name":" coffee ","parent_id":1}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO categories (name, parent_id) VALUES ($1, $2) RETURNING id")).WithArgs("coffee", 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+		err = CreateCategoryHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, `{"id":2,"name":"coffee","parent_id":1}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for unknown parent", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodPost, "", `{"name":"coffee","parent_id":99}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO categories")).WillReturnError(&pq.Error{Code: foreignKeyViolation})
+
+		err = CreateCategoryHandler(c)
+
+		if assert.ErrorIs(t, err, ErrInvalidCategory) {
+			assert.EqualError(t, err, "parent category does not exist")
+		}
+	})
+
+	t.Run("Test case for duplicated sibling name", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodPost, "", `{"name":"Coffee","parent_id":1}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO categories")).WillReturnError(&pq.Error{Code: uniqueViolation})
+
+		err = CreateCategoryHandler(c)
+
+		assert.ErrorIs(t, err, ErrCategoryConflict)
+	})
+
+	t.Run("Test case for empty name", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodPost, "", `{"name":"  "}`)
+
+		err := CreateCategoryHandler(c)
+
+		if assert.ErrorIs(t, err, ErrInvalidCategory) {
+			assert.EqualError(t, err, "category name is required")
+		}
+	})
+}
+
+func TestGetCategoriesHandler(t *testing.T) {
+	c, rec := newCategoryContext(http.MethodGet, "", "")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, parent_id FROM categories ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "parent_id"}).AddRow(1, "food and drink", nil).AddRow(2, "coffee", 1))
+
+	err = GetCategoriesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `[{"id":1,"name":"food and drink","parent_id":null},{"id":2,"name":"coffee","parent_id":1}]`, strings.TrimSpace(rec.Body.String()))
+	}
+}
+
+func TestUpdateCategoryHandler(t *testing.T) {
+	t.Run("Test case for moving a category", func(t *testing.T) {
+		c, rec := newCategoryContext(http.MethodPut, "2", `{"name":"coffee","parent_id":3}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT $2 IN (WITH RECURSIVE tree AS")).WithArgs(2, 3).WillReturnRows(sqlmock.NewRows([]string{"cycle"}).AddRow(false))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE categories SET name = $2, parent_id = $3 WHERE id = $1")).WithArgs(2, "coffee", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err = UpdateCategoryHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `{"id":2,"name":"coffee","parent_id":3}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for moving a category under its descendant", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodPut, "1", `{"name":"food","parent_id":2}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT $2 IN (WITH RECURSIVE tree AS")).WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"cycle"}).AddRow(true))
+		mock.ExpectRollback()
+
+		err = UpdateCategoryHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidCategory)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDeleteCategoryHandler(t *testing.T) {
+	t.Run("Test case for deleting a leaf category", func(t *testing.T) {
+		c, rec := newCategoryContext(http.MethodDelete, "2", "")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM categories WHERE id = $1")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = DeleteCategoryHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+	})
+
+	t.Run("Test case for deleting a category with subcategories", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodDelete, "1", "")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM categories WHERE id = $1")).WithArgs(1).WillReturnError(&pq.Error{Code: foreignKeyViolation})
+
+		err = DeleteCategoryHandler(c)
+
+		assert.ErrorIs(t, err, ErrCategoryConflict)
+	})
+
+	t.Run("Test case for deleting an unknown category", func(t *testing.T) {
+		c, _ := newCategoryContext(http.MethodDelete, "9", "")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM categories WHERE id = $1")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		err = DeleteCategoryHandler(c)
+
+		assert.ErrorIs(t, err, ErrCategoryNotFound)
+	})
+}
+
+func TestGetExpensesHandlerFiltersByCategoryTree(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/expenses?category=food", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE category_id IN (WITH RECURSIVE tree AS (\n\tSELECT id FROM categories WHERE CASE WHEN $1 ~ '^[0-9]+$' THEN id::text = $1 ELSE lower(name) = lower($1) END")).
+		WithArgs("food", KindExpense).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).AddRow(1, "latte", 80.0, "morning", pq.Array([]string{"coffee"}), 2, "2023-01-15", nil, nil, nil))
+
+	err = GetExpensesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `[{"id":1,"title":"latte","amount":80,"note":"morning","tags":["coffee"],"category_id":2,"date":"2023-01-15"}]`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateExpenseHandlerWithUnknownCategory(t *testing.T) {
+	body := `{"title":"latte","amount":80,"note":"morning","tags":["coffee"],"category_id":99}`
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("latte", 80.0, "morning", pq.Array([]string{"coffee"}), 99, nil, nil, nil, nil, "expense", nil).WillReturnError(&pq.Error{Code: foreignKeyViolation})
+
+	err = CreateExpenseHandler(c)
+
+	if assert.ErrorIs(t, err, ErrInvalidExpense) {
+		assert.EqualError(t, err, "category does not exist")
+	}
+}
@@ -1,31 +1,84 @@
 package expense
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 )
 
+// createExpenseSQL registers any new tag in the same statement as the expense.
+const createExpenseSQL = "WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($4::text[]) ON CONFLICT DO NOTHING) INSERT INTO expenses (title, amount, note, tags, category_id, spent_on, split, merchant_id, account_id, kind, transfer_id) values ($1, $2, $3, $4, $5, COALESCE($6::date, CURRENT_DATE), $7, $8, $9, $10, $11) RETURNING id, to_char(spent_on, 'YYYY-MM-DD')"
+
 func CreateExpenseHandler(c echo.Context) error {
 	e := Expense{}
 	err := c.Bind(&e)
 	if err != nil {
 		c.Logger().Error("invalid request binding to struct exepnse error: ", err)
-		return c.JSON(http.StatusBadRequest, Err{Message: errors.New("invalid request").Error()})
+		return ErrInvalidRequest.Wrap(err)
 	}
+	e.Kind, e.Transfer = "", nil
+
+	return createExpense(c, e)
+}
 
+// createExpense validates and saves an expense or income, guessing its
+// merchant and running the rules over it first.
+func createExpense(c echo.Context, e Expense) error {
+	e.Tags = NormalizeTags(e.Tags)
+	e.Split.normalize()
 	if err := e.Validate(); err != nil {
 		c.Logger().Error("invalid request error: ", err)
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return err
+	}
+	e.Split.allocate(e.Amount)
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	var err error
+	if e.MerchantID == nil {
+		// A failed guess only leaves the expense without a merchant.
+		if e.MerchantID, err = suggestMerchant(ctx, e.Title); err != nil {
+			c.Logger().Error("suggest merchant error: ", err)
+		}
+	}
+	if fired, err := applyRules(ctx, &e); err != nil {
+		c.Logger().Error("apply rules error: ", err)
+	} else if len(fired) > 0 {
+		// A rule may leave the expense invalid, say with a tag too many.
+		if err := e.Validate(); err != nil {
+			c.Logger().Error("invalid expense after rules error: ", err)
+			return err
+		}
 	}
 
-	row := db.QueryRow("INSERT INTO expenses (title, amount, note, tags) values ($1, $2, $3, $4) RETURNING id", e.Title, e.Amount, e.Note, pq.Array(e.Tags))
-	err = row.Scan(&e.ID)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, createExpenseSQL, e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.CategoryID, nullIfEmpty(e.Date), e.Split, e.MerchantID, e.AccountID, kindOf(e), nil)
+	err = row.Scan(&e.ID, &e.Date)
 	if err != nil {
 		c.Logger().Error("insert data error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("cannot insert data").Error()})
+		return expenseWriteError(err, ErrCreate)
+	}
+	if err = enqueueEvent(ctx, tx, eventFor(e, EventExpenseCreated), e); err != nil {
+		c.Logger().Error("enqueue event error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+
+	if kindOf(e) == KindExpense {
+		if err := checkBudgetAlerts(ctx, e); err != nil {
+			c.Logger().Error("check budget alerts error: ", err)
+		}
 	}
 
 	return c.JSON(http.StatusCreated, e)
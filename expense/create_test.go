@@ -3,6 +3,7 @@
 package expense
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -24,12 +25,17 @@ func TestCreateExpenseHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		mockSql := "INSERT INTO expenses (title, amount, note, tags) values ($1, $2, $3, $4) RETURNING id"
-		mockRows := sqlmock.NewRows([]string{"id"}).AddRow("1")
+		mockSql := createExpenseSQL
+		mockRows := sqlmock.NewRows([]string{"id", "date"}).AddRow("1", "2023-01-15")
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
-		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs("title", 100.0, "note", pq.Array([]string{"tag1", "tag2"})).WillReturnRows(mockRows)
+		mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+		mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs("title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}), nil, nil, nil, nil, nil, "expense", nil).WillReturnRows(mockRows)
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -39,7 +45,7 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		if assert.NoError(t, err) {
 			assert.Equal(t, http.StatusCreated, rec.Code)
-			assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["tag1","tag2"]}`, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["tag1","tag2"],"date":"2023-01-15"}`, strings.TrimSpace(rec.Body.String()))
 		}
 
 		if err := mock.ExpectationsWereMet(); err != nil {
@@ -47,6 +53,15 @@ func TestCreateExpenseHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Test case for decoding a numeric string id", func(t *testing.T) {
+		e := Expense{}
+
+		err := json.Unmarshal([]byte(`{"id":"1","title":"title","amount":100,"note":"note","tags":["tag1"]}`), &e)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Expense{ID: 1, Title: "title", Amount: 100, Note: "note", Tags: []string{"tag1"}}, e)
+	})
+
 	t.Run("Test case for failed creation of expense", func(t *testing.T) {
 		body := `invalid request`
 		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
@@ -56,10 +71,7 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err := CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"invalid request"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrInvalidRequest)
 	})
 
 	t.Run("Test case for invalid request with empty title", func(t *testing.T) {
@@ -71,9 +83,8 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err := CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"title is required"}`, strings.TrimSpace(rec.Body.String()))
+		if assert.ErrorIs(t, err, ErrInvalidExpense) {
+			assert.EqualError(t, err, "title is required")
 		}
 	})
 
@@ -86,9 +97,8 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err := CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"amount is required and must be greater than 0"}`, strings.TrimSpace(rec.Body.String()))
+		if assert.ErrorIs(t, err, ErrInvalidExpense) {
+			assert.EqualError(t, err, "amount is required and must be greater than 0")
 		}
 	})
 
@@ -101,9 +111,8 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err := CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"note is required"}`, strings.TrimSpace(rec.Body.String()))
+		if assert.ErrorIs(t, err, ErrInvalidExpense) {
+			assert.EqualError(t, err, "note is required")
 		}
 	})
 
@@ -116,9 +125,8 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err := CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"at least one tag is required"}`, strings.TrimSpace(rec.Body.String()))
+		if assert.ErrorIs(t, err, ErrInvalidExpense) {
+			assert.EqualError(t, err, "at least one tag is required")
 		}
 	})
 
@@ -129,11 +137,14 @@ func TestCreateExpenseHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		mockSql := "INSERT INTO expenses (title, amount, note, tags) values ($1, $2, $3, $4) RETURNING id"
+		mockSql := createExpenseSQL
 		mockDB, mock, err := sqlmock.New()
 		db = mockDB
 
-		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs("title", 100.0, "note", pq.Array([]string{"tag1", "tag2"})).WillReturnError(errors.New("database error"))
+		mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+		mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs("title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}), nil, nil, nil, nil, nil, "expense", nil).WillReturnError(errors.New("database error"))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -141,10 +152,7 @@ func TestCreateExpenseHandler(t *testing.T) {
 
 		err = CreateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"cannot insert data"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrCreate)
 	})
 
 	t.Run("Test case for validating required fields during creation of expense", func(t *testing.T) {
@@ -1,23 +1,27 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
 )
 
-var db *sql.DB
+const maxConnectBackoff = 30 * time.Second
 
-func InitDB() {
-	var err error
-	db, err = sql.Open(os.Getenv("DATABASE_DRIVER"), os.Getenv("DATABASE_URL"))
-	if err != nil {
-		log.Fatal("Connect to database error", err)
-	}
+var (
+	db           *sql.DB
+	queryTimeout = 5 * time.Second
+)
 
-	createTb := `
+var migrations = []string{
+	`
 	CREATE TABLE IF NOT EXISTS expenses (
 		id SERIAL PRIMARY KEY,
 		title TEXT,
@@ -25,15 +29,361 @@ func InitDB() {
 		note TEXT,
 		tags TEXT[]
 	);
+	`,
+	`
+	UPDATE expenses SET tags = ARRAY(
+		SELECT tag FROM unnest(tags) WITH ORDINALITY AS u(raw, i), lower(btrim(normalize(raw, NFC))) AS tag
+		GROUP BY tag ORDER BY MIN(i)
+	) WHERE tags IS NOT NULL;
+	CREATE TABLE IF NOT EXISTS tags (
+		name TEXT PRIMARY KEY
+	);
+	INSERT INTO tags (name) SELECT DISTINCT unnest(tags) FROM expenses ON CONFLICT DO NOTHING;
+	CREATE INDEX IF NOT EXISTS expenses_tags_idx ON expenses USING GIN (tags);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		parent_id INT REFERENCES categories (id) ON DELETE RESTRICT
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS categories_sibling_name_idx ON categories (COALESCE(parent_id, 0), lower(name));
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS category_id INT REFERENCES categories (id) ON DELETE SET NULL;
+	CREATE INDEX IF NOT EXISTS expenses_category_id_idx ON expenses (category_id);
+	`,
+	`
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS spent_on DATE NOT NULL DEFAULT CURRENT_DATE;
+	CREATE INDEX IF NOT EXISTS expenses_spent_on_idx ON expenses (spent_on);
+	CREATE TABLE IF NOT EXISTS budgets (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		scope TEXT NOT NULL CHECK (scope IN ('all', 'tag', 'category')),
+		tag TEXT,
+		category_id INT REFERENCES categories (id) ON DELETE CASCADE,
+		amount FLOAT NOT NULL,
+		period TEXT NOT NULL CHECK (period IN ('weekly', 'monthly', 'custom')),
+		start_date DATE NOT NULL DEFAULT CURRENT_DATE,
+		end_date DATE,
+		rollover BOOLEAN NOT NULL DEFAULT FALSE
+	);
+	`,
+	`
+	ALTER TABLE budgets ADD COLUMN IF NOT EXISTS thresholds FLOAT[] NOT NULL DEFAULT '{80,100}';
+	CREATE TABLE IF NOT EXISTS budget_alerts (
+		id SERIAL PRIMARY KEY,
+		budget_id INT NOT NULL REFERENCES budgets (id) ON DELETE CASCADE,
+		threshold FLOAT NOT NULL,
+		period_start DATE NOT NULL,
+		period_end DATE NOT NULL,
+		spent FLOAT NOT NULL,
+		amount FLOAT NOT NULL,
+		percent_used FLOAT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		delivered_at TIMESTAMPTZ,
+		UNIQUE (budget_id, threshold, period_start)
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id SERIAL PRIMARY KEY,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT[] NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+		id SERIAL PRIMARY KEY,
+		webhook_id INT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		event TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		attempts INT NOT NULL,
+		last_error TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS outbox (
+		id BIGSERIAL PRIMARY KEY,
+		event_id TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		payload JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		attempts INT NOT NULL DEFAULT 0,
+		available_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		last_error TEXT,
+		dispatched_at TIMESTAMPTZ
+	);
+	CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE dispatched_at IS NULL;
+	`,
 	`
-	_, err = db.Exec(createTb)
+	CREATE OR REPLACE FUNCTION notify_outbox() RETURNS trigger AS $$
+	BEGIN
+		PERFORM pg_notify('outbox', NEW.id::text);
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+	DROP TRIGGER IF EXISTS outbox_notify ON outbox;
+	CREATE TRIGGER outbox_notify AFTER INSERT ON outbox FOR EACH ROW EXECUTE FUNCTION notify_outbox();
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS recurring_expenses (
+		id SERIAL PRIMARY KEY,
+		schedule TEXT NOT NULL,
+		title TEXT NOT NULL,
+		amount FLOAT NOT NULL,
+		note TEXT NOT NULL,
+		tags TEXT[] NOT NULL,
+		category_id INT REFERENCES categories (id) ON DELETE SET NULL,
+		start_date DATE NOT NULL,
+		end_date DATE,
+		materialized_through DATE NOT NULL
+	);
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS recurring_id INT REFERENCES recurring_expenses (id) ON DELETE SET NULL;
+	CREATE UNIQUE INDEX IF NOT EXISTS expenses_recurring_occurrence_idx ON expenses (recurring_id, spent_on) WHERE recurring_id IS NOT NULL;
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS attachments (
+		id SERIAL PRIMARY KEY,
+		expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		checksum TEXT NOT NULL,
+		storage_key TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE INDEX IF NOT EXISTS attachments_expense_id_idx ON attachments (expense_id);
+	`,
+	`
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS split JSONB;
+	CREATE TABLE IF NOT EXISTS settlements (
+		id SERIAL PRIMARY KEY,
+		payer TEXT NOT NULL,
+		payee TEXT NOT NULL,
+		amount FLOAT NOT NULL,
+		settled_on DATE NOT NULL DEFAULT CURRENT_DATE,
+		note TEXT NOT NULL DEFAULT ''
+	);
+	`,
+	`
+	CREATE OR REPLACE FUNCTION expense_tags_text(tags TEXT[]) RETURNS TEXT
+		LANGUAGE sql IMMUTABLE AS $$ SELECT array_to_string(tags, ' ') $$;
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+		setweight(to_tsvector('simple', COALESCE(expense_tags_text(tags), '')), 'B') ||
+		setweight(to_tsvector('simple', COALESCE(note, '')), 'C')
+	) STORED;
+	CREATE INDEX IF NOT EXISTS expenses_search_idx ON expenses USING GIN (search);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS views (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		tags TEXT[],
+		category TEXT,
+		min_amount FLOAT,
+		max_amount FLOAT,
+		date_window TEXT,
+		sort TEXT NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS merchants (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		key TEXT NOT NULL UNIQUE
+	);
+	CREATE INDEX IF NOT EXISTS merchants_key_prefix_idx ON merchants (key text_pattern_ops);
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS merchant_id INT REFERENCES merchants (id) ON DELETE SET NULL;
+	CREATE INDEX IF NOT EXISTS expenses_merchant_id_idx ON expenses (merchant_id);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS rules (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		priority INT NOT NULL DEFAULT 0,
+		conditions JSONB NOT NULL,
+		actions JSONB NOT NULL
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS accounts (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		currency TEXT NOT NULL,
+		opening_balance FLOAT NOT NULL DEFAULT 0
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS accounts_name_key ON accounts (lower(name));
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS account_id INT REFERENCES accounts (id) ON DELETE RESTRICT;
+	CREATE INDEX IF NOT EXISTS expenses_account_id_idx ON expenses (account_id, spent_on);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS transfers (
+		id SERIAL PRIMARY KEY,
+		from_account_id INT NOT NULL REFERENCES accounts (id) ON DELETE RESTRICT,
+		to_account_id INT NOT NULL REFERENCES accounts (id) ON DELETE RESTRICT,
+		CHECK (from_account_id <> to_account_id)
+	);
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'expense' CHECK (kind IN ('expense', 'income', 'transfer'));
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS transfer_id INT REFERENCES transfers (id) ON DELETE CASCADE;
+	CREATE INDEX IF NOT EXISTS expenses_kind_idx ON expenses (kind, spent_on);
+	CREATE INDEX IF NOT EXISTS expenses_transfer_id_idx ON expenses (transfer_id);
+	`,
+	`
+	ALTER TABLE expenses ADD COLUMN IF NOT EXISTS import_key TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS expenses_import_key_idx ON expenses (account_id, import_key) WHERE import_key IS NOT NULL;
+	CREATE TABLE IF NOT EXISTS imports (
+		id SERIAL PRIMARY KEY,
+		account_id INT NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+		format TEXT NOT NULL,
+		lines JSONB NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		committed_at TIMESTAMPTZ
+	);
+	`,
+	`
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		outbox_id BIGINT NOT NULL REFERENCES outbox (id) ON DELETE CASCADE,
+		webhook_id INT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+		delivered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (outbox_id, webhook_id)
+	);
+	`,
+	`
+	CREATE SEQUENCE IF NOT EXISTS outbox_seq;
+	ALTER TABLE outbox ADD COLUMN IF NOT EXISTS seq BIGINT;
+	UPDATE outbox SET seq = id WHERE seq IS NULL;
+	SELECT setval('outbox_seq', COALESCE(MAX(seq), 0) + 1, false) FROM outbox;
+	CREATE UNIQUE INDEX IF NOT EXISTS outbox_seq_idx ON outbox (seq);
+	CREATE INDEX IF NOT EXISTS outbox_unsequenced_idx ON outbox (id) WHERE seq IS NULL;
+	`,
+	`
+	ALTER TABLE outbox ADD COLUMN IF NOT EXISTS webhook_attempts INT NOT NULL DEFAULT 0;
+	`,
+}
 
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// pqErrorCode returns the SQLSTATE of a PostgreSQL error, or "" for any other
+// error.
+func pqErrorCode(err error) pq.ErrorCode {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code
+	}
+	return ""
+}
+
+func InitDB(cfg config.Database) error {
+	var err error
+	db, err = sql.Open(cfg.Driver, cfg.URL)
 	if err != nil {
-		log.Fatal("can't create table", err)
+		return fmt.Errorf("open database: %w", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	queryTimeout = cfg.QueryTimeout
+
+	if err = connect(cfg.ConnectRetries, cfg.ConnectBackoff); err != nil {
+		return err
+	}
+
+	if err = migrate(); err != nil {
+		return fmt.Errorf("migrate database: %w", err)
 	}
 
+	registerHealthCheckers()
+	return nil
 }
 
 func CloseDB() {
 	defer db.Close()
 }
+
+func connect(retries int, backoff time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		err := db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("connect to database after %d attempts: %w", attempt+1, err)
+		}
+
+		log.Printf("connect to database error (attempt %d/%d), retrying in %s: %v", attempt+1, retries+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+func queryContext(c echo.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request().Context(), queryTimeout)
+}
+
+// migrationLock is the advisory lock held while migrating, so instances
+// starting together apply each migration once.
+const migrationLock = 0x6d696772617465
+
+func migrate() error {
+	ctx := context.Background()
+	// The lock belongs to a session, so every statement runs on one
+	// connection.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	if _, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INT PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err = conn.QueryRowContext(ctx, schemaVersionSQL).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1)", i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const schemaVersionSQL = "SELECT COALESCE(MAX(version), 0) FROM schema_migrations"
+
+func schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, schemaVersionSQL).Scan(&version)
+	return version, err
+}
@@ -0,0 +1,73 @@
+//go:build unit
+
+package expense
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnect(t *testing.T) {
+	t.Run("Test case for connecting after retrying", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing()
+
+		err = connect(3, time.Millisecond)
+
+		assert.NoError(t, err)
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("Test case for giving up after all retries", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+		err = connect(1, time.Millisecond)
+
+		assert.EqualError(t, err, "connect to database after 2 attempts: connection refused")
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS schema_migrations")).WillReturnResult(sqlmock.NewResult(0, 0))
+	// Another instance applied all but the newest migration while this one
+	// waited for the lock.
+	mock.ExpectQuery(regexp.QuoteMeta(schemaVersionSQL)).WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations) - 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[len(migrations)-1])).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schema_migrations (version) VALUES ($1)")).WithArgs(len(migrations)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(migrationLock).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = migrate()
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,55 @@
+package expense
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+func DeleteExpenseHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Logger().Error("cast id error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	keys, err := attachmentKeys(ctx, tx, "id = $1", id)
+	if err != nil {
+		c.Logger().Error("query attachments error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	e := Expense{}
+	err = scanExpense(tx.QueryRowContext(ctx, "DELETE FROM expenses WHERE id = $1 AND kind = 'expense' RETURNING "+expenseColumns, id), &e)
+	if err == sql.ErrNoRows {
+		return ErrNotFound.WithDetailKey("detail.expense.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("delete data error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	if err = enqueueEvent(ctx, tx, EventExpenseDeleted, e); err != nil {
+		c.Logger().Error("enqueue event error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	deleteBlobs(ctx, c.Logger(), keys...)
+	return c.NoContent(http.StatusNoContent)
+}
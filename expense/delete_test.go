@@ -0,0 +1,79 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteExpenseHandler(t *testing.T) {
+	deleteSQL := "DELETE FROM expenses WHERE id = $1 AND kind = 'expense' RETURNING " + expenseColumns
+	keysSQL := "SELECT storage_key FROM attachments WHERE expense_id IN (SELECT id FROM expenses WHERE id = $1)"
+
+	t.Run("Test case for deleting an expense and its attachments", func(t *testing.T) {
+		store := &localStore{dir: t.TempDir()}
+		blobs = store
+		assert.NoError(t, store.Put(context.Background(), "expenses/1/a", strings.NewReader("receipt"), 7, "text/plain"))
+
+		req := httptest.NewRequest(http.MethodDelete, "/expenses/1", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(keysSQL)).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("expenses/1/a"))
+		mock.ExpectQuery(regexp.QuoteMeta(deleteSQL)).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).AddRow(1, "title", 100.0, "note", "{tag1}", nil, "2023-01-15", nil, nil, nil))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox (event_id, type, payload) VALUES ($1, $2, $3)")).
+			WithArgs(sqlmock.AnyArg(), EventExpenseDeleted, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = DeleteExpenseHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			_, err = store.Get(context.Background(), "expenses/1/a")
+			assert.ErrorIs(t, err, ErrBlobNotFound)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for deleting a missing expense", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/expenses/2", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta(keysSQL)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+		mock.ExpectQuery(regexp.QuoteMeta(deleteSQL)).WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		err = DeleteExpenseHandler(c)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
@@ -0,0 +1,88 @@
+package expense
+
+import (
+	"net/http"
+
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+var (
+	ErrInvalidRequest = problem.ErrInvalidRequest
+	ErrInvalidExpense = problem.New(http.StatusBadRequest, "expense_invalid")
+	ErrNotFound       = problem.New(http.StatusNotFound, "expense_not_found")
+	ErrCreate         = problem.New(http.StatusInternalServerError, "expense_create_failed")
+	ErrQuery          = problem.New(http.StatusInternalServerError, "expense_query_failed")
+	ErrUpdate         = problem.New(http.StatusInternalServerError, "expense_update_failed")
+	ErrInvalidTag     = problem.New(http.StatusBadRequest, "tag_invalid")
+	ErrTagNotFound    = problem.New(http.StatusNotFound, "tag_not_found")
+	ErrTagInUse       = problem.New(http.StatusConflict, "tag_in_use")
+	ErrTagQuery       = problem.New(http.StatusInternalServerError, "tag_query_failed")
+	ErrTagUpdate      = problem.New(http.StatusInternalServerError, "tag_update_failed")
+
+	ErrInvalidCategory  = problem.New(http.StatusBadRequest, "category_invalid")
+	ErrCategoryNotFound = problem.New(http.StatusNotFound, "category_not_found")
+	ErrCategoryConflict = problem.New(http.StatusConflict, "category_conflict")
+	ErrCategoryQuery    = problem.New(http.StatusInternalServerError, "category_query_failed")
+	ErrCategoryUpdate   = problem.New(http.StatusInternalServerError, "category_update_failed")
+
+	ErrInvalidBudget  = problem.New(http.StatusBadRequest, "budget_invalid")
+	ErrBudgetNotFound = problem.New(http.StatusNotFound, "budget_not_found")
+	ErrBudgetQuery    = problem.New(http.StatusInternalServerError, "budget_query_failed")
+	ErrBudgetUpdate   = problem.New(http.StatusInternalServerError, "budget_update_failed")
+
+	ErrInvalidWebhook     = problem.New(http.StatusBadRequest, "webhook_invalid")
+	ErrWebhookNotFound    = problem.New(http.StatusNotFound, "webhook_not_found")
+	ErrDeadLetterNotFound = problem.New(http.StatusNotFound, "dead_letter_not_found")
+	ErrWebhookQuery       = problem.New(http.StatusInternalServerError, "webhook_query_failed")
+	ErrWebhookUpdate      = problem.New(http.StatusInternalServerError, "webhook_update_failed")
+	ErrWebhookDelivery    = problem.New(http.StatusBadGateway, "webhook_delivery_failed")
+
+	ErrInvalidRecurring  = problem.New(http.StatusBadRequest, "recurring_expense_invalid")
+	ErrRecurringNotFound = problem.New(http.StatusNotFound, "recurring_expense_not_found")
+	ErrRecurringQuery    = problem.New(http.StatusInternalServerError, "recurring_expense_query_failed")
+	ErrRecurringUpdate   = problem.New(http.StatusInternalServerError, "recurring_expense_update_failed")
+
+	ErrAttachmentNotFound = problem.New(http.StatusNotFound, "attachment_not_found")
+	ErrAttachmentTooLarge = problem.New(http.StatusRequestEntityTooLarge, "attachment_too_large")
+	ErrAttachmentType     = problem.New(http.StatusUnsupportedMediaType, "attachment_type_unsupported")
+	ErrAttachmentQuery    = problem.New(http.StatusInternalServerError, "attachment_query_failed")
+	ErrAttachmentUpdate   = problem.New(http.StatusInternalServerError, "attachment_update_failed")
+
+	ErrInvalidSettlement = problem.New(http.StatusBadRequest, "settlement_invalid")
+	ErrSettlementUpdate  = problem.New(http.StatusInternalServerError, "settlement_update_failed")
+	ErrBalanceQuery      = problem.New(http.StatusInternalServerError, "balance_query_failed")
+
+	ErrInvalidView  = problem.New(http.StatusBadRequest, "view_invalid")
+	ErrViewNotFound = problem.New(http.StatusNotFound, "view_not_found")
+	ErrViewQuery    = problem.New(http.StatusInternalServerError, "view_query_failed")
+	ErrViewUpdate   = problem.New(http.StatusInternalServerError, "view_update_failed")
+
+	ErrInvalidMerchant  = problem.New(http.StatusBadRequest, "merchant_invalid")
+	ErrMerchantNotFound = problem.New(http.StatusNotFound, "merchant_not_found")
+	ErrMerchantConflict = problem.New(http.StatusConflict, "merchant_conflict")
+	ErrMerchantQuery    = problem.New(http.StatusInternalServerError, "merchant_query_failed")
+	ErrMerchantUpdate   = problem.New(http.StatusInternalServerError, "merchant_update_failed")
+
+	ErrInvalidRule  = problem.New(http.StatusBadRequest, "rule_invalid")
+	ErrRuleNotFound = problem.New(http.StatusNotFound, "rule_not_found")
+	ErrRuleQuery    = problem.New(http.StatusInternalServerError, "rule_query_failed")
+	ErrRuleUpdate   = problem.New(http.StatusInternalServerError, "rule_update_failed")
+
+	ErrInvalidAccount  = problem.New(http.StatusBadRequest, "account_invalid")
+	ErrAccountNotFound = problem.New(http.StatusNotFound, "account_not_found")
+	ErrAccountConflict = problem.New(http.StatusConflict, "account_conflict")
+	ErrAccountInUse    = problem.New(http.StatusConflict, "account_in_use")
+	ErrAccountQuery    = problem.New(http.StatusInternalServerError, "account_query_failed")
+	ErrAccountUpdate   = problem.New(http.StatusInternalServerError, "account_update_failed")
+
+	ErrInvalidTransaction  = problem.New(http.StatusBadRequest, "transaction_invalid")
+	ErrTransactionNotFound = problem.New(http.StatusNotFound, "transaction_not_found")
+	ErrInvalidTransfer     = problem.New(http.StatusBadRequest, "transfer_invalid")
+
+	ErrInvalidStatement  = problem.New(http.StatusBadRequest, "statement_invalid")
+	ErrStatementTooLarge = problem.New(http.StatusRequestEntityTooLarge, "statement_too_large")
+	ErrImportNotFound    = problem.New(http.StatusNotFound, "import_not_found")
+	ErrImportCommitted   = problem.New(http.StatusConflict, "import_committed")
+	ErrImportQuery       = problem.New(http.StatusInternalServerError, "import_query_failed")
+	ErrImportUpdate      = problem.New(http.StatusInternalServerError, "import_update_failed")
+)
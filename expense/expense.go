@@ -1,31 +1,104 @@
 package expense
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+)
 
 type Expense struct {
-	ID     int      `json:"id"`
-	Title  string   `json:"title"`
-	Amount float64  `json:"amount"`
-	Note   string   `json:"note"`
-	Tags   []string `json:"tags"`
+	ID         int      `json:"id"`
+	Title      string   `json:"title"`
+	Amount     float64  `json:"amount"`
+	Note       string   `json:"note"`
+	Tags       []string `json:"tags"`
+	CategoryID *int     `json:"category_id,omitempty"`
+	Date       string   `json:"date,omitempty"`
+	// Split shares the expense between people; see GET /balances.
+	Split *Split `json:"split,omitempty"`
+	// MerchantID is guessed from the title on create when left out.
+	MerchantID *int `json:"merchant_id,omitempty"`
+	// AccountID is the account the expense was paid from.
+	AccountID *int `json:"account_id,omitempty"`
+	// Kind is left out by the /expenses endpoints, which only ever see
+	// expenses, and filled in by the /transactions ones.
+	Kind string `json:"kind,omitempty"`
+	// Transfer links both legs of a transfer between accounts.
+	Transfer *TransferLink `json:"transfer,omitempty"`
+	// Attachments is only filled in when asked for with ?embed=attachments.
+	Attachments []Attachment `json:"attachments,omitempty"`
 }
 
-type Err struct {
-	Message string `json:"message"`
+const expenseColumns = "id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (e *Expense) Validate() error {
-	if e.Title == "" {
-		return errors.New("title is required")
-	}
-	if e.Amount <= 0 {
-		return errors.New("amount is required and must be greater than 0")
+// scanExpense reads a row selected with expenseColumns, followed by any
+// more columns into more.
+func scanExpense(s scanner, e *Expense, more ...interface{}) error {
+	dest := []interface{}{&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags), &e.CategoryID, &e.Date, &e.Split, &e.MerchantID, &e.AccountID}
+	return s.Scan(append(dest, more...)...)
+}
+
+// UnmarshalJSON accepts the id as either a number or a numeric string, as
+// shown in the README examples. Attachments are managed through their own
+// endpoints and ignored here.
+func (e *Expense) UnmarshalJSON(b []byte) error {
+	type expense Expense
+	aux := struct {
+		*expense
+		ID          json.Number     `json:"id"`
+		Attachments json.RawMessage `json:"attachments"`
+	}{expense: (*expense)(e)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
 	}
-	if e.Note == "" {
-		return errors.New("note is required")
+	if aux.ID == "" {
+		return nil
 	}
-	if len(e.Tags) == 0 {
-		return errors.New("at least one tag is required")
+
+	id, err := aux.ID.Int64()
+	if err != nil {
+		return fmt.Errorf("invalid expense id %q", aux.ID)
 	}
+	e.ID = int(id)
 	return nil
 }
+
+func (e *Expense) Validate() error {
+	return validator.Validate(*e)
+}
+
+// expenseReferences maps the foreign keys of expenses, other than the one to
+// categories, to the field holding them.
+var expenseReferences = map[string]string{
+	"expenses_merchant_id_fkey": "merchant_id",
+	"expenses_account_id_fkey":  "account_id",
+}
+
+// expenseWriteError reports a reference to a missing category, merchant or
+// account as an invalid expense and any other failure as fallback.
+func expenseWriteError(err error, fallback *problem.Error) error {
+	if pqErrorCode(err) != foreignKeyViolation {
+		return fallback.Wrap(err)
+	}
+	field := "category_id"
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && expenseReferences[pqErr.Constraint] != "" {
+		field = expenseReferences[pqErr.Constraint]
+	}
+	return ErrInvalidExpense.WithFields(problem.NewFieldError("body", "/"+field, "exists", "expense."+field+".exists", nil)).Wrap(err)
+}
+
+// nullIfEmpty lets an omitted optional value fall back to the column default.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
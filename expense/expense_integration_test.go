@@ -3,14 +3,18 @@
 package expense
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -18,11 +22,13 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/problem"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestIntegrationCreateExpenseHandler(t *testing.T) {
-	InitDB()
+	initIntegrationDB(t)
 	defer CloseDB()
 
 	teardown := startIntegrationTestServer(t)
@@ -46,7 +52,7 @@ func TestIntegrationCreateExpenseHandler(t *testing.T) {
 }
 
 func TestIntegrationGetExpenseHandler(t *testing.T) {
-	InitDB()
+	initIntegrationDB(t)
 	defer CloseDB()
 
 	teardown := startIntegrationTestServer(t)
@@ -70,7 +76,7 @@ func TestIntegrationGetExpenseHandler(t *testing.T) {
 }
 
 func TestIntegrationUpdateExpenseHandler(t *testing.T) {
-	InitDB()
+	initIntegrationDB(t)
 	defer CloseDB()
 
 	teardown := startIntegrationTestServer(t)
@@ -95,7 +101,7 @@ func TestIntegrationUpdateExpenseHandler(t *testing.T) {
 }
 
 func TestIntegrationGetExpensesHandler(t *testing.T) {
-	InitDB()
+	initIntegrationDB(t)
 	defer CloseDB()
 
 	teardown := startIntegrationTestServer(t)
@@ -112,8 +118,662 @@ func TestIntegrationGetExpensesHandler(t *testing.T) {
 	assert.Greater(t, len(eps), 0)
 }
 
+func TestIntegrationRenameTagHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	from, to := "from"+suffix, "to"+suffix
+	var ep Expense
+	body := bytes.NewBufferString(fmt.Sprintf(`{"title":"merge","amount":10,"note":"merge","tags":["%s","%s "]}`, strings.ToUpper(from), to))
+	if err := request(http.MethodPost, uri("expenses"), body).Decode(&ep); err != nil {
+		t.Fatal("can't create expense:", err)
+	}
+	assert.Equal(t, []string{from, to}, ep.Tags)
+
+	var tag Tag
+	res := request(http.MethodPut, uri("tags", from), bytes.NewBufferString(fmt.Sprintf(`{"name":"%s"}`, to)))
+	err := res.Decode(&tag)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, Tag{Name: to, Count: 1}, tag)
+
+	var got Expense
+	assert.Nil(t, request(http.MethodGet, uri("expenses", strconv.Itoa(ep.ID)), nil).Decode(&got))
+	assert.Equal(t, []string{to}, got.Tags)
+
+	res = request(http.MethodDelete, uri("tags", to), nil)
+	assert.Nil(t, res.err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	res = request(http.MethodDelete, uri("tags", to), nil)
+	assert.Nil(t, res.err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestIntegrationFilterExpensesByCategoryTree(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var parent, child Category
+	if err := request(http.MethodPost, uri("categories"), bytes.NewBufferString(`{"name":"food `+suffix+`"}`)).Decode(&parent); err != nil {
+		t.Fatal("can't create category:", err)
+	}
+	body := fmt.Sprintf(`{"name":"coffee","parent_id":%d}`, parent.ID)
+	if err := request(http.MethodPost, uri("categories"), bytes.NewBufferString(body)).Decode(&child); err != nil {
+		t.Fatal("can't create category:", err)
+	}
+	var ep Expense
+	body = fmt.Sprintf(`{"title":"latte","amount":80,"note":"morning","tags":["coffee"],"category_id":%d}`, child.ID)
+	if err := request(http.MethodPost, uri("expenses"), bytes.NewBufferString(body)).Decode(&ep); err != nil {
+		t.Fatal("can't create expense:", err)
+	}
+
+	var eps []Expense
+	res := request(http.MethodGet, uri("expenses?category=food%20"+suffix), nil)
+	err := res.Decode(&eps)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.Len(t, eps, 1) {
+		assert.Equal(t, ep.ID, eps[0].ID)
+		assert.Equal(t, &child.ID, eps[0].CategoryID)
+	}
+
+	res = request(http.MethodDelete, uri("categories", strconv.Itoa(parent.ID)), nil)
+	assert.Nil(t, res.err)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+}
+
+func TestIntegrationBudgetStatusHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	tag := "budget" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var b Budget
+	body := fmt.Sprintf(`{"name":"food","scope":"tag","tag":"%s","amount":1000,"period":"monthly","rollover":false}`, tag)
+	if err := request(http.MethodPost, uri("budgets"), bytes.NewBufferString(body)).Decode(&b); err != nil {
+		t.Fatal("can't create budget:", err)
+	}
+	body = fmt.Sprintf(`{"title":"lunch","amount":250,"note":"budget","tags":["%s"]}`, tag)
+	if err := request(http.MethodPost, uri("expenses"), bytes.NewBufferString(body)).Decode(&Expense{}); err != nil {
+		t.Fatal("can't create expense:", err)
+	}
+
+	var st BudgetStatus
+	res := request(http.MethodGet, uri("budgets", strconv.Itoa(b.ID), "status"), nil)
+	err := res.Decode(&st)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 250.0, st.Spent)
+	assert.Equal(t, 750.0, st.Remaining)
+	assert.Equal(t, 25.0, st.PercentUsed)
+}
+
+func TestIntegrationBudgetAlertsHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	tag := "alert" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var b Budget
+	body := fmt.Sprintf(`{"name":"food","scope":"tag","tag":"%s","amount":1000,"period":"monthly","rollover":false,"thresholds":[50,100]}`, tag)
+	if err := request(http.MethodPost, uri("budgets"), bytes.NewBufferString(body)).Decode(&b); err != nil {
+		t.Fatal("can't create budget:", err)
+	}
+	for i := 0; i < 2; i++ {
+		body = fmt.Sprintf(`{"title":"lunch","amount":300,"note":"alert","tags":["%s"]}`, tag)
+		if err := request(http.MethodPost, uri("expenses"), bytes.NewBufferString(body)).Decode(&Expense{}); err != nil {
+			t.Fatal("can't create expense:", err)
+		}
+	}
+
+	var as []Alert
+	res := request(http.MethodGet, uri("budgets", strconv.Itoa(b.ID), "alerts"), nil)
+	err := res.Decode(&as)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	if assert.Len(t, as, 1) {
+		assert.Equal(t, 50.0, as[0].Threshold)
+		assert.Equal(t, 600.0, as[0].Spent)
+	}
+}
+
+func TestIntegrationExpenseWebhook(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	if err := StartDispatcher(config.Outbox{Sinks: "webhook", PollInterval: 100 * time.Millisecond, BatchSize: 10}); err != nil {
+		t.Fatal("can't start dispatcher:", err)
+	}
+	defer StopDispatcher(context.Background())
+
+	events := make(chan Event, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, sign("s3cret", body), r.Header.Get(HeaderWebhookSignature))
+		var ev Event
+		assert.NoError(t, json.Unmarshal(body, &ev))
+		select {
+		case events <- ev:
+		default:
+		}
+	}))
+	defer srv.Close()
+
+	var w Webhook
+	body := fmt.Sprintf(`{"url":"%s","secret":"s3cret","events":["expense.created","expense.deleted"]}`, srv.URL)
+	if err := request(http.MethodPost, uri("webhooks"), bytes.NewBufferString(body)).Decode(&w); err != nil {
+		t.Fatal("can't create webhook:", err)
+	}
+	defer request(http.MethodDelete, uri("webhooks", strconv.Itoa(w.ID)), nil)
+
+	e := seedExpense(t)
+	res := request(http.MethodDelete, uri("expenses", strconv.Itoa(e.ID)), nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+
+	// Events left in the outbox by other tests may arrive first.
+	want := map[string]bool{EventExpenseCreated: true, EventExpenseDeleted: true}
+	timeout := time.After(10 * time.Second)
+	for len(want) > 0 {
+		select {
+		case ev := <-events:
+			if data, ok := ev.Data.(map[string]interface{}); ok && data["id"] == float64(e.ID) {
+				delete(want, ev.Type)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for", want)
+		}
+	}
+}
+
+func TestIntegrationStreamExpensesHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	cfg, _ := config.Load(nil)
+	if err := StartStream(cfg.Database); err != nil {
+		t.Fatal("can't start stream:", err)
+	}
+	defer func() {
+		StopStream()
+		hub = newStreamHub()
+	}()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	res := request(http.MethodGet, uri("expenses", "stream"), nil)
+	if res.err != nil {
+		t.Fatal("can't open stream:", res.err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	e := seedExpense(t)
+
+	found := make(chan bool, 1)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var ev Event
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev) != nil {
+				continue
+			}
+			if d, ok := ev.Data.(map[string]interface{}); ok && ev.Type == EventExpenseCreated && d["id"] == float64(e.ID) {
+				found <- true
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-found:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the expense.created event")
+	}
+}
+
+func TestIntegrationRecurringExpense(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	tag := "recurring" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	start := today().AddDate(0, 0, -9).Format(dateLayout)
+	var r RecurringExpense
+	body := fmt.Sprintf(`{"schedule":"FREQ=DAILY","template":{"title":"coffee","amount":60,"note":"daily","tags":["%s"]},"start":"%s"}`, tag, start)
+	if err := request(http.MethodPost, uri("recurring-expenses"), bytes.NewBufferString(body)).Decode(&r); err != nil {
+		t.Fatal("can't create recurring expense:", err)
+	}
+
+	// A second run, like a restart, must not create the days again.
+	for i := 0; i < 2; i++ {
+		if _, err := materializeDue(context.Background(), today()); err != nil {
+			t.Fatal("can't materialize recurring expenses:", err)
+		}
+	}
+
+	var eps []Expense
+	res := request(http.MethodGet, uri("expenses")+"?tag="+tag, nil)
+	err := res.Decode(&eps)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, eps, 10)
+
+	var preview []string
+	res = request(http.MethodGet, uri("recurring-expenses", strconv.Itoa(r.ID), "preview")+"?n=2", nil)
+	err = res.Decode(&preview)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{today().AddDate(0, 0, 1).Format(dateLayout), today().AddDate(0, 0, 2).Format(dateLayout)}, preview)
+}
+
+func TestIntegrationAttachments(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	e := seedExpense(t)
+	receipt := []byte("%PDF-1.4 integration test receipt")
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, _ := w.CreateFormFile("file", "receipt.pdf")
+	part.Write(receipt)
+	w.Close()
+	req, _ := http.NewRequest(http.MethodPost, uri("expenses", strconv.Itoa(e.ID), "attachments"), &body)
+	req.Header.Add("Authorization", os.Getenv("AUTH_TOKEN"))
+	req.Header.Add("Content-Type", w.FormDataContentType())
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("can't upload attachment:", err)
+	}
+	var a Attachment
+	err = json.NewDecoder(res.Body).Decode(&a)
+	res.Body.Close()
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	assert.Equal(t, "application/pdf", a.ContentType)
+
+	download := request(http.MethodGet, uri("expenses", strconv.Itoa(e.ID), "attachments", strconv.Itoa(a.ID)), nil)
+	if assert.NoError(t, download.err) {
+		b, _ := io.ReadAll(download.Body)
+		download.Body.Close()
+		assert.Equal(t, receipt, b)
+	}
+
+	var got Expense
+	err = request(http.MethodGet, uri("expenses", strconv.Itoa(e.ID))+"?embed=attachments", nil).Decode(&got)
+	assert.Nil(t, err)
+	assert.Len(t, got.Attachments, 1)
+
+	res = request(http.MethodDelete, uri("expenses", strconv.Itoa(e.ID), "attachments", strconv.Itoa(a.ID)), nil).Response
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+}
+
+func TestIntegrationSearchExpensesHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	word := "zq" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var e Expense
+	body := bytes.NewBufferString(fmt.Sprintf(`{"title":"grab %s","amount":120,"note":"rainy evening at central world","tags":["integration"]}`, word))
+	err := request(http.MethodPost, uri("expenses"), body).Decode(&e)
+	if err != nil {
+		t.Fatal("can't create expense:", err)
+	}
+
+	var rs []SearchResult
+	err = request(http.MethodGet, uri("expenses", "search")+"?q="+url.QueryEscape(word[:len(word)-2]+` "central world"`), nil).Decode(&rs)
+
+	assert.Nil(t, err)
+	if assert.Len(t, rs, 1) {
+		assert.Equal(t, e.ID, rs[0].Expense.ID)
+		assert.Contains(t, rs[0].Snippet, "<mark>"+word+"</mark>")
+	}
+
+	err = request(http.MethodGet, uri("expenses", "search")+"?q="+url.QueryEscape(word+` "world central"`), nil).Decode(&rs)
+
+	assert.Nil(t, err)
+	assert.Len(t, rs, 0)
+}
+
+func TestIntegrationViewExpensesHandler(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	tag := "view-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	for _, amount := range []int{40, 120, 80} {
+		body := bytes.NewBufferString(fmt.Sprintf(`{"title":"coffee","amount":%d,"note":"integration test note","tags":[%q]}`, amount, tag))
+		if err := request(http.MethodPost, uri("expenses"), body).Decode(&Expense{}); err != nil {
+			t.Fatal("can't create expense:", err)
+		}
+	}
+
+	var v View
+	body := bytes.NewBufferString(fmt.Sprintf(`{"name":"big coffee","tags":[%q],"min_amount":50,"window":"7d","sort":"-amount"}`, tag))
+	err := request(http.MethodPost, uri("views"), body).Decode(&v)
+	assert.Nil(t, err)
+
+	var es []Expense
+	err = request(http.MethodGet, uri("views", strconv.Itoa(v.ID), "expenses"), nil).Decode(&es)
+
+	assert.Nil(t, err)
+	if assert.Len(t, es, 2) {
+		assert.Equal(t, 120.0, es[0].Amount)
+		assert.Equal(t, 80.0, es[1].Amount)
+	}
+}
+
+func TestIntegrationSplitBalances(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	// Balances span every test run, so the participants are unique to this one.
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	alice, bob, carol := "alice-"+suffix, "bob-"+suffix, "carol-"+suffix
+
+	var e Expense
+	body := bytes.NewBufferString(fmt.Sprintf(`{"title":"dinner","amount":90,"note":"group dinner","tags":["integration"],
+		"split":{"paid_by":%q,"method":"equal","shares":[{"participant":%q},{"participant":%q},{"participant":%q}]}}`, alice, alice, bob, carol))
+	err := request(http.MethodPost, uri("expenses"), body).Decode(&e)
+	assert.Nil(t, err)
+	if assert.NotNil(t, e.Split) {
+		assert.Equal(t, 30.0, e.Split.Shares[1].Amount)
+	}
+
+	var s Settlement
+	body = bytes.NewBufferString(fmt.Sprintf(`{"from":%q,"to":%q,"amount":30}`, bob, alice))
+	err = request(http.MethodPost, uri("settlements"), body).Decode(&s)
+	assert.Nil(t, err)
+
+	var bs Balances
+	err = request(http.MethodGet, uri("balances"), nil).Decode(&bs)
+	assert.Nil(t, err)
+	got := map[string]float64{}
+	for _, b := range bs.Balances {
+		got[b.Participant] = b.Balance
+	}
+	assert.Equal(t, 30.0, got[alice])
+	assert.Equal(t, 0.0, got[bob])
+	assert.Equal(t, -30.0, got[carol])
+	assert.Contains(t, bs.Transfers, Transfer{From: carol, To: alice, Amount: 30})
+}
+
+func TestIntegrationMerchants(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	name := "Shop-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var m Merchant
+	err := request(http.MethodPost, uri("merchants"), bytes.NewBufferString(fmt.Sprintf(`{"name":%q}`, name))).Decode(&m)
+	assert.Nil(t, err)
+
+	var e Expense
+	body := bytes.NewBufferString(fmt.Sprintf(`{"title":"%s silom","amount":45,"note":"integration test note","tags":["snack"]}`, strings.ToLower(name)))
+	err = request(http.MethodPost, uri("expenses"), body).Decode(&e)
+	assert.Nil(t, err)
+	if assert.NotNil(t, e.MerchantID) {
+		assert.Equal(t, m.ID, *e.MerchantID)
+	}
+
+	var ms []Merchant
+	err = request(http.MethodGet, uri("merchants")+"?prefix="+strings.ToUpper(name), nil).Decode(&ms)
+	assert.Nil(t, err)
+	assert.Equal(t, []Merchant{{ID: m.ID, Name: name, Count: 1}}, ms)
+}
+
+func TestIntegrationRules(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	// Rules outlive the run and apply to every expense created afterwards,
+	// so this one only matches a word unique to the run.
+	word := "rule" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	var r Rule
+	body := bytes.NewBufferString(fmt.Sprintf(`{"name":"tag %[1]s","conditions":[{"field":"title","contains":%[1]q}],
+		"actions":[{"type":"add_tag","tag":%[1]q},{"type":"set_note","note":"tagged by rule"}]}`, word))
+	err := request(http.MethodPost, uri("rules"), body).Decode(&r)
+	assert.Nil(t, err)
+
+	var dry RuleDryRun
+	body = bytes.NewBufferString(fmt.Sprintf(`{"title":"taxi %s","amount":90,"note":"integration test note","tags":["taxi"]}`, word))
+	err = request(http.MethodPost, uri("rules", "dry-run"), body).Decode(&dry)
+	assert.Nil(t, err)
+	if assert.Len(t, dry.Fired, 1) {
+		assert.Equal(t, r.ID, dry.Fired[0].ID)
+	}
+
+	var e Expense
+	body = bytes.NewBufferString(fmt.Sprintf(`{"title":"taxi %s","amount":90,"note":"integration test note","tags":["taxi"]}`, word))
+	err = request(http.MethodPost, uri("expenses"), body).Decode(&e)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"taxi", word}, e.Tags)
+	assert.Equal(t, "tagged by rule", e.Note)
+}
+
+func TestIntegrationAccounts(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	var a Account
+	name := "card-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	body := bytes.NewBufferString(fmt.Sprintf(`{"name":%q,"type":"credit_card","currency":"thb","opening_balance":0}`, name))
+	err := request(http.MethodPost, uri("accounts"), body).Decode(&a)
+	assert.Nil(t, err)
+	assert.Equal(t, "THB", a.Currency)
+
+	for _, e := range []struct {
+		amount int
+		date   string
+	}{{amount: 300, date: "2023-02-01"}, {amount: 150, date: "2023-02-03"}} {
+		body := bytes.NewBufferString(fmt.Sprintf(`{"title":"fuel","amount":%d,"note":"integration test note","tags":["car"],"date":%q,"account_id":%d}`, e.amount, e.date, a.ID))
+		if err := request(http.MethodPost, uri("expenses"), body).Decode(&Expense{}); err != nil {
+			t.Fatal("can't create expense:", err)
+		}
+	}
+
+	var b AccountBalance
+	err = request(http.MethodGet, uri("accounts", strconv.Itoa(a.ID), "balance"), nil).Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, -450.0, b.Balance)
+	assert.Equal(t, []AccountBalanceDay{{Date: "2023-02-01", Spent: 300, Balance: -300}, {Date: "2023-02-03", Spent: 150, Balance: -450}}, b.Days)
+
+	var es []Expense
+	err = request(http.MethodGet, uri("expenses")+"?account="+name, nil).Decode(&es)
+	assert.Nil(t, err)
+	assert.Len(t, es, 2)
+}
+
+func TestIntegrationTransactions(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	var bank, wallet Account
+	for name, a := range map[string]*Account{"bank-": &bank, "wallet-": &wallet} {
+		body := bytes.NewBufferString(fmt.Sprintf(`{"name":%q,"type":"debit","currency":"THB","opening_balance":0}`, name+suffix))
+		if err := request(http.MethodPost, uri("accounts"), body).Decode(a); err != nil {
+			t.Fatal("can't create account:", err)
+		}
+	}
+
+	var income Expense
+	body := bytes.NewBufferString(fmt.Sprintf(`{"kind":"income","title":"salary","amount":1000,"note":"integration test note","tags":["salary"],"date":"2023-03-01","account_id":%d}`, bank.ID))
+	err := request(http.MethodPost, uri("transactions"), body).Decode(&income)
+	assert.Nil(t, err)
+	assert.Equal(t, KindIncome, income.Kind)
+
+	var tr AccountTransfer
+	body = bytes.NewBufferString(fmt.Sprintf(`{"from_account_id":%d,"to_account_id":%d,"amount":400,"note":"integration test note","tags":["cash"],"date":"2023-03-02"}`, bank.ID, wallet.ID))
+	err = request(http.MethodPost, uri("transactions", "transfers"), body).Decode(&tr)
+	assert.Nil(t, err)
+	assert.Len(t, tr.Transactions, 2)
+
+	var b AccountBalance
+	err = request(http.MethodGet, uri("accounts", strconv.Itoa(bank.ID), "balance"), nil).Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 600.0, b.Balance)
+	err = request(http.MethodGet, uri("accounts", strconv.Itoa(wallet.ID), "balance"), nil).Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 400.0, b.Balance)
+
+	var es []Expense
+	err = request(http.MethodGet, uri("expenses")+"?account="+strconv.Itoa(bank.ID), nil).Decode(&es)
+	assert.Nil(t, err)
+	assert.Empty(t, es)
+
+	var flow CashFlow
+	err = request(http.MethodGet, uri("transactions", "summary")+"?account="+strconv.Itoa(bank.ID), nil).Decode(&flow)
+	assert.Nil(t, err)
+	assert.Equal(t, []CashFlowPeriod{{Start: "2023-03-01", CashFlowTotals: CashFlowTotals{Income: 1000, TransfersOut: 400, Net: 600}}}, flow.Periods)
+
+	res := request(http.MethodDelete, uri("transactions", strconv.Itoa(tr.Transactions[1].ID)), nil)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	var ts []Expense
+	err = request(http.MethodGet, uri("transactions")+"?kind=transfer&account="+strconv.Itoa(bank.ID), nil).Decode(&ts)
+	assert.Nil(t, err)
+	assert.Empty(t, ts)
+}
+
+func TestIntegrationImports(t *testing.T) {
+	initIntegrationDB(t)
+	defer CloseDB()
+
+	teardown := startIntegrationTestServer(t)
+	defer teardown()
+
+	var bank Account
+	body := bytes.NewBufferString(fmt.Sprintf(`{"name":"import-%s","type":"debit","currency":"THB","opening_balance":0}`, strconv.FormatInt(time.Now().UnixNano(), 36)))
+	if err := request(http.MethodPost, uri("accounts"), body).Decode(&bank); err != nil {
+		t.Fatal("can't create account:", err)
+	}
+
+	upload := func(statement string) Import {
+		var body bytes.Buffer
+		w := multipart.NewWriter(&body)
+		part, _ := w.CreateFormFile("file", "statement.qif")
+		part.Write([]byte(statement))
+		w.Close()
+		req, _ := http.NewRequest(http.MethodPost, uri("imports")+"?account_id="+strconv.Itoa(bank.ID), &body)
+		req.Header.Add("Authorization", os.Getenv("AUTH_TOKEN"))
+		req.Header.Add("Content-Type", w.FormDataContentType())
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("can't upload statement:", err)
+		}
+		defer res.Body.Close()
+		var imp Import
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Nil(t, json.NewDecoder(res.Body).Decode(&imp))
+		return imp
+	}
+
+	january := "!Type:Bank\nD15/01/2023\nT-60.00\nPintegration noodles\n^\nD25/01/2023\nT1000.00\nPintegration salary\n^\n"
+	imp := upload(january)
+	assert.Equal(t, map[string]int{ImportNew: 2}, imp.Counts)
+
+	var committed Import
+	err := request(http.MethodPost, uri("imports", strconv.Itoa(imp.ID), "commit"), nil).Decode(&committed)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int{ImportCreated: 2}, committed.Counts)
+	res := request(http.MethodPost, uri("imports", strconv.Itoa(imp.ID), "commit"), nil)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	overlap := upload(january + "D01/02/2023\nT-80.00\nPintegration cafe\n^\n")
+	assert.Equal(t, map[string]int{ImportDuplicate: 2, ImportNew: 1}, overlap.Counts)
+
+	var b AccountBalance
+	err = request(http.MethodGet, uri("accounts", strconv.Itoa(bank.ID), "balance"), nil).Decode(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, 940.0, b.Balance)
+}
+
+func initIntegrationDB(t *testing.T) {
+	cfg, err := config.Load(nil)
+	if err != nil {
+		t.Fatal("can't load config:", err)
+	}
+	if err := InitDB(cfg.Database); err != nil {
+		t.Fatal("can't init database:", err)
+	}
+	if err := SetAttachmentStore(cfg.Attachments); err != nil {
+		t.Fatal("can't open attachment store:", err)
+	}
+	if s, ok := blobs.(*s3Store); ok {
+		createBucket(t, s)
+	}
+}
+
+// createBucket makes sure the bucket of a fresh MinIO exists.
+func createBucket(t *testing.T, s *s3Store) {
+	u := *s.endpoint
+	u.Path = "/" + s.cfg.Bucket
+	req, _ := http.NewRequest(http.MethodPut, u.String(), nil)
+	s.sign(req, time.Now().UTC())
+	res, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal("can't create bucket:", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusConflict {
+		t.Fatal("can't create bucket: status", res.StatusCode)
+	}
+}
+
 func startIntegrationTestServer(t *testing.T) func() {
 	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
 
 	go func() {
 		e.Use(authMiddlewareGuardIntegrationTest)
@@ -121,7 +781,42 @@ func startIntegrationTestServer(t *testing.T) func() {
 		e.POST("/expenses", CreateExpenseHandler)
 		e.GET("/expenses/:id", GetExpenseHandler)
 		e.PUT("/expenses/:id", UpdateExpenseHandler)
+		e.DELETE("/expenses/:id", DeleteExpenseHandler)
 		e.GET("expenses", GetExpensesHandler)
+		e.GET("/expenses/stream", StreamExpensesHandler)
+		e.GET("/expenses/search", SearchExpensesHandler)
+		e.POST("/expenses/:id/attachments", CreateAttachmentHandler)
+		e.GET("/expenses/:id/attachments/:attachment_id", GetAttachmentHandler)
+		e.DELETE("/expenses/:id/attachments/:attachment_id", DeleteAttachmentHandler)
+		e.GET("/tags", GetTagsHandler)
+		e.PUT("/tags/:name", RenameTagHandler)
+		e.DELETE("/tags/:name", DeleteTagHandler)
+		e.POST("/categories", CreateCategoryHandler)
+		e.DELETE("/categories/:id", DeleteCategoryHandler)
+		e.POST("/budgets", CreateBudgetHandler)
+		e.GET("/budgets/:id/status", GetBudgetStatusHandler)
+		e.GET("/budgets/:id/alerts", GetBudgetAlertsHandler)
+		e.POST("/webhooks", CreateWebhookHandler)
+		e.DELETE("/webhooks/:id", DeleteWebhookHandler)
+		e.POST("/recurring-expenses", CreateRecurringExpenseHandler)
+		e.GET("/recurring-expenses/:id/preview", PreviewRecurringExpenseHandler)
+		e.GET("/balances", GetBalancesHandler)
+		e.POST("/settlements", CreateSettlementHandler)
+		e.POST("/views", CreateViewHandler)
+		e.GET("/views/:id/expenses", GetViewExpensesHandler)
+		e.POST("/merchants", CreateMerchantHandler)
+		e.GET("/merchants", GetMerchantsHandler)
+		e.POST("/rules", CreateRuleHandler)
+		e.POST("/rules/dry-run", DryRunRulesHandler)
+		e.POST("/accounts", CreateAccountHandler)
+		e.GET("/accounts/:id/balance", GetAccountBalanceHandler)
+		e.POST("/transactions", CreateTransactionHandler)
+		e.GET("/transactions", GetTransactionsHandler)
+		e.POST("/transactions/transfers", CreateTransferHandler)
+		e.GET("/transactions/summary", GetCashFlowHandler)
+		e.DELETE("/transactions/:id", DeleteTransactionHandler)
+		e.POST("/imports", CreateImportHandler)
+		e.POST("/imports/:id/commit", CommitImportHandler)
 		e.Start(fmt.Sprintf(":%s", os.Getenv("PORT")))
 	}()
 	for {
@@ -0,0 +1,105 @@
+package expense
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// Filter narrows the expenses selected by list queries. Every query that
+// lists expenses builds its WHERE clause from a Filter, so they stay in sync.
+type Filter struct {
+	// Kind narrows the list to one kind of transaction. Left empty it is
+	// KindExpense, so the expense endpoints never see income or transfers;
+	// anyKind lists them all.
+	Kind string `json:"-"`
+	// Category matches a category by id or by case-insensitive name, together
+	// with all of its descendants.
+	Category string `json:"category,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	// Account matches an account by id or by case-insensitive name.
+	Account string `json:"account,omitempty"`
+	// Tags matches expenses carrying every one of the tags.
+	Tags []string `json:"tags,omitempty"`
+	// MinAmount and MaxAmount bound the amount, both inclusive.
+	MinAmount *float64 `json:"min_amount,omitempty"`
+	MaxAmount *float64 `json:"max_amount,omitempty"`
+	// From and To bound the expense date, both inclusive, as YYYY-MM-DD.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+func FilterFromQuery(c echo.Context) Filter {
+	return Filter{
+		Category: c.QueryParam("category"),
+		Tag:      NormalizeTag(c.QueryParam("tag")),
+		Account:  c.QueryParam("account"),
+		From:     c.QueryParam("from"),
+		To:       c.QueryParam("to"),
+	}
+}
+
+func (f Filter) apply(q *query) {
+	if f.Category != "" {
+		q.add("category_id IN ("+categoryTreeSQL+")", f.Category)
+	}
+	if f.Tag != "" {
+		q.add("tags @> ARRAY[?]", f.Tag)
+	}
+	if f.Account != "" {
+		q.add("account_id IN (SELECT id FROM accounts WHERE CASE WHEN ? ~ '^[0-9]+$' THEN id::text = ? ELSE lower(name) = lower(?) END)", f.Account)
+	}
+	if len(f.Tags) > 0 {
+		q.add("tags @> ?", pq.Array(f.Tags))
+	}
+	if f.MinAmount != nil {
+		q.add("amount >= ?", *f.MinAmount)
+	}
+	if f.MaxAmount != nil {
+		q.add("amount <= ?", *f.MaxAmount)
+	}
+	if f.From != "" {
+		q.add("spent_on >= ?::date", f.From)
+	}
+	if f.To != "" {
+		q.add("spent_on <= ?::date", f.To)
+	}
+	switch f.Kind {
+	case "":
+		q.add("kind = ?", KindExpense)
+	case anyKind:
+	default:
+		q.add("kind = ?", f.Kind)
+	}
+}
+
+// categoryTreeSQL selects the ids of the categories matching ? and of all
+// their descendants. A numeric value is an id, anything else a name.
+const categoryTreeSQL = `WITH RECURSIVE tree AS (
+	SELECT id FROM categories WHERE CASE WHEN ? ~ '^[0-9]+$' THEN id::text = ? ELSE lower(name) = lower(?) END
+	UNION
+	SELECT c.id FROM categories c JOIN tree t ON c.parent_id = t.id
+) SELECT id FROM tree`
+
+// query accumulates WHERE conditions written with ? placeholders and numbers
+// them as $1, $2, ... in the order they are added.
+type query struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends a condition. Every ? in cond is bound to arg.
+func (q *query) add(cond string, arg interface{}) {
+	n := len(q.args) + 1
+	q.conds = append(q.conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", n)))
+	q.args = append(q.args, arg)
+}
+
+func (q *query) where() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conds, " AND ")
+}
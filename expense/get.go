@@ -1,55 +1,144 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
 )
 
+const EmbedAttachments = "attachments"
+
+// embedded reports whether the request asks for attachments with ?embed=.
+func embedded(c echo.Context) (bool, error) {
+	switch c.QueryParam("embed") {
+	case "":
+		return false, nil
+	case EmbedAttachments:
+		return true, nil
+	}
+	return false, ErrInvalidRequest.WithDetailKey("detail.embed.enum", i18n.Params{"embed": EmbedAttachments})
+}
+
+// embedAttachments fills in the attachments of each expense with one query.
+func embedAttachments(ctx context.Context, es []Expense) error {
+	if len(es) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(es))
+	byID := make(map[int]*Expense, len(es))
+	for i := range es {
+		ids[i] = int64(es[i].ID)
+		es[i].Attachments = []Attachment{}
+		byID[es[i].ID] = &es[i]
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE expense_id = ANY($1) ORDER BY id", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a := Attachment{}
+		if err = scanAttachment(rows, &a); err != nil {
+			return err
+		}
+		e := byID[a.ExpenseID]
+		e.Attachments = append(e.Attachments, a)
+	}
+	return rows.Err()
+}
+
 func GetExpenseHandler(c echo.Context) error {
 	id := c.Param("id")
+	embed, err := embedded(c)
+	if err != nil {
+		return err
+	}
 
-	stmt, err := db.Prepare("SELECT id, title, amount, note, tags FROM expenses WHERE id = $1")
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	stmt, err := db.PrepareContext(ctx, "SELECT "+expenseColumns+" FROM expenses WHERE id = $1 AND kind = 'expense'")
 	if err != nil {
 		c.Logger().Error("prepare statment error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("cannot prepare query expense statment").Error()})
+		return ErrQuery.Wrap(err)
 	}
 
-	row := stmt.QueryRow(id)
+	defer stmt.Close()
+
+	row := stmt.QueryRowContext(ctx, id)
 	e := Expense{}
-	err = row.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags))
+	err = scanExpense(row, &e)
 	if err == sql.ErrNoRows {
 		c.Logger().Error("data not found: ", err)
-		return c.JSON(http.StatusNotFound, Err{Message: errors.New("expense not found").Error()})
+		return ErrNotFound.Wrap(err)
 	} else if err != nil {
 		c.Logger().Error("scan expense error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("unable to scan expense").Error()})
+		return ErrQuery.Wrap(err)
+	}
+
+	if embed {
+		es := []Expense{e}
+		if err = embedAttachments(ctx, es); err != nil {
+			c.Logger().Error("query attachments error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+		e = es[0]
 	}
 
 	return c.JSON(http.StatusOK, e)
 }
 
 func GetExpensesHandler(c echo.Context) error {
+	return listExpenses(c, FilterFromQuery(c), "")
+}
 
-	rows, err := db.Query("SELECT id, title, amount, note, tags FROM expenses")
+// listExpenses responds with the expenses f selects, ordered by one of the
+// orderBy sorts or unordered when sort is empty.
+func listExpenses(c echo.Context, f Filter, sort string) error {
+	embed, err := embedded(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	q := &query{}
+	f.apply(q)
+
+	rows, err := db.QueryContext(ctx, "SELECT "+expenseColumns+" FROM expenses"+q.where()+orderBy[sort], q.args...)
 	if err != nil {
 		c.Logger().Error("query statment error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("cannot query expense").Error()})
+		return ErrQuery.Wrap(err)
 	}
+	defer rows.Close()
 
 	es := []Expense{}
 	for rows.Next() {
 		e := Expense{}
-		err = rows.Scan(&e.ID, &e.Title, &e.Amount, &e.Note, pq.Array(&e.Tags))
+		err = scanExpense(rows, &e)
 		if err != nil {
 			c.Logger().Error("scan expense error: ", err)
-			return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("unable to scan expense").Error()})
+			return ErrQuery.Wrap(err)
 		}
 		es = append(es, e)
 	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate expenses error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+
+	if embed {
+		if err = embedAttachments(ctx, es); err != nil {
+			c.Logger().Error("query attachments error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+	}
 
 	return c.JSON(http.StatusOK, es)
 }
@@ -3,12 +3,14 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/labstack/echo/v4"
@@ -26,8 +28,8 @@ func TestGetExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"
-		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, "title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}))
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE id = $1 AND kind = 'expense'"
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).AddRow(1, "title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}), nil, "2023-01-15", nil, nil, nil)
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
@@ -41,7 +43,7 @@ func TestGetExpenseHandler(t *testing.T) {
 
 		if assert.NoError(t, err) {
 			assert.Equal(t, http.StatusOK, rec.Code)
-			assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["tag1","tag2"]}`, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["tag1","tag2"],"date":"2023-01-15"}`, strings.TrimSpace(rec.Body.String()))
 		}
 
 		if err := mock.ExpectationsWereMet(); err != nil {
@@ -58,8 +60,8 @@ func TestGetExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"
-		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(1, "title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}))
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE id = $1 AND kind = 'expense'"
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).AddRow(1, "title", 100.0, "note", pq.Array([]string{"tag1", "tag2"}), nil, "2023-01-15", nil, nil, nil)
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
@@ -71,10 +73,7 @@ func TestGetExpenseHandler(t *testing.T) {
 
 		err = GetExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"cannot prepare query expense statment"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrQuery)
 	})
 
 	t.Run("Test case for getting expense by ID not found", func(t *testing.T) {
@@ -86,7 +85,7 @@ func TestGetExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE id = $1 AND kind = 'expense'"
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
@@ -98,10 +97,7 @@ func TestGetExpenseHandler(t *testing.T) {
 
 		err = GetExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusNotFound, rec.Code)
-			assert.Equal(t, `{"message":"expense not found"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrNotFound)
 	})
 
 	t.Run("Test case for unable to scan expense", func(t *testing.T) {
@@ -113,7 +109,7 @@ func TestGetExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses WHERE id = $1"
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE id = $1 AND kind = 'expense'"
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
@@ -125,10 +121,7 @@ func TestGetExpenseHandler(t *testing.T) {
 
 		err = GetExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"unable to scan expense"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrQuery)
 	})
 }
 
@@ -139,14 +132,14 @@ func TestGetExpensesHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses"
-		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-			AddRow("1", "title1", 100.0, "note1", pq.Array([]string{"tag1", "tag2"})).
-			AddRow("2", "title2", 200.0, "note2", pq.Array([]string{"tag11", "tag22"}))
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses WHERE kind = $1"
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).
+			AddRow("1", "title1", 100.0, "note1", pq.Array([]string{"tag1", "tag2"}), nil, "2023-01-15", nil, nil, nil).
+			AddRow("2", "title2", 200.0, "note2", pq.Array([]string{"tag11", "tag22"}), nil, "2023-01-15", nil, nil, nil)
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
-		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WillReturnRows(mockRows)
+		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs(KindExpense).WillReturnRows(mockRows)
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -156,7 +149,7 @@ func TestGetExpensesHandler(t *testing.T) {
 
 		if assert.NoError(t, err) {
 			assert.Equal(t, http.StatusOK, rec.Code)
-			assert.Equal(t, `[{"id":1,"title":"title1","amount":100,"note":"note1","tags":["tag1","tag2"]},{"id":2,"title":"title2","amount":200,"note":"note2","tags":["tag11","tag22"]}]`, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, `[{"id":1,"title":"title1","amount":100,"note":"note1","tags":["tag1","tag2"],"date":"2023-01-15"},{"id":2,"title":"title2","amount":200,"note":"note2","tags":["tag11","tag22"],"date":"2023-01-15"}]`, strings.TrimSpace(rec.Body.String()))
 		}
 	})
 
@@ -166,10 +159,10 @@ func TestGetExpensesHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expensesx"
-		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-			AddRow("1", "title1", 100.0, "note1", pq.Array([]string{"tag1", "tag2"})).
-			AddRow("2", "title2", 200.0, "note2", pq.Array([]string{"tag11", "tag22"}))
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expensesx"
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).
+			AddRow("1", "title1", 100.0, "note1", pq.Array([]string{"tag1", "tag2"}), nil, "2023-01-15", nil, nil, nil).
+			AddRow("2", "title2", 200.0, "note2", pq.Array([]string{"tag11", "tag22"}), nil, "2023-01-15", nil, nil, nil)
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
@@ -181,10 +174,31 @@ func TestGetExpensesHandler(t *testing.T) {
 
 		err = GetExpensesHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"cannot query expense"}`, strings.TrimSpace(rec.Body.String()))
+		assert.ErrorIs(t, err, ErrQuery)
+	})
+
+	t.Run("Test case for cancelled request stopping the query", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/expenses", strings.NewReader("")).WithContext(ctx)
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses"
+		mockRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"})
+		mockDB, mock, err := sqlmock.New()
+
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WillDelayFor(time.Second).WillReturnRows(mockRows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+		defer mockDB.Close()
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		err = GetExpensesHandler(c)
+
+		assert.ErrorIs(t, err, ErrQuery)
 	})
 
 	t.Run("Test case for unable to prepare scan all expense", func(t *testing.T) {
@@ -193,7 +207,7 @@ func TestGetExpensesHandler(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := echo.New().NewContext(req, rec)
 
-		mockSql := "SELECT id, title, amount, note, tags FROM expenses"
+		mockSql := "SELECT id, title, amount, note, tags, category_id, to_char(spent_on, 'YYYY-MM-DD'), split, merchant_id, account_id FROM expenses"
 		mockRows := sqlmock.NewRows([]string{"id", "title"}).AddRow("invalid", "title invalid")
 		mockDB, mock, err := sqlmock.New()
 
@@ -206,9 +220,6 @@ func TestGetExpensesHandler(t *testing.T) {
 
 		err = GetExpensesHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"unable to scan expense"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrQuery)
 	})
 }
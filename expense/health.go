@@ -0,0 +1,47 @@
+package expense
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lnwsitgod/assessment/health"
+)
+
+func registerHealthCheckers() {
+	health.Register("database", checkDatabase)
+	health.Register("migrations", checkMigrations)
+	health.Register("database_pool", checkDatabasePool)
+}
+
+func checkDatabase(ctx context.Context) (map[string]interface{}, error) {
+	return nil, db.PingContext(ctx)
+}
+
+func checkMigrations(ctx context.Context) (map[string]interface{}, error) {
+	version, err := schemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{"current": version, "expected": len(migrations)}
+	if version != len(migrations) {
+		return details, fmt.Errorf("schema version %d does not match expected version %d", version, len(migrations))
+	}
+	return details, nil
+}
+
+// checkDatabasePool reports how busy the connection pool is. A saturated
+// pool is a pod under load, not a broken one, so it never fails readiness;
+// taking the pod out of rotation would only push its load onto the others.
+func checkDatabasePool(ctx context.Context) (map[string]interface{}, error) {
+	stats := db.Stats()
+	return map[string]interface{}{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"saturated":            stats.MaxOpenConnections > 0 && stats.InUse >= stats.MaxOpenConnections,
+		"wait_count":           stats.WaitCount,
+		"wait_duration":        stats.WaitDuration.String(),
+	}, nil
+}
@@ -0,0 +1,81 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckers(t *testing.T) {
+	t.Run("Test case for database ping failure", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+
+		_, err = checkDatabase(context.Background())
+
+		assert.EqualError(t, err, "connection refused")
+	})
+
+	t.Run("Test case for migrations up to date", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(len(migrations)))
+
+		details, err := checkMigrations(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"current": len(migrations), "expected": len(migrations)}, details)
+	})
+
+	t.Run("Test case for pending migrations", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")).
+			WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(0))
+
+		_, err = checkMigrations(context.Background())
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Test case for a saturated connection pool staying ready", func(t *testing.T) {
+		mockDB, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		db.SetMaxOpenConns(1)
+		conn, err := db.Conn(context.Background())
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when acquiring a connection", err)
+		}
+		defer conn.Close()
+
+		details, err := checkDatabasePool(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, details["in_use"])
+		assert.Equal(t, true, details["saturated"])
+	})
+}
@@ -0,0 +1,465 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+// maxStatementSize is the largest statement file accepted for import.
+const maxStatementSize = 5 << 20
+
+const defaultImportTag = "imported"
+
+const (
+	ImportNew       = "new"
+	ImportDuplicate = "duplicate"
+	ImportInvalid   = "invalid"
+	ImportSkipped   = "skipped"
+	ImportCreated   = "created"
+)
+
+// Import is a bank statement read into transactions for an account. It is
+// saved as a preview and nothing is recorded until it is committed.
+type Import struct {
+	ID          int            `json:"id"`
+	AccountID   int            `json:"account_id"`
+	Format      string         `json:"format"`
+	Counts      map[string]int `json:"counts"`
+	Lines       []ImportLine   `json:"lines"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CommittedAt *time.Time     `json:"committed_at,omitempty"`
+}
+
+// ImportLine is one statement line. Only new lines are recorded on commit;
+// a line already imported, or seen earlier in the same statement, is a
+// duplicate.
+type ImportLine struct {
+	Line        int                  `json:"line"`
+	Key         string               `json:"key"`
+	Status      string               `json:"status"`
+	Transaction Expense              `json:"transaction"`
+	Errors      []problem.FieldError `json:"errors,omitempty"`
+}
+
+// ImportCommit lists the lines of the preview to leave out.
+type ImportCommit struct {
+	Skip []int `json:"skip"`
+}
+
+func (i *Import) count() {
+	i.Counts = map[string]int{}
+	for _, l := range i.Lines {
+		i.Counts[l.Status]++
+	}
+}
+
+const importColumns = "id, account_id, format, lines, created_at, committed_at"
+
+func scanImport(s scanner, i *Import) error {
+	var lines []byte
+	if err := s.Scan(&i.ID, &i.AccountID, &i.Format, &lines, &i.CreatedAt, &i.CommittedAt); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(lines, &i.Lines); err != nil {
+		return err
+	}
+	i.count()
+	return nil
+}
+
+// importBatchSize is how many lines a commit records per statement.
+const importBatchSize = 500
+
+// importExpensesSQL records a batch of imported lines, given as a JSON array,
+// except those whose key already went into the account. Only the lines
+// recorded come back.
+const importExpensesSQL = `WITH lines AS (
+		SELECT * FROM jsonb_to_recordset($1::jsonb) AS l(line INT, title TEXT, amount FLOAT, note TEXT, tags JSONB, category_id INT,
+			spent_on DATE, merchant_id INT, account_id INT, kind TEXT, import_key TEXT)
+	), new_tags AS (
+		INSERT INTO tags (name) SELECT DISTINCT jsonb_array_elements_text(tags) FROM lines ON CONFLICT DO NOTHING
+	)
+	INSERT INTO expenses (title, amount, note, tags, category_id, spent_on, merchant_id, account_id, kind, import_key)
+	SELECT title, amount, note, ARRAY(SELECT jsonb_array_elements_text(tags)), category_id, spent_on, merchant_id, account_id, kind, import_key
+	FROM lines ORDER BY line
+	ON CONFLICT (account_id, import_key) WHERE import_key IS NOT NULL DO NOTHING
+	RETURNING id, import_key, to_char(spent_on, 'YYYY-MM-DD')`
+
+// importedLine is a line in the form importExpensesSQL reads.
+type importedLine struct {
+	Line       int      `json:"line"`
+	Title      string   `json:"title"`
+	Amount     float64  `json:"amount"`
+	Note       string   `json:"note"`
+	Tags       []string `json:"tags"`
+	CategoryID *int     `json:"category_id"`
+	SpentOn    string   `json:"spent_on"`
+	MerchantID *int     `json:"merchant_id"`
+	AccountID  *int     `json:"account_id"`
+	Kind       string   `json:"kind"`
+	ImportKey  string   `json:"import_key"`
+}
+
+// importTimeout bounds reading a statement into a preview and committing it,
+// which take longer than a single query for a long statement.
+var importTimeout = time.Minute
+
+// readStatement reads the "file" part of a multipart upload.
+func readStatement(c echo.Context) ([]byte, error) {
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxStatementSize+multipartOverhead)
+	fh, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, ErrStatementTooLarge.WithDetailKey("detail.statement.tooLarge", i18n.Params{"max": maxStatementSize}).Wrap(err)
+	} else if err != nil {
+		return nil, ErrInvalidRequest.WithDetailKey("detail.file.required", nil).Wrap(err)
+	}
+	if fh.Size > maxStatementSize {
+		return nil, ErrStatementTooLarge.WithDetailKey("detail.statement.tooLarge", i18n.Params{"max": maxStatementSize})
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, ErrInvalidRequest.Wrap(err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// CreateImportHandler reads an OFX or QIF statement uploaded as "file" into
+// a preview for ?account_id=. Each line goes through the same merchant guess
+// and rules as a new transaction, and is checked against what was imported
+// into the account before. The format is guessed from the file unless given
+// with ?format=.
+func CreateImportHandler(c echo.Context) error {
+	account, err := strconv.Atoi(c.QueryParam("account_id"))
+	if err != nil {
+		return ErrInvalidRequest.WithDetailKey("detail.import.accountId.required", nil).Wrap(err)
+	}
+	tag := defaultImportTag
+	if s := c.QueryParam("tag"); s != "" {
+		tag = NormalizeTag(s)
+	}
+	dateOrder := c.QueryParam("date_order")
+	switch dateOrder {
+	case "":
+		dateOrder = DateOrderDMY
+	case DateOrderDMY, DateOrderMDY:
+	default:
+		return ErrInvalidRequest.WithDetailKey("detail.import.dateOrder.enum", nil)
+	}
+
+	data, err := readStatement(c)
+	if err != nil {
+		return err
+	}
+	text := decodeStatement(data)
+	format := c.QueryParam("format")
+	if format == "" {
+		if format = detectFormat(text); format == "" {
+			return ErrInvalidStatement.WithDetailKey("detail.statement.format", nil)
+		}
+	}
+	sls, err := parseStatement(text, format, dateOrder)
+	if err != nil {
+		var se *statementError
+		if errors.As(err, &se) {
+			return ErrInvalidStatement.WithDetailKey(se.key(), se.params()).Wrap(err)
+		}
+		return ErrInvalidStatement.WithDetailKey("detail.statement.format", nil).Wrap(err)
+	}
+	if len(sls) == 0 {
+		return ErrInvalidStatement.WithDetailKey("detail.statement.empty", nil)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), importTimeout)
+	defer cancel()
+
+	var a Account
+	err = scanAccount(db.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM accounts WHERE id = $1", account), &a)
+	if err == sql.ErrNoRows {
+		return ErrAccountNotFound.WithDetailKey("detail.account.notFound", i18n.Params{"id": account}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan account error: ", err)
+		return ErrAccountQuery.Wrap(err)
+	}
+
+	rules, err := loadRules(ctx)
+	if err != nil {
+		c.Logger().Error("load rules error: ", err)
+	}
+	// Statements repeat payees, so the merchants are read once and each
+	// title is only matched once. A failure only leaves the guesses out.
+	candidates, err := loadMerchantKeys(ctx)
+	if err != nil {
+		c.Logger().Error("load merchants error: ", err)
+	}
+	merchants := map[string]*int{}
+
+	imp := Import{AccountID: account, Format: format}
+	keys := importKeys(sls)
+	for n, sl := range sls {
+		l := ImportLine{Line: n + 1, Key: keys[n], Status: ImportNew, Transaction: sl.transaction(account, tag)}
+		e := &l.Transaction
+		e.Tags = NormalizeTags(e.Tags)
+		id, ok := merchants[e.Title]
+		if !ok {
+			id = matchMerchant(merchantKey(e.Title), candidates)
+			merchants[e.Title] = id
+		}
+		e.MerchantID = id
+		runRules(rules, e)
+
+		var pe *problem.Error
+		if err := e.Validate(); errors.As(err, &pe) {
+			l.Status, l.Errors = ImportInvalid, pe.Fields
+		}
+		imp.Lines = append(imp.Lines, l)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT import_key FROM expenses WHERE account_id = $1 AND import_key = ANY($2)", account, pq.Array(keys))
+	if err != nil {
+		c.Logger().Error("query import keys error: ", err)
+		return ErrImportQuery.Wrap(err)
+	}
+	defer rows.Close()
+	seen := map[string]bool{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			c.Logger().Error("scan import key error: ", err)
+			return ErrImportQuery.Wrap(err)
+		}
+		seen[key] = true
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate import keys error: ", err)
+		return ErrImportQuery.Wrap(err)
+	}
+	for i := range imp.Lines {
+		l := &imp.Lines[i]
+		if seen[l.Key] {
+			l.Status = ImportDuplicate
+		}
+		seen[l.Key] = true
+	}
+
+	lines, err := json.Marshal(imp.Lines)
+	if err != nil {
+		return ErrImportUpdate.Wrap(err)
+	}
+	err = db.QueryRowContext(ctx, "INSERT INTO imports (account_id, format, lines) VALUES ($1, $2, $3) RETURNING id, created_at",
+		account, format, string(lines)).Scan(&imp.ID, &imp.CreatedAt)
+	if err != nil {
+		c.Logger().Error("insert import error: ", err)
+		return ErrImportUpdate.Wrap(err)
+	}
+	imp.count()
+
+	return c.JSON(http.StatusCreated, imp)
+}
+
+func GetImportHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	imp := Import{}
+	err = scanImport(db.QueryRowContext(ctx, "SELECT "+importColumns+" FROM imports WHERE id = $1", id), &imp)
+	if err == sql.ErrNoRows {
+		return ErrImportNotFound.WithDetailKey("detail.import.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan import error: ", err)
+		return ErrImportQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, imp)
+}
+
+// CommitImportHandler records the new lines of a preview, less any listed in
+// "skip", in one transaction. A line imported by another statement since the
+// preview was made turns out a duplicate instead. An import is committed
+// only once.
+func CommitImportHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+	ic := ImportCommit{}
+	if err := c.Bind(&ic); err != nil {
+		c.Logger().Error("invalid request binding to struct import commit error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	skip := map[int]bool{}
+	for _, n := range ic.Skip {
+		skip[n] = true
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), importTimeout)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrImportUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	imp := Import{}
+	err = scanImport(tx.QueryRowContext(ctx, "SELECT "+importColumns+" FROM imports WHERE id = $1 FOR UPDATE", id), &imp)
+	if err == sql.ErrNoRows {
+		return ErrImportNotFound.WithDetailKey("detail.import.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan import error: ", err)
+		return ErrImportQuery.Wrap(err)
+	}
+	if imp.CommittedAt != nil {
+		return ErrImportCommitted.WithDetailKey("detail.import.committed", i18n.Params{"id": id})
+	}
+
+	var pending []*ImportLine
+	for i := range imp.Lines {
+		l := &imp.Lines[i]
+		if l.Status != ImportNew {
+			continue
+		}
+		if skip[l.Line] {
+			l.Status = ImportSkipped
+			continue
+		}
+		pending = append(pending, l)
+	}
+
+	var created []Expense
+	for len(pending) > 0 {
+		batch := pending
+		if len(batch) > importBatchSize {
+			batch = batch[:importBatchSize]
+		}
+		pending = pending[len(batch):]
+
+		ls, err := importLines(ctx, tx, batch)
+		if err != nil {
+			c.Logger().Error("insert imported transactions error: ", err)
+			return expenseWriteError(err, ErrImportUpdate)
+		}
+		evs := make([]Event, len(ls))
+		for i, l := range ls {
+			evs[i] = newEvent(eventFor(l.Transaction, EventExpenseCreated), l.Transaction)
+			created = append(created, l.Transaction)
+		}
+		if err = enqueueEvents(ctx, tx, evs); err != nil {
+			c.Logger().Error("enqueue events error: ", err)
+			return ErrImportUpdate.Wrap(err)
+		}
+	}
+
+	lines, err := json.Marshal(imp.Lines)
+	if err != nil {
+		return ErrImportUpdate.Wrap(err)
+	}
+	err = tx.QueryRowContext(ctx, "UPDATE imports SET lines = $2, committed_at = now() WHERE id = $1 RETURNING committed_at", id, string(lines)).
+		Scan(&imp.CommittedAt)
+	if err != nil {
+		c.Logger().Error("update import error: ", err)
+		return ErrImportUpdate.Wrap(err)
+	}
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrImportUpdate.Wrap(err)
+	}
+	imp.count()
+
+	for _, e := range created {
+		if kindOf(e) != KindExpense {
+			continue
+		}
+		if err := checkBudgetAlerts(ctx, e); err != nil {
+			c.Logger().Error("check budget alerts error: ", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, imp)
+}
+
+// importLines records a batch of lines and returns those it created. A line
+// whose key went into the account since the preview becomes a duplicate.
+func importLines(ctx context.Context, tx *sql.Tx, batch []*ImportLine) ([]*ImportLine, error) {
+	ils := make([]importedLine, len(batch))
+	byKey := map[string]*ImportLine{}
+	for i, l := range batch {
+		e := l.Transaction
+		ils[i] = importedLine{Line: l.Line, Title: e.Title, Amount: e.Amount, Note: e.Note, Tags: e.Tags, CategoryID: e.CategoryID,
+			SpentOn: e.Date, MerchantID: e.MerchantID, AccountID: e.AccountID, Kind: kindOf(e), ImportKey: l.Key}
+		byKey[l.Key] = l
+		l.Status = ImportDuplicate
+	}
+	data, err := json.Marshal(ils)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, importExpensesSQL, string(data))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var created []*ImportLine
+	for rows.Next() {
+		var id int
+		var key, date string
+		if err = rows.Scan(&id, &key, &date); err != nil {
+			return nil, err
+		}
+		l := byKey[key]
+		l.Transaction.ID, l.Transaction.Date, l.Status = id, date, ImportCreated
+		created = append(created, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].Line < created[j].Line })
+	return created, nil
+}
+
+// DeleteImportHandler discards an import. The transactions of a committed
+// import are kept.
+func DeleteImportHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM imports WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete import error: ", err)
+		return ErrImportUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrImportUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrImportNotFound.WithDetailKey("detail.import.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,190 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+var importRowColumns = []string{"id", "account_id", "format", "lines", "created_at", "committed_at"}
+
+func importContext(t *testing.T, target string, content string) (echo.Context, *httptest.ResponseRecorder) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "statement.qif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	w.Close()
+
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func TestCreateImportHandler(t *testing.T) {
+	t.Run("Test case for a preview with an imported and an invalid line", func(t *testing.T) {
+		qif := "!Type:Bank\nD15/01/2023\nT-60.00\nPNoodle Shop\n^\nD16/01/2023\nT0\nPFree sample\n^\nD20/01/2023\nT500\nPRefund\n^\nD20/01/2023\nT500\nPRefund\n^\n"
+		c, rec := importContext(t, "/imports?account_id=1", qif)
+		keys := []string{"fp:2023-01-15:-6000:noodleshop", "fp:2023-01-16:0:freesample", "fp:2023-01-20:50000:refund", "fp:2023-01-20:50000:refund:2"}
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + accountColumns + " FROM accounts WHERE id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(accountRowColumns).AddRow(1, "wallet", "debit", "THB", 0.0))
+		mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, key FROM merchants ORDER BY id")).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "key"}).AddRow(4, "noodleshop").AddRow(6, "freshmart"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT import_key FROM expenses WHERE account_id = $1 AND import_key = ANY($2)")).WithArgs(1, pq.Array(keys)).
+			WillReturnRows(sqlmock.NewRows([]string{"import_key"}).AddRow(keys[2]))
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO imports (account_id, format, lines) VALUES ($1, $2, $3) RETURNING id, created_at")).
+			WithArgs(1, FormatQIF, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, time.Date(2023, 2, 1, 9, 0, 0, 0, time.UTC)))
+
+		err = CreateImportHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			imp := Import{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &imp))
+			assert.Equal(t, 7, imp.ID)
+			assert.Equal(t, map[string]int{ImportNew: 2, ImportInvalid: 1, ImportDuplicate: 1}, imp.Counts)
+			var statuses []string
+			for _, l := range imp.Lines {
+				statuses = append(statuses, l.Status)
+			}
+			assert.Equal(t, []string{ImportNew, ImportInvalid, ImportDuplicate, ImportNew}, statuses)
+			e := imp.Lines[0].Transaction
+			assert.Equal(t, KindExpense, e.Kind)
+			assert.Equal(t, 60.0, e.Amount)
+			assert.Equal(t, []string{defaultImportTag}, e.Tags)
+			if assert.NotNil(t, e.MerchantID) {
+				assert.Equal(t, 4, *e.MerchantID)
+			}
+			assert.Equal(t, "/amount", imp.Lines[1].Errors[0].Pointer)
+			assert.Equal(t, KindIncome, imp.Lines[2].Transaction.Kind)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an unknown date order", func(t *testing.T) {
+		c, _ := importContext(t, "/imports?account_id=1&date_order=ymd", "!Type:Bank\nD2023/01/15\nT-60\n^\n")
+
+		err := CreateImportHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("Test case for a line that cannot be read", func(t *testing.T) {
+		c, rec := importContext(t, "/imports?account_id=1", "!Type:Bank\nD15/01/2023\nTabc\n^\n")
+		c.Request().Header.Set("Accept-Language", "th")
+
+		err := CreateImportHandler(c)
+		problem.HTTPErrorHandler(err, c)
+
+		assert.ErrorIs(t, err, ErrInvalidStatement)
+		assert.Contains(t, rec.Body.String(), `"detail":"บรรทัด 3: จำนวนเงิน \"abc\" ไม่ถูกต้อง"`)
+	})
+
+	t.Run("Test case for a file that is not a statement", func(t *testing.T) {
+		c, _ := importContext(t, "/imports?account_id=1", "date,amount\n2023-01-15,60\n")
+
+		err := CreateImportHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidStatement)
+	})
+}
+
+func TestCommitImportHandler(t *testing.T) {
+	lines := `[
+		{"line":1,"key":"fitid:1","status":"new","transaction":{"title":"salary","amount":45000,"note":"salary","tags":["imported"],"date":"2023-01-25","account_id":1,"kind":"income"}},
+		{"line":2,"key":"fitid:2","status":"new","transaction":{"title":"cafe","amount":80,"note":"cafe","tags":["imported"],"date":"2023-01-26","account_id":1,"kind":"expense"}},
+		{"line":3,"key":"fitid:3","status":"new","transaction":{"title":"bonus","amount":500,"note":"bonus","tags":["imported"],"date":"2023-01-27","account_id":1,"kind":"income"}},
+		{"line":4,"key":"fitid:4","status":"duplicate","transaction":{"title":"rent","amount":9000,"note":"rent","tags":["imported"],"date":"2023-01-28","account_id":1,"kind":"expense"}}
+	]`
+	created := time.Date(2023, 2, 1, 9, 0, 0, 0, time.UTC)
+
+	t.Run("Test case for committing the new lines", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/imports/7/commit", strings.NewReader(`{"skip":[2]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + importColumns + " FROM imports WHERE id = $1 FOR UPDATE")).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows(importRowColumns).AddRow(7, 1, FormatOFX, []byte(lines), created, nil))
+		// Line 3 went in with another statement since the preview.
+		mock.ExpectQuery(regexp.QuoteMeta(importExpensesSQL)).WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "import_key", "date"}).AddRow(11, "fitid:1", "2023-01-25"))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox (event_id, type, payload) SELECT * FROM unnest($1::text[], $2::text[], $3::jsonb[])")).
+			WithArgs(sqlmock.AnyArg(), pq.Array([]string{EventTransactionCreated}), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE imports SET lines = $2, committed_at = now() WHERE id = $1 RETURNING committed_at")).WithArgs(7, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"committed_at"}).AddRow(created.Add(time.Minute)))
+		mock.ExpectCommit()
+
+		err = CommitImportHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			imp := Import{}
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &imp))
+			assert.Equal(t, map[string]int{ImportCreated: 1, ImportSkipped: 1, ImportDuplicate: 2}, imp.Counts)
+			assert.Equal(t, 11, imp.Lines[0].Transaction.ID)
+			assert.NotNil(t, imp.CommittedAt)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an import committed before", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/imports/7/commit", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("7")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + importColumns + " FROM imports WHERE id = $1 FOR UPDATE")).WithArgs(7).
+			WillReturnRows(sqlmock.NewRows(importRowColumns).AddRow(7, 1, FormatOFX, []byte(lines), created, created))
+		mock.ExpectRollback()
+
+		err = CommitImportHandler(c)
+
+		assert.ErrorIs(t, err, ErrImportCommitted)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,252 @@
+package expense
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	defaultMerchantLimit = 10
+	maxMerchantLimit     = 50
+	// minMerchantPrefix is the shortest merchant key that links an expense
+	// whose title merely starts with it.
+	minMerchantPrefix = 4
+)
+
+// Merchant is a shop or payee shared by many expenses. Count is the number
+// of expenses linked to it.
+type Merchant struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// merchantKey reduces a name to lowercase letters and digits, so "7-Eleven",
+// "7 eleven" and "7ELEVEN" share the key "7eleven".
+func merchantKey(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFC.String(strings.ToLower(name)) {
+		if unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editDistance is the Levenshtein distance between a and b in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// merchantScore rates how well an expense title key matches a merchant key,
+// higher being better, or returns 0 when it does not match. Equal keys beat
+// a title starting with the merchant, which beats a near miss of at most one
+// typo per five characters.
+func merchantScore(title, merchant string) int {
+	n := utf8.RuneCountInString(merchant)
+	switch {
+	case title == merchant:
+		return 3 * 1000
+	case n >= minMerchantPrefix && strings.HasPrefix(title, merchant):
+		return 2*1000 + n
+	}
+	if d := editDistance(title, merchant); d <= n/5 {
+		return 1000 - d
+	}
+	return 0
+}
+
+// suggestMerchant finds the existing merchant an expense title most likely
+// refers to. Only merchants sharing the first character of the title are
+// considered. It returns nil when none is close enough.
+func suggestMerchant(ctx context.Context, title string) (*int, error) {
+	key := merchantKey(title)
+	if key == "" {
+		return nil, nil
+	}
+	first, _ := utf8.DecodeRuneInString(key)
+
+	ms, err := queryMerchantKeys(ctx, "SELECT id, key FROM merchants WHERE left(key, 1) = $1", string(first))
+	if err != nil {
+		return nil, err
+	}
+	return matchMerchant(key, ms), nil
+}
+
+// merchantCandidate is the key of an existing merchant.
+type merchantCandidate struct {
+	id  int
+	key string
+}
+
+// loadMerchantKeys reads the keys of every merchant, for matching many
+// titles without a query each.
+func loadMerchantKeys(ctx context.Context) ([]merchantCandidate, error) {
+	return queryMerchantKeys(ctx, "SELECT id, key FROM merchants ORDER BY id")
+}
+
+func queryMerchantKeys(ctx context.Context, query string, args ...interface{}) ([]merchantCandidate, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ms []merchantCandidate
+	for rows.Next() {
+		m := merchantCandidate{}
+		if err = rows.Scan(&m.id, &m.key); err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, rows.Err()
+}
+
+// matchMerchant picks the merchant the title key matches best out of those
+// sharing its first character, or returns nil.
+func matchMerchant(key string, ms []merchantCandidate) *int {
+	if key == "" {
+		return nil
+	}
+	first, _ := utf8.DecodeRuneInString(key)
+
+	var best *int
+	bestScore := 0
+	for _, m := range ms {
+		if r, _ := utf8.DecodeRuneInString(m.key); r != first {
+			continue
+		}
+		if score := merchantScore(key, m.key); score > bestScore {
+			id := m.id
+			best, bestScore = &id, score
+		}
+	}
+	return best
+}
+
+func CreateMerchantHandler(c echo.Context) error {
+	m := Merchant{}
+	if err := c.Bind(&m); err != nil {
+		c.Logger().Error("invalid request binding to struct merchant error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	m.Name = strings.TrimSpace(m.Name)
+	key := merchantKey(m.Name)
+	if key == "" {
+		return ErrInvalidMerchant.WithFields(problem.NewFieldError("body", "/name", "required", "merchant.name.required", nil))
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err := db.QueryRowContext(ctx, "INSERT INTO merchants (name, key) VALUES ($1, $2) RETURNING id", m.Name, key).Scan(&m.ID)
+	if pqErrorCode(err) == uniqueViolation {
+		return ErrMerchantConflict.WithDetailKey("detail.merchant.conflict", i18n.Params{"name": m.Name}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("insert merchant error: ", err)
+		return ErrMerchantUpdate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, m)
+}
+
+// GetMerchantsHandler suggests merchants whose name starts with ?prefix=,
+// ignoring case and punctuation, the most used first.
+func GetMerchantsHandler(c echo.Context) error {
+	limit := defaultMerchantLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxMerchantLimit {
+			return ErrInvalidRequest.WithDetailKey("detail.limit.range", i18n.Params{"max": maxMerchantLimit})
+		}
+		limit = n
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	// Keys hold only letters and digits, so the prefix needs no escaping.
+	rows, err := db.QueryContext(ctx, `SELECT m.id, m.name, COUNT(e.id) AS count FROM merchants m LEFT JOIN expenses e ON e.merchant_id = m.id
+		WHERE m.key LIKE $1 || '%' GROUP BY m.id ORDER BY count DESC, m.name LIMIT $2`, merchantKey(c.QueryParam("prefix")), limit)
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrMerchantQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	ms := []Merchant{}
+	for rows.Next() {
+		m := Merchant{}
+		if err = rows.Scan(&m.ID, &m.Name, &m.Count); err != nil {
+			c.Logger().Error("scan merchant error: ", err)
+			return ErrMerchantQuery.Wrap(err)
+		}
+		ms = append(ms, m)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate merchants error: ", err)
+		return ErrMerchantQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, ms)
+}
+
+func DeleteMerchantHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM merchants WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete merchant error: ", err)
+		return ErrMerchantUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrMerchantUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrMerchantNotFound.WithDetailKey("detail.merchant.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,186 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+const suggestMerchantSQL = "SELECT id, key FROM merchants WHERE left(key, 1) = $1"
+
+func TestMerchantKey(t *testing.T) {
+	assert.Equal(t, "7eleven", merchantKey("7-Eleven"))
+	assert.Equal(t, "7eleven", merchantKey(" 7 ELEVEN! "))
+	assert.Equal(t, "กาแฟ", merchantKey("กาแฟ."))
+	assert.Equal(t, "", merchantKey("--"))
+}
+
+func TestMerchantScore(t *testing.T) {
+	tests := []struct {
+		name     string
+		title    string
+		merchant string
+		match    bool
+	}{
+		{name: "equal keys", title: "starbucks", merchant: "starbucks", match: true},
+		{name: "a title starting with the merchant", title: "7elevensilom", merchant: "7eleven", match: true},
+		{name: "a short merchant only as a prefix", title: "bkkairport", merchant: "bk", match: false},
+		{name: "one typo in a long name", title: "starbuks", merchant: "starbucks", match: true},
+		{name: "too many typos", title: "stabuks", merchant: "starbucks", match: false},
+		{name: "a different merchant", title: "grab", merchant: "gourmetmarket", match: false},
+	}
+
+	for _, test := range tests {
+		t.Run("Test case for "+test.name, func(t *testing.T) {
+			assert.Equal(t, test.match, merchantScore(test.title, test.merchant) > 0)
+		})
+	}
+
+	t.Run("Test case for an exact match beating a prefix", func(t *testing.T) {
+		assert.Greater(t, merchantScore("7eleven", "7eleven"), merchantScore("7eleven", "7elev"))
+		assert.Greater(t, merchantScore("7elevensilom", "7eleven"), merchantScore("7elevensilom", "7elev"))
+	})
+}
+
+func TestSuggestMerchant(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key"}).AddRow(1, "7elev").AddRow(2, "7eleven").AddRow(3, "7days"))
+
+	id, err := suggestMerchant(context.Background(), "7-Eleven Silom")
+
+	if assert.NoError(t, err) && assert.NotNil(t, id) {
+		assert.Equal(t, 2, *id)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateExpenseHandlerLinksMerchant(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{"title":"Starbucks Siam","amount":150,"note":"latte","tags":["coffee"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WithArgs("s").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "key"}).AddRow(4, "starbucks"))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("Starbucks Siam", 150.0, "latte", pq.Array([]string{"coffee"}), nil, nil, nil, 4, nil, "expense", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-15"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = CreateExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"merchant_id":4`)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateMerchantHandler(t *testing.T) {
+	t.Run("Test case for creating a merchant", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/merchants", strings.NewReader(`{"name":" 7-Eleven "}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO merchants (name, key) VALUES ($1, $2) RETURNING id")).WithArgs("7-Eleven", "7eleven").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		err = CreateMerchantHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Equal(t, `{"id":1,"name":"7-Eleven","count":0}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a merchant with the same key", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/merchants", strings.NewReader(`{"name":"7 eleven"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO merchants (name, key) VALUES ($1, $2) RETURNING id")).
+			WillReturnError(&pq.Error{Code: uniqueViolation})
+
+		err = CreateMerchantHandler(c)
+
+		assert.ErrorIs(t, err, ErrMerchantConflict)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a name without letters or digits", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/merchants", strings.NewReader(`{"name":"--"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		err := CreateMerchantHandler(c)
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.Equal(t, "merchant.name.required", pe.Fields[0].Key)
+		}
+	})
+}
+
+func TestGetMerchantsHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/merchants?prefix=7-El&limit=5", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT m.id, m.name, COUNT(e.id) AS count FROM merchants m")).WithArgs("7el", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "count"}).AddRow(1, "7-Eleven", 12).AddRow(2, "7-Eleven Express", 0))
+
+	err = GetMerchantsHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `[{"id":1,"name":"7-Eleven","count":12},{"id":2,"name":"7-Eleven Express","count":0}]`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
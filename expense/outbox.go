@@ -0,0 +1,328 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+)
+
+const maxOutboxBackoff = 5 * time.Minute
+
+// OutboxMessage is an event claimed from the outbox. Payload is the event as
+// it was written next to the change it describes. Attempts counts every
+// failed publish, WebhookAttempts only those the webhooks failed.
+type OutboxMessage struct {
+	ID              int64
+	EventID         string
+	Type            string
+	Payload         json.RawMessage
+	Attempts        int
+	WebhookAttempts int
+
+	lease time.Time
+}
+
+// Sink publishes outbox messages somewhere. A message is retried until every
+// sink accepts it, so sinks see each message at least once.
+type Sink interface {
+	Publish(ctx context.Context, m OutboxMessage) error
+	Close() error
+}
+
+// sinks builds the sinks that can be named in the outbox config.
+var sinks = map[string]func(cfg config.Outbox) (Sink, error){
+	"webhook": func(config.Outbox) (Sink, error) { return webhookSink{}, nil },
+	"stdout":  func(config.Outbox) (Sink, error) { return &writerSink{w: os.Stdout}, nil },
+	"file": func(cfg config.Outbox) (Sink, error) {
+		f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &writerSink{w: f, c: f}, nil
+	},
+}
+
+// writerSink writes each payload as a line of JSON.
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+	c  io.Closer
+}
+
+func (s *writerSink) Publish(ctx context.Context, m OutboxMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := fmt.Fprintf(s.w, "%s\n", m.Payload)
+	return err
+}
+
+func (s *writerSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// enqueueEvent writes an event to the outbox in the transaction making the
+// change, so the event is published if and only if the change commits.
+func enqueueEvent(ctx context.Context, tx *sql.Tx, event string, data interface{}) error {
+	ev := newEvent(event, data)
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox (event_id, type, payload) VALUES ($1, $2, $3)", ev.ID, ev.Type, string(b))
+	return err
+}
+
+// enqueueEvents writes many events to the outbox in one statement.
+func enqueueEvents(ctx context.Context, tx *sql.Tx, evs []Event) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	ids, types, payloads := make([]string, len(evs)), make([]string, len(evs)), make([]string, len(evs))
+	for i, ev := range evs {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		ids[i], types[i], payloads[i] = ev.ID, ev.Type, string(b)
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO outbox (event_id, type, payload) SELECT * FROM unnest($1::text[], $2::text[], $3::jsonb[])",
+		pq.Array(ids), pq.Array(types), pq.Array(payloads))
+	return err
+}
+
+func newEvent(event string, data interface{}) Event {
+	return Event{ID: newEventID(), Type: event, CreatedAt: now().UTC(), Data: data}
+}
+
+// outboxLease hides a claimed message from other dispatchers while it is
+// published. A dispatcher that dies mid-batch leaves its messages to be
+// claimed again once the lease runs out. The lease is renewed before each
+// message is published, so a slow batch does not outlive it.
+const outboxLease = time.Minute
+
+// claimOutboxSQL leases a batch of pending messages in one statement, so no
+// row lock is held while they are published. SKIP LOCKED lets several
+// dispatchers share the outbox without claiming a message twice at once.
+const claimOutboxSQL = `UPDATE outbox SET available_at = now() + $2 * interval '1 millisecond'
+	WHERE id IN (SELECT id FROM outbox WHERE dispatched_at IS NULL AND available_at <= now()
+		ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+	RETURNING id, event_id, type, payload, attempts, webhook_attempts, available_at`
+
+// renewOutboxSQL extends the lease on message $1 as long as it still ends at
+// $2. Once another dispatcher has claimed the message after the lease ran
+// out, or published it, nothing is updated.
+const renewOutboxSQL = `UPDATE outbox SET available_at = now() + $3 * interval '1 millisecond'
+	WHERE id = $1 AND available_at = $2 AND dispatched_at IS NULL RETURNING available_at`
+
+// retryError lets a sink pick the first wait before a message it rejected is
+// retried, instead of the poll interval.
+type retryError struct {
+	err     error
+	backoff time.Duration
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+type outboxDispatcher struct {
+	sinks    []Sink
+	interval time.Duration
+	batch    int
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var dispatcher *outboxDispatcher
+
+// StartDispatcher publishes outbox messages to the configured sinks in the
+// background until StopDispatcher is called.
+func StartDispatcher(cfg config.Outbox) error {
+	d := &outboxDispatcher{
+		interval: cfg.PollInterval,
+		batch:    cfg.BatchSize,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, name := range cfg.SinkNames() {
+		newSink, ok := sinks[name]
+		if !ok {
+			d.close()
+			return fmt.Errorf("unknown outbox sink %q", name)
+		}
+		s, err := newSink(cfg)
+		if err != nil {
+			d.close()
+			return fmt.Errorf("open outbox sink %s: %w", name, err)
+		}
+		d.sinks = append(d.sinks, s)
+	}
+	// Budget alerts go out whichever sinks are configured.
+	d.sinks = append(d.sinks, alertSink{})
+
+	dispatcher = d
+	go d.run()
+	return nil
+}
+
+// StopDispatcher lets the message being published finish and closes the
+// sinks. Messages
+// left in the outbox are published after the next start.
+func StopDispatcher(ctx context.Context) error {
+	d := dispatcher
+	if d == nil {
+		return nil
+	}
+	dispatcher = nil
+
+	close(d.stop)
+	select {
+	case <-d.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return d.close()
+}
+
+func (d *outboxDispatcher) close() error {
+	var err error
+	for _, s := range d.sinks {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (d *outboxDispatcher) run() {
+	defer close(d.done)
+	for {
+		// Catches up on events whose notification the streams missed.
+		if err := sequenceOutbox(context.Background()); err != nil {
+			log.Printf("sequence outbox error: %v", err)
+		}
+		n, err := d.dispatch(context.Background())
+		if err != nil {
+			log.Printf("dispatch outbox error: %v", err)
+		}
+
+		// A full batch means more messages are probably waiting.
+		wait := d.interval
+		if err == nil && n == d.batch {
+			wait = 0
+		}
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// dispatch publishes one batch and returns how many messages it claimed. A
+// message any sink rejects is retried later with exponential backoff. When
+// the dispatcher is stopped mid-batch, the messages not yet published are
+// handed back.
+func (d *outboxDispatcher) dispatch(ctx context.Context) (int, error) {
+	rows, err := db.QueryContext(ctx, claimOutboxSQL, d.batch, outboxLease.Milliseconds())
+	if err != nil {
+		return 0, err
+	}
+	var ms []OutboxMessage
+	for rows.Next() {
+		m := OutboxMessage{}
+		if err = rows.Scan(&m.ID, &m.EventID, &m.Type, &m.Payload, &m.Attempts, &m.WebhookAttempts, &m.lease); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ms = append(ms, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+
+	for i, m := range ms {
+		select {
+		case <-d.stop:
+			return i, releaseOutbox(ctx, ms[i:])
+		default:
+		}
+
+		err = db.QueryRowContext(ctx, renewOutboxSQL, m.ID, m.lease, outboxLease.Milliseconds()).Scan(&m.lease)
+		if err == sql.ErrNoRows {
+			log.Printf("outbox message %d was claimed by another dispatcher", m.ID)
+			continue
+		}
+		if err != nil {
+			return i, err
+		}
+
+		if perr := d.publish(ctx, m); perr != nil {
+			log.Printf("publish outbox message %d error: %v", m.ID, perr)
+			base := d.interval
+			var re *retryError
+			if errors.As(perr, &re) && re.backoff > 0 {
+				base = re.backoff
+			}
+			_, err = db.ExecContext(ctx, "UPDATE outbox SET attempts = attempts + 1, last_error = $2, available_at = now() + $3 * interval '1 millisecond' WHERE id = $1",
+				m.ID, perr.Error(), outboxBackoff(base, m.Attempts).Milliseconds())
+		} else {
+			_, err = db.ExecContext(ctx, "UPDATE outbox SET dispatched_at = now() WHERE id = $1", m.ID)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(ms), nil
+}
+
+// releaseOutbox ends the lease on messages claimed but not published.
+func releaseOutbox(ctx context.Context, ms []OutboxMessage) error {
+	ids := make([]int64, len(ms))
+	for i, m := range ms {
+		ids[i] = m.ID
+	}
+	_, err := db.ExecContext(ctx, "UPDATE outbox SET available_at = now() WHERE id = ANY($1)", pq.Array(ids))
+	return err
+}
+
+func (d *outboxDispatcher) publish(ctx context.Context, m OutboxMessage) error {
+	for _, s := range d.sinks {
+		if err := s.Publish(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func outboxBackoff(interval time.Duration, attempts int) time.Duration {
+	backoff := interval
+	for i := 0; i < attempts && backoff < maxOutboxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxOutboxBackoff {
+		backoff = maxOutboxBackoff
+	}
+	return backoff
+}
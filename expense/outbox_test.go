@@ -0,0 +1,217 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingSink struct {
+	got []OutboxMessage
+	err error
+}
+
+func (s *recordingSink) Publish(ctx context.Context, m OutboxMessage) error {
+	s.got = append(s.got, m)
+	return s.err
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func TestDispatchOutbox(t *testing.T) {
+	claimColumns := []string{"id", "event_id", "type", "payload", "attempts", "webhook_attempts", "available_at"}
+	lease := time.Date(2023, 1, 20, 9, 0, 0, 0, time.UTC)
+	claimRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(claimColumns).
+			AddRow(1, "e1", EventExpenseCreated, []byte(`{"id":"e1"}`), 0, 0, lease).
+			AddRow(2, "e2", EventExpenseDeleted, []byte(`{"id":"e2"}`), 2, 0, lease)
+	}
+	renew := func(mock sqlmock.Sqlmock, id int) {
+		mock.ExpectQuery(regexp.QuoteMeta(renewOutboxSQL)).WithArgs(id, lease, outboxLease.Milliseconds()).
+			WillReturnRows(sqlmock.NewRows([]string{"available_at"}).AddRow(lease.Add(time.Minute)))
+	}
+
+	t.Run("Test case for publishing a batch", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(claimOutboxSQL)).WithArgs(10, outboxLease.Milliseconds()).WillReturnRows(claimRows())
+		renew(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET dispatched_at = now() WHERE id = $1")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		renew(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET dispatched_at = now() WHERE id = $1")).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		sink := &recordingSink{}
+		d := &outboxDispatcher{sinks: []Sink{sink}, interval: time.Second, batch: 10, stop: make(chan struct{})}
+		n, err := d.dispatch(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		if assert.Len(t, sink.got, 2) {
+			assert.Equal(t, "e1", sink.got[0].EventID)
+			assert.Equal(t, `{"id":"e2"}`, string(sink.got[1].Payload))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for backing off a rejected message", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		retrySQL := "UPDATE outbox SET attempts = attempts + 1, last_error = $2"
+		mock.ExpectQuery(regexp.QuoteMeta(claimOutboxSQL)).WithArgs(10, outboxLease.Milliseconds()).WillReturnRows(claimRows())
+		renew(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta(retrySQL)).WithArgs(1, "sink down", int64(1000)).WillReturnResult(sqlmock.NewResult(0, 1))
+		renew(mock, 2)
+		mock.ExpectExec(regexp.QuoteMeta(retrySQL)).WithArgs(2, "sink down", int64(4000)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d := &outboxDispatcher{sinks: []Sink{&recordingSink{err: errors.New("sink down")}}, interval: time.Second, batch: 10, stop: make(chan struct{})}
+		_, err = d.dispatch(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for the backoff a sink asks for", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		retrySQL := "UPDATE outbox SET attempts = attempts + 1, last_error = $2"
+		mock.ExpectQuery(regexp.QuoteMeta(claimOutboxSQL)).WithArgs(10, outboxLease.Milliseconds()).
+			WillReturnRows(sqlmock.NewRows(claimColumns).AddRow(1, "e1", EventExpenseCreated, []byte(`{"id":"e1"}`), 1, 1, lease))
+		renew(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta(retrySQL)).WithArgs(1, "webhook down", int64(10000)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		sink := &recordingSink{err: &retryError{err: errors.New("webhook down"), backoff: 5 * time.Second}}
+		d := &outboxDispatcher{sinks: []Sink{sink}, interval: time.Second, batch: 10, stop: make(chan struct{})}
+		_, err = d.dispatch(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for skipping a message another dispatcher took over", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(claimOutboxSQL)).WithArgs(10, outboxLease.Milliseconds()).WillReturnRows(claimRows())
+		renew(mock, 1)
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET dispatched_at = now() WHERE id = $1")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		// The lease on message 2 ran out while message 1 was published.
+		mock.ExpectQuery(regexp.QuoteMeta(renewOutboxSQL)).WithArgs(2, lease, outboxLease.Milliseconds()).
+			WillReturnRows(sqlmock.NewRows([]string{"available_at"}))
+
+		sink := &recordingSink{}
+		d := &outboxDispatcher{sinks: []Sink{sink}, interval: time.Second, batch: 10, stop: make(chan struct{})}
+		n, err := d.dispatch(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		if assert.Len(t, sink.got, 1) {
+			assert.Equal(t, "e1", sink.got[0].EventID)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for handing back a batch when stopping", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(claimOutboxSQL)).WithArgs(10, outboxLease.Milliseconds()).WillReturnRows(claimRows())
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET available_at = now() WHERE id = ANY($1)")).WithArgs(pq.Array([]int64{1, 2})).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		sink := &recordingSink{}
+		d := &outboxDispatcher{sinks: []Sink{sink}, interval: time.Second, batch: 10, stop: make(chan struct{})}
+		close(d.stop)
+		n, err := d.dispatch(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.Empty(t, sink.got)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, outboxBackoff(time.Second, 0))
+	assert.Equal(t, 8*time.Second, outboxBackoff(time.Second, 3))
+	assert.Equal(t, maxOutboxBackoff, outboxBackoff(time.Second, 40))
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	s := &writerSink{w: &buf}
+
+	assert.NoError(t, s.Publish(context.Background(), OutboxMessage{Payload: []byte(`{"id":"e1"}`)}))
+	assert.NoError(t, s.Publish(context.Background(), OutboxMessage{Payload: []byte(`{"id":"e2"}`)}))
+
+	assert.Equal(t, "{\"id\":\"e1\"}\n{\"id\":\"e2\"}\n", buf.String())
+	assert.NoError(t, s.Close())
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(HeaderWebhookEvent)
+	}))
+	defer srv.Close()
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(webhookRecipientsSQL)).WithArgs(EventExpenseUpdated, int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow(1, srv.URL, "secret"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhook_deliveries (outbox_id, webhook_id) VALUES ($1, $2) ON CONFLICT DO NOTHING")).WithArgs(int64(3), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	err = webhookSink{}.Publish(context.Background(), OutboxMessage{ID: 3, Type: EventExpenseUpdated, Payload: []byte(`{"id":"e1"}`)})
+
+	assert.NoError(t, err)
+	assert.Equal(t, EventExpenseUpdated, <-received)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStartDispatcher(t *testing.T) {
+	t.Run("Test case for an unknown sink", func(t *testing.T) {
+		err := StartDispatcher(config.Outbox{Sinks: "webhook, kafka", PollInterval: time.Second, BatchSize: 10})
+
+		assert.EqualError(t, err, `unknown outbox sink "kafka"`)
+	})
+
+	t.Run("Test case for stopping without a dispatcher", func(t *testing.T) {
+		assert.NoError(t, StopDispatcher(context.Background()))
+	})
+}
@@ -0,0 +1,302 @@
+package expense
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 100
+)
+
+// RecurringExpense creates a copy of Template on every day its schedule falls
+// on between Start and End. Schedule is an RRULE such as
+// FREQ=MONTHLY;BYMONTHDAY=1 or a cron expression such as "0 0 1 * *".
+// MaterializedThrough is the last day the scheduler has created expenses for.
+type RecurringExpense struct {
+	ID                  int     `json:"id"`
+	Schedule            string  `json:"schedule"`
+	Template            Expense `json:"template"`
+	Start               string  `json:"start,omitempty"`
+	End                 string  `json:"end,omitempty"`
+	MaterializedThrough string  `json:"materialized_through,omitempty"`
+}
+
+const recurringColumns = "id, schedule, title, amount, note, tags, category_id, to_char(start_date, 'YYYY-MM-DD'), COALESCE(to_char(end_date, 'YYYY-MM-DD'), ''), to_char(materialized_through, 'YYYY-MM-DD')"
+
+func scanRecurring(s scanner, r *RecurringExpense) error {
+	t := &r.Template
+	return s.Scan(&r.ID, &r.Schedule, &t.Title, &t.Amount, &t.Note, pq.Array(&t.Tags), &t.CategoryID, &r.Start, &r.End, &r.MaterializedThrough)
+}
+
+// schedule parses the schedule anchored at the start date.
+func (r *RecurringExpense) schedule() (schedule, time.Time, error) {
+	start, err := time.Parse(dateLayout, r.Start)
+	if err != nil {
+		return nil, start, err
+	}
+	s, err := parseSchedule(r.Schedule, start)
+	return s, start, err
+}
+
+// occurrences returns up to limit days after the given day through the given
+// day on which an expense is due.
+func (r *RecurringExpense) occurrences(after, through time.Time, limit int) ([]time.Time, error) {
+	s, start, err := r.schedule()
+	if err != nil {
+		return nil, err
+	}
+	var end time.Time
+	if r.End != "" {
+		if end, err = time.Parse(dateLayout, r.End); err != nil {
+			return nil, err
+		}
+	}
+	return occurrences(s, start, end, after, through, limit), nil
+}
+
+func (r *RecurringExpense) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "recurring."+field+"."+rule, params))
+	}
+
+	start, err := time.Parse(dateLayout, r.Start)
+	if err != nil {
+		add("/start", "start", "format", nil)
+	}
+	if r.End != "" {
+		if end, err := time.Parse(dateLayout, r.End); err != nil {
+			add("/end", "end", "format", nil)
+		} else if end.Before(start) {
+			add("/end", "end", "beforeStart", nil)
+		}
+	}
+	if r.Schedule == "" {
+		add("/schedule", "schedule", "required", nil)
+	} else if _, err := parseSchedule(r.Schedule, start); err != nil {
+		add("/schedule", "schedule", "format", i18n.Params{"reason": err.Error()})
+	}
+
+	// The template is checked like any expense, with its fields reported
+	// under /template.
+	var pe *problem.Error
+	if err := r.Template.Validate(); errors.As(err, &pe) {
+		for _, f := range pe.Fields {
+			f.Pointer = "/template" + f.Pointer
+			fields = append(fields, f)
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidRecurring.WithFields(fields...)
+	}
+	return nil
+}
+
+func bindRecurring(c echo.Context) (RecurringExpense, error) {
+	r := RecurringExpense{}
+	if err := c.Bind(&r); err != nil {
+		c.Logger().Error("invalid request binding to struct recurring expense error: ", err)
+		return r, ErrInvalidRequest.Wrap(err)
+	}
+	r.Schedule = strings.TrimSpace(r.Schedule)
+	if r.Start == "" {
+		r.Start = today().Format(dateLayout)
+	}
+	// The id and date of each expense come from the occurrence.
+	r.Template.ID = 0
+	r.Template.Date = ""
+	r.Template.Tags = NormalizeTags(r.Template.Tags)
+	r.MaterializedThrough = ""
+	return r, r.Validate()
+}
+
+func recurringWriteError(err error, fallback *problem.Error) error {
+	if pqErrorCode(err) == foreignKeyViolation {
+		return ErrInvalidRecurring.WithFields(problem.NewFieldError("body", "/template/category_id", "exists", "expense.category_id.exists", nil)).Wrap(err)
+	}
+	return fallback.Wrap(err)
+}
+
+// CreateRecurringExpenseHandler stores a recurring expense. Occurrences from
+// the start date on, including past ones, are created by the scheduler.
+func CreateRecurringExpenseHandler(c echo.Context) error {
+	r, err := bindRecurring(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	t := r.Template
+	err = db.QueryRowContext(ctx, `WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($5::text[]) ON CONFLICT DO NOTHING)
+		INSERT INTO recurring_expenses (schedule, title, amount, note, tags, category_id, start_date, end_date, materialized_through)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $7::date - 1) RETURNING id, to_char(materialized_through, 'YYYY-MM-DD')`,
+		r.Schedule, t.Title, t.Amount, t.Note, pq.Array(t.Tags), t.CategoryID, r.Start, nullIfEmpty(r.End)).Scan(&r.ID, &r.MaterializedThrough)
+	if err != nil {
+		c.Logger().Error("insert recurring expense error: ", err)
+		return recurringWriteError(err, ErrRecurringUpdate)
+	}
+
+	return c.JSON(http.StatusCreated, r)
+}
+
+func GetRecurringExpensesHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+recurringColumns+" FROM recurring_expenses ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrRecurringQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	rs := []RecurringExpense{}
+	for rows.Next() {
+		r := RecurringExpense{}
+		if err = scanRecurring(rows, &r); err != nil {
+			c.Logger().Error("scan recurring expense error: ", err)
+			return ErrRecurringQuery.Wrap(err)
+		}
+		rs = append(rs, r)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate recurring expenses error: ", err)
+		return ErrRecurringQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, rs)
+}
+
+func getRecurring(c echo.Context) (RecurringExpense, error) {
+	r := RecurringExpense{}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return r, ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = scanRecurring(db.QueryRowContext(ctx, "SELECT "+recurringColumns+" FROM recurring_expenses WHERE id = $1", id), &r)
+	if err == sql.ErrNoRows {
+		return r, ErrRecurringNotFound.WithDetailKey("detail.recurring.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan recurring expense error: ", err)
+		return r, ErrRecurringQuery.Wrap(err)
+	}
+	return r, nil
+}
+
+func GetRecurringExpenseHandler(c echo.Context) error {
+	r, err := getRecurring(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// UpdateRecurringExpenseHandler replaces a recurring expense. Expenses already
+// created are kept; the new schedule applies to days not yet materialized.
+func UpdateRecurringExpenseHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	r, err := bindRecurring(c)
+	if err != nil {
+		return err
+	}
+	r.ID = id
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	t := r.Template
+	err = db.QueryRowContext(ctx, `WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($6::text[]) ON CONFLICT DO NOTHING)
+		UPDATE recurring_expenses SET schedule = $2, title = $3, amount = $4, note = $5, tags = $6, category_id = $7, start_date = $8, end_date = $9,
+		materialized_through = GREATEST(materialized_through, $8::date - 1) WHERE id = $1 RETURNING to_char(materialized_through, 'YYYY-MM-DD')`,
+		id, r.Schedule, t.Title, t.Amount, t.Note, pq.Array(t.Tags), t.CategoryID, r.Start, nullIfEmpty(r.End)).Scan(&r.MaterializedThrough)
+	if err == sql.ErrNoRows {
+		return ErrRecurringNotFound.WithDetailKey("detail.recurring.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("update recurring expense error: ", err)
+		return recurringWriteError(err, ErrRecurringUpdate)
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
+
+// DeleteRecurringExpenseHandler stops a recurring expense. Expenses it already
+// created are kept and lose their link to it.
+func DeleteRecurringExpenseHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM recurring_expenses WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete recurring expense error: ", err)
+		return ErrRecurringUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrRecurringUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrRecurringNotFound.WithDetailKey("detail.recurring.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PreviewRecurringExpenseHandler lists the dates of the next n expenses the
+// scheduler will create.
+func PreviewRecurringExpenseHandler(c echo.Context) error {
+	n := defaultPreviewCount
+	if s := c.QueryParam("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 1 || n > maxPreviewCount {
+			return ErrInvalidRequest.WithDetailKey("detail.recurring.n.range", i18n.Params{"max": maxPreviewCount})
+		}
+	}
+
+	r, err := getRecurring(c)
+	if err != nil {
+		return err
+	}
+
+	after, err := time.Parse(dateLayout, r.MaterializedThrough)
+	if err != nil {
+		return ErrRecurringQuery.Wrap(err)
+	}
+	days, err := r.occurrences(after, time.Time{}, n)
+	if err != nil {
+		c.Logger().Error("parse recurring schedule error: ", err)
+		return ErrRecurringQuery.Wrap(err)
+	}
+
+	dates := make([]string, len(days))
+	for i, day := range days {
+		dates[i] = day.Format(dateLayout)
+	}
+	return c.JSON(http.StatusOK, dates)
+}
@@ -0,0 +1,178 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+var recurringRowColumns = []string{"id", "schedule", "title", "amount", "note", "tags", "category_id", "start", "end", "materialized_through"}
+
+func TestRecurringExpenseValidate(t *testing.T) {
+	template := Expense{Title: "rent", Amount: 12000, Note: "condo", Tags: []string{"home"}}
+
+	t.Run("Test case for a valid recurring expense", func(t *testing.T) {
+		r := RecurringExpense{Schedule: "FREQ=MONTHLY", Template: template, Start: "2023-01-01"}
+
+		assert.NoError(t, r.Validate())
+	})
+
+	t.Run("Test case for reporting template fields under the template", func(t *testing.T) {
+		r := RecurringExpense{Schedule: "0 0 1 * *", Template: Expense{Title: "rent", Note: "condo", Tags: []string{"home"}}, Start: "2023-01-01", End: "2022-12-31"}
+
+		err := r.Validate()
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.EqualError(t, err, "end must not be before start; amount is required and must be greater than 0")
+			assert.Equal(t, "/end", pe.Fields[0].Pointer)
+			assert.Equal(t, "/template/amount", pe.Fields[1].Pointer)
+		}
+	})
+
+	t.Run("Test case for an invalid schedule", func(t *testing.T) {
+		r := RecurringExpense{Schedule: "FREQ=SECONDLY", Template: template, Start: "2023-01-01"}
+
+		err := r.Validate()
+
+		assert.EqualError(t, err, `schedule must be an RRULE or a cron expression: unsupported FREQ "SECONDLY"`)
+	})
+}
+
+func TestCreateRecurringExpenseHandler(t *testing.T) {
+	body := `{"schedule":"FREQ=MONTHLY;BYMONTHDAY=1","template":{"title":"rent","amount":12000,"note":"condo","tags":["Home"]},"start":"2023-01-01"}`
+	req := httptest.NewRequest(http.MethodPost, "/recurring-expenses", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO recurring_expenses")).
+		WithArgs("FREQ=MONTHLY;BYMONTHDAY=1", "rent", 12000.0, "condo", pq.Array([]string{"home"}), nil, "2023-01-01", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "materialized_through"}).AddRow(1, "2022-12-31"))
+
+	err = CreateRecurringExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"schedule":"FREQ=MONTHLY;BYMONTHDAY=1","template":{"id":0,"title":"rent","amount":12000,"note":"condo","tags":["home"]},"start":"2023-01-01","materialized_through":"2022-12-31"}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreviewRecurringExpenseHandler(t *testing.T) {
+	t.Run("Test case for the next occurrences", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/recurring-expenses/1/preview?n=3", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/:id/preview")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + recurringColumns + " FROM recurring_expenses WHERE id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(recurringRowColumns).AddRow(1, "FREQ=WEEKLY;BYDAY=FR", "lunch", 200.0, "team", "{food}", nil, "2023-01-01", "", "2023-01-06"))
+
+		err = PreviewRecurringExpenseHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `["2023-01-13","2023-01-20","2023-01-27"]`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for too many occurrences", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/recurring-expenses/1/preview?n=101", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		err := PreviewRecurringExpenseHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestMaterialize(t *testing.T) {
+	claimSQL := regexp.QuoteMeta(claimRecurringSQL)
+	insertSQL := regexp.QuoteMeta("INSERT INTO expenses (title, amount, note, tags, category_id, spent_on, recurring_id, merchant_id)")
+	outboxSQL := regexp.QuoteMeta("INSERT INTO outbox (event_id, type, payload) VALUES ($1, $2, $3)")
+	advanceSQL := regexp.QuoteMeta("UPDATE recurring_expenses SET materialized_through = $2 WHERE id = $1")
+	rules := []Rule{{ID: 1, Name: "fixed costs", Conditions: []Condition{{Field: "title", Contains: "rent"}}, Actions: []Action{{Type: "add_tag", Tag: "fixed"}}}}
+
+	t.Run("Test case for catching up on missed days", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(claimSQL).WithArgs(1, "2023-03-10").
+			WillReturnRows(sqlmock.NewRows(recurringRowColumns).AddRow(1, "0 0 1 * *", "rent", 12000.0, "condo", "{home}", nil, "2023-01-01", "", "2023-01-31"))
+		mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WithArgs("r").WillReturnRows(sqlmock.NewRows([]string{"id", "key"}).AddRow(5, "rent"))
+		mock.ExpectQuery(insertSQL).WithArgs("rent", 12000.0, "condo", pq.Array([]string{"home", "fixed"}), nil, "2023-02-01", 1, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectExec(outboxSQL).WithArgs(sqlmock.AnyArg(), EventExpenseCreated, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		// The March occurrence already exists, so nothing is inserted or published.
+		mock.ExpectQuery(insertSQL).WithArgs("rent", 12000.0, "condo", pq.Array([]string{"home", "fixed"}), nil, "2023-03-01", 1, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectExec(advanceSQL).WithArgs(1, "2023-03-10").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery(regexp.QuoteMeta(matchingBudgetsSQL)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		n, err := materialize(context.Background(), 1, date("2023-03-10"), rules)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a recurring expense claimed elsewhere", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(claimSQL).WithArgs(1, "2023-03-10").WillReturnRows(sqlmock.NewRows(recurringRowColumns))
+		mock.ExpectRollback()
+
+		n, err := materialize(context.Background(), 1, date("2023-03-10"), rules)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestStopSchedulerWithoutScheduler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, StopScheduler(ctx))
+}
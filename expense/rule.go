@@ -0,0 +1,581 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const (
+	ConditionTitle    = "title"
+	ConditionNote     = "note"
+	ConditionAmount   = "amount"
+	ConditionMerchant = "merchant_id"
+
+	ActionAddTag      = "add_tag"
+	ActionRemoveTag   = "remove_tag"
+	ActionSetCategory = "set_category"
+	ActionSetNote     = "set_note"
+)
+
+// reapplyBatchSize is how many expenses the re-apply job reads at a time.
+const reapplyBatchSize = 200
+
+// Rule changes an expense when all of its conditions match. Rules run in
+// ascending priority, ties by id, and each sees the expense as left by the
+// rules before it, so a later rule setting the category wins.
+type Rule struct {
+	ID         int         `json:"id"`
+	Name       string      `json:"name"`
+	Priority   int         `json:"priority"`
+	Conditions []Condition `json:"conditions"`
+	Actions    []Action    `json:"actions"`
+}
+
+// Condition tests one field of an expense. The title and note match with
+// either Contains, ignoring case, or Regex; the amount lies between Min and
+// Max, both inclusive and either optional; the merchant equals MerchantID.
+type Condition struct {
+	Field      string   `json:"field"`
+	Contains   string   `json:"contains,omitempty"`
+	Regex      string   `json:"regex,omitempty"`
+	Min        *float64 `json:"min,omitempty"`
+	Max        *float64 `json:"max,omitempty"`
+	MerchantID *int     `json:"merchant_id,omitempty"`
+
+	re *regexp.Regexp
+}
+
+type Action struct {
+	Type       string `json:"type"`
+	Tag        string `json:"tag,omitempty"`
+	CategoryID *int   `json:"category_id,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+const ruleColumns = "id, name, priority, conditions, actions"
+
+func scanRule(s scanner, r *Rule) error {
+	var conditions, actions []byte
+	if err := s.Scan(&r.ID, &r.Name, &r.Priority, &conditions, &actions); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(conditions, &r.Conditions); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(actions, &r.Actions); err != nil {
+		return err
+	}
+	for i := range r.Conditions {
+		if err := r.Conditions[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", r.ID, err)
+		}
+	}
+	return nil
+}
+
+func (c *Condition) compile() error {
+	if c.Regex == "" {
+		c.re = nil
+		return nil
+	}
+	re, err := regexp.Compile(c.Regex)
+	c.re = re
+	return err
+}
+
+func (r *Rule) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "rule."+field+"."+rule, params))
+	}
+
+	if r.Name == "" {
+		add("/name", "name", "required", nil)
+	}
+
+	if len(r.Conditions) == 0 {
+		add("/conditions", "conditions", "minItems", nil)
+	}
+	for i := range r.Conditions {
+		c := &r.Conditions[i]
+		pointer := fmt.Sprintf("/conditions/%d", i)
+		switch c.Field {
+		case ConditionTitle, ConditionNote:
+			if (c.Contains == "") == (c.Regex == "") {
+				add(pointer, "condition.text", "oneOf", nil)
+			} else if err := c.compile(); err != nil {
+				add(pointer+"/regex", "condition.regex", "pattern", i18n.Params{"error": err.Error()})
+			}
+		case ConditionAmount:
+			switch {
+			case c.Min == nil && c.Max == nil:
+				add(pointer, "condition.amount", "required", nil)
+			case c.Min != nil && c.Max != nil && *c.Max < *c.Min:
+				add(pointer+"/max", "condition.max", "belowMin", nil)
+			}
+		case ConditionMerchant:
+			if c.MerchantID == nil {
+				add(pointer+"/merchant_id", "condition.merchant_id", "required", nil)
+			}
+		default:
+			add(pointer+"/field", "condition.field", "enum", nil)
+		}
+	}
+
+	if len(r.Actions) == 0 {
+		add("/actions", "actions", "minItems", nil)
+	}
+	for i, a := range r.Actions {
+		pointer := fmt.Sprintf("/actions/%d", i)
+		switch a.Type {
+		case ActionAddTag, ActionRemoveTag:
+			if !validator.tagPattern.MatchString(a.Tag) {
+				fields = append(fields, problem.NewFieldError("body", pointer+"/tag", "pattern", "expense.tags.pattern", i18n.Params{"tag": a.Tag}))
+			}
+		case ActionSetCategory:
+			if a.CategoryID == nil {
+				add(pointer+"/category_id", "action.category_id", "required", nil)
+			}
+		case ActionSetNote:
+			if a.Note == "" {
+				add(pointer+"/note", "action.note", "required", nil)
+			}
+		default:
+			add(pointer+"/type", "action.type", "enum", nil)
+		}
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidRule.WithFields(fields...)
+	}
+	return nil
+}
+
+func (c *Condition) matches(e *Expense) bool {
+	switch c.Field {
+	case ConditionTitle, ConditionNote:
+		text := e.Title
+		if c.Field == ConditionNote {
+			text = e.Note
+		}
+		if c.re != nil {
+			return c.re.MatchString(text)
+		}
+		return strings.Contains(strings.ToLower(text), strings.ToLower(c.Contains))
+	case ConditionAmount:
+		return (c.Min == nil || e.Amount >= *c.Min) && (c.Max == nil || e.Amount <= *c.Max)
+	case ConditionMerchant:
+		return e.MerchantID != nil && *e.MerchantID == *c.MerchantID
+	}
+	return false
+}
+
+func (r *Rule) matches(e *Expense) bool {
+	for i := range r.Conditions {
+		if !r.Conditions[i].matches(e) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) apply(e *Expense) {
+	for _, a := range r.Actions {
+		switch a.Type {
+		case ActionAddTag:
+			if !hasTag(e.Tags, a.Tag) {
+				e.Tags = append(e.Tags, a.Tag)
+			}
+		case ActionRemoveTag:
+			tags := make([]string, 0, len(e.Tags))
+			for _, tag := range e.Tags {
+				if tag != a.Tag {
+					tags = append(tags, tag)
+				}
+			}
+			e.Tags = tags
+		case ActionSetCategory:
+			id := *a.CategoryID
+			e.CategoryID = &id
+		case ActionSetNote:
+			e.Note = a.Note
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// runRules applies every matching rule to e in order and returns the rules
+// that fired.
+func runRules(rules []Rule, e *Expense) []Rule {
+	fired := []Rule{}
+	for i := range rules {
+		if rules[i].matches(e) {
+			rules[i].apply(e)
+			fired = append(fired, rules[i])
+		}
+	}
+	return fired
+}
+
+func loadRules(ctx context.Context) ([]Rule, error) {
+	rows, err := db.QueryContext(ctx, "SELECT "+ruleColumns+" FROM rules ORDER BY priority, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rs []Rule
+	for rows.Next() {
+		r := Rule{}
+		if err = scanRule(rows, &r); err != nil {
+			return nil, err
+		}
+		rs = append(rs, r)
+	}
+	return rs, rows.Err()
+}
+
+// applyRules runs the saved rules on an incoming expense and returns the
+// rules that fired.
+func applyRules(ctx context.Context, e *Expense) ([]Rule, error) {
+	rules, err := loadRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return runRules(rules, e), nil
+}
+
+func bindRule(c echo.Context) (Rule, error) {
+	r := Rule{}
+	if err := c.Bind(&r); err != nil {
+		c.Logger().Error("invalid request binding to struct rule error: ", err)
+		return r, ErrInvalidRequest.Wrap(err)
+	}
+	r.Name = strings.TrimSpace(r.Name)
+	for i := range r.Actions {
+		if r.Actions[i].Tag != "" {
+			r.Actions[i].Tag = NormalizeTag(r.Actions[i].Tag)
+		}
+	}
+	return r, r.Validate()
+}
+
+func ruleJSON(r Rule) (string, string, error) {
+	conditions, err := json.Marshal(r.Conditions)
+	if err != nil {
+		return "", "", err
+	}
+	actions, err := json.Marshal(r.Actions)
+	return string(conditions), string(actions), err
+}
+
+func CreateRuleHandler(c echo.Context) error {
+	r, err := bindRule(c)
+	if err != nil {
+		return err
+	}
+	conditions, actions, err := ruleJSON(r)
+	if err != nil {
+		return ErrRuleUpdate.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, "INSERT INTO rules (name, priority, conditions, actions) VALUES ($1, $2, $3, $4) RETURNING id",
+		r.Name, r.Priority, conditions, actions).Scan(&r.ID)
+	if err != nil {
+		c.Logger().Error("insert rule error: ", err)
+		return ErrRuleUpdate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, r)
+}
+
+func GetRulesHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rs, err := loadRules(ctx)
+	if err != nil {
+		c.Logger().Error("query rules error: ", err)
+		return ErrRuleQuery.Wrap(err)
+	}
+	if rs == nil {
+		rs = []Rule{}
+	}
+
+	return c.JSON(http.StatusOK, rs)
+}
+
+func GetRuleHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	r := Rule{}
+	err = scanRule(db.QueryRowContext(ctx, "SELECT "+ruleColumns+" FROM rules WHERE id = $1", id), &r)
+	if err == sql.ErrNoRows {
+		return ErrRuleNotFound.WithDetailKey("detail.rule.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan rule error: ", err)
+		return ErrRuleQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
+
+func UpdateRuleHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	r, err := bindRule(c)
+	if err != nil {
+		return err
+	}
+	r.ID = id
+	conditions, actions, err := ruleJSON(r)
+	if err != nil {
+		return ErrRuleUpdate.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "UPDATE rules SET name = $2, priority = $3, conditions = $4, actions = $5 WHERE id = $1",
+		id, r.Name, r.Priority, conditions, actions)
+	if err != nil {
+		c.Logger().Error("update rule error: ", err)
+		return ErrRuleUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrRuleUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrRuleNotFound.WithDetailKey("detail.rule.notFound", i18n.Params{"id": id})
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
+
+func DeleteRuleHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM rules WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete rule error: ", err)
+		return ErrRuleUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrRuleUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrRuleNotFound.WithDetailKey("detail.rule.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RuleDryRun is what the saved rules would do to a sample expense.
+type RuleDryRun struct {
+	Fired   []Rule  `json:"fired"`
+	Expense Expense `json:"expense"`
+}
+
+// DryRunRulesHandler runs the saved rules on a sample expense the way
+// creating it would, merchant guess included, without saving anything.
+func DryRunRulesHandler(c echo.Context) error {
+	e := Expense{}
+	if err := c.Bind(&e); err != nil {
+		c.Logger().Error("invalid request binding to struct exepnse error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	e.Tags = NormalizeTags(e.Tags)
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	if e.MerchantID == nil {
+		m, err := suggestMerchant(ctx, e.Title)
+		if err != nil {
+			c.Logger().Error("suggest merchant error: ", err)
+			return ErrMerchantQuery.Wrap(err)
+		}
+		e.MerchantID = m
+	}
+
+	fired, err := applyRules(ctx, &e)
+	if err != nil {
+		c.Logger().Error("apply rules error: ", err)
+		return ErrRuleQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, RuleDryRun{Fired: fired, Expense: e})
+}
+
+// RuleRun counts the expenses the re-apply job looked at, changed and could
+// not change, such as when a rule leaves an expense invalid.
+type RuleRun struct {
+	Checked int `json:"checked"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+// reapplyRuleSQL saves what the rules changed and registers any new tag.
+const reapplyRuleSQL = "WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($3::text[]) ON CONFLICT DO NOTHING) UPDATE expenses SET note = $2, tags = $3, category_id = $4 WHERE id = $1"
+
+// ApplyRulesHandler runs the saved rules over existing expenses, narrowed by
+// the usual filters. Expenses are read in batches and each change is saved
+// in its own transaction, so one bad expense does not hold up the rest and
+// a long run is not cut short by the query timeout.
+func ApplyRulesHandler(c echo.Context) error {
+	f := FilterFromQuery(c)
+
+	ctx, cancel := queryContext(c)
+	rules, err := loadRules(ctx)
+	cancel()
+	if err != nil {
+		c.Logger().Error("query rules error: ", err)
+		return ErrRuleQuery.Wrap(err)
+	}
+
+	run := RuleRun{}
+	for last := 0; ; {
+		es, err := expenseBatch(c, f, last)
+		if err != nil {
+			c.Logger().Error("query expenses error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+		if len(es) == 0 {
+			break
+		}
+		last = es[len(es)-1].ID
+
+		for _, e := range es {
+			run.Checked++
+			before := e
+			before.Tags = append([]string(nil), e.Tags...)
+			if len(runRules(rules, &e)) == 0 || !rulesChanged(before, e) {
+				continue
+			}
+			updated, err := reapplyRules(c, rules, e.ID)
+			if err != nil {
+				c.Logger().Errorf("re-apply rules to expense %d error: %v", e.ID, err)
+				run.Failed++
+				continue
+			}
+			if updated {
+				run.Updated++
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+func expenseBatch(c echo.Context, f Filter, after int) ([]Expense, error) {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	q := &query{}
+	f.apply(q)
+	q.add("id > ?", after)
+	rows, err := db.QueryContext(ctx, "SELECT "+expenseColumns+" FROM expenses"+q.where()+" ORDER BY id LIMIT "+strconv.Itoa(reapplyBatchSize), q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var es []Expense
+	for rows.Next() {
+		e := Expense{}
+		if err = scanExpense(rows, &e); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+func rulesChanged(before, after Expense) bool {
+	if before.Note != after.Note || len(before.Tags) != len(after.Tags) {
+		return true
+	}
+	for i := range before.Tags {
+		if before.Tags[i] != after.Tags[i] {
+			return true
+		}
+	}
+	if (before.CategoryID == nil) != (after.CategoryID == nil) {
+		return true
+	}
+	return before.CategoryID != nil && *before.CategoryID != *after.CategoryID
+}
+
+// reapplyRules runs the rules again over the expense as it is now, locked
+// until the change is saved, so an edit made since the batch was read is
+// neither lost nor overwritten. It reports whether the expense changed; one
+// deleted in the meantime is left alone.
+func reapplyRules(c echo.Context, rules []Rule, id int) (bool, error) {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	e := Expense{}
+	err = scanExpense(tx.QueryRowContext(ctx, "SELECT "+expenseColumns+" FROM expenses WHERE id = $1 FOR UPDATE", id), &e)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	before := e
+	before.Tags = append([]string(nil), e.Tags...)
+	if len(runRules(rules, &e)) == 0 || !rulesChanged(before, e) {
+		return false, nil
+	}
+	if err = e.Validate(); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, reapplyRuleSQL, e.ID, e.Note, pq.Array(e.Tags), e.CategoryID); err != nil {
+		return false, err
+	}
+	if err = enqueueEvent(ctx, tx, EventExpenseUpdated, e); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,230 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+const loadRulesSQL = "SELECT id, name, priority, conditions, actions FROM rules ORDER BY priority, id"
+
+var ruleRowColumns = []string{"id", "name", "priority", "conditions", "actions"}
+
+var expenseRowColumns = []string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}
+
+func compiledRule(t *testing.T, r Rule) Rule {
+	for i := range r.Conditions {
+		if err := r.Conditions[i].compile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func TestRuleValidate(t *testing.T) {
+	min, max := 100.0, 50.0
+	r := Rule{
+		Conditions: []Condition{
+			{Field: ConditionTitle},
+			{Field: ConditionNote, Regex: "(taxi"},
+			{Field: ConditionAmount, Min: &min, Max: &max},
+			{Field: ConditionMerchant},
+			{Field: "date"},
+		},
+		Actions: []Action{
+			{Type: ActionAddTag, Tag: "#bad"},
+			{Type: ActionSetCategory},
+			{Type: ActionSetNote},
+			{Type: "delete"},
+		},
+	}
+
+	err := r.Validate()
+
+	var pe *problem.Error
+	if assert.ErrorAs(t, err, &pe) {
+		var got []string
+		for _, f := range pe.Fields {
+			got = append(got, f.Pointer+" "+f.Rule)
+		}
+		assert.Equal(t, []string{
+			"/name required",
+			"/conditions/0 oneOf",
+			"/conditions/1/regex pattern",
+			"/conditions/2/max belowMin",
+			"/conditions/3/merchant_id required",
+			"/conditions/4/field enum",
+			"/actions/0/tag pattern",
+			"/actions/1/category_id required",
+			"/actions/2/note required",
+			"/actions/3/type enum",
+		}, got)
+	}
+}
+
+func TestRunRules(t *testing.T) {
+	min := 500.0
+	merchant, food, dining := 7, 1, 2
+	rules := []Rule{
+		compiledRule(t, Rule{ID: 1, Conditions: []Condition{{Field: ConditionTitle, Contains: "GRAB"}},
+			Actions: []Action{{Type: ActionAddTag, Tag: "transport"}, {Type: ActionRemoveTag, Tag: "misc"}}}),
+		compiledRule(t, Rule{ID: 2, Conditions: []Condition{{Field: ConditionNote, Regex: `^airport\b`}, {Field: ConditionAmount, Min: &min}},
+			Actions: []Action{{Type: ActionSetNote, Note: "airport ride"}}}),
+		compiledRule(t, Rule{ID: 3, Conditions: []Condition{{Field: ConditionMerchant, MerchantID: &merchant}},
+			Actions: []Action{{Type: ActionSetCategory, CategoryID: &food}}}),
+		compiledRule(t, Rule{ID: 4, Conditions: []Condition{{Field: ConditionTitle, Contains: "food"}},
+			Actions: []Action{{Type: ActionSetCategory, CategoryID: &dining}}}),
+	}
+
+	t.Run("Test case for matching rules in order", func(t *testing.T) {
+		e := Expense{Title: "Grab Food", Amount: 600, Note: "airport pickup", Tags: []string{"misc", "transport"}, MerchantID: &merchant}
+
+		fired := runRules(rules, &e)
+
+		var ids []int
+		for _, r := range fired {
+			ids = append(ids, r.ID)
+		}
+		assert.Equal(t, []int{1, 2, 3, 4}, ids)
+		assert.Equal(t, []string{"transport"}, e.Tags)
+		assert.Equal(t, "airport ride", e.Note)
+		assert.Equal(t, &dining, e.CategoryID)
+	})
+
+	t.Run("Test case for a condition that does not match", func(t *testing.T) {
+		e := Expense{Title: "taxi", Amount: 100, Note: "airport", Tags: []string{"travel"}}
+
+		fired := runRules(rules, &e)
+
+		assert.Empty(t, fired)
+		assert.Equal(t, Expense{Title: "taxi", Amount: 100, Note: "airport", Tags: []string{"travel"}}, e)
+	})
+}
+
+func TestCreateRuleHandler(t *testing.T) {
+	body := `{"name":" grab ","priority":10,"conditions":[{"field":"title","contains":"grab"}],"actions":[{"type":"add_tag","tag":"Transport"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/rules", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO rules (name, priority, conditions, actions) VALUES ($1, $2, $3, $4) RETURNING id")).
+		WithArgs("grab", 10, `[{"field":"title","contains":"grab"}]`, `[{"type":"add_tag","tag":"transport"}]`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	err = CreateRuleHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"name":"grab","priority":10,"conditions":[{"field":"title","contains":"grab"}],"actions":[{"type":"add_tag","tag":"transport"}]}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateExpenseHandlerAppliesRules(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`{"title":"grab home","amount":120,"note":"rainy","tags":["misc"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns).
+		AddRow(1, "grab", 0, `[{"field":"title","regex":"^grab\\b"}]`, `[{"type":"add_tag","tag":"transport"},{"type":"remove_tag","tag":"misc"}]`))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("grab home", 120.0, "rainy", pq.Array([]string{"transport"}), nil, nil, nil, nil, nil, "expense", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-15"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = CreateExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"tags":["transport"]`)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDryRunRulesHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/rules/dry-run", strings.NewReader(`{"title":"Grab","amount":80,"note":"office","tags":["work"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns).
+		AddRow(1, "grab", 0, `[{"field":"title","contains":"grab"}]`, `[{"type":"add_tag","tag":"transport"}]`).
+		AddRow(2, "big", 5, `[{"field":"amount","min":1000}]`, `[{"type":"add_tag","tag":"big"}]`))
+
+	err = DryRunRulesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"fired":[{"id":1,"name":"grab","priority":0,"conditions":[{"field":"title","contains":"grab"}],"actions":[{"type":"add_tag","tag":"transport"}]}],`+
+			`"expense":{"id":0,"title":"Grab","amount":80,"note":"office","tags":["work","transport"]}}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyRulesHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/rules/apply?tag=misc", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	batchSQL := "SELECT " + expenseColumns + " FROM expenses WHERE tags @> ARRAY[$1] AND kind = $2 AND id > $3 ORDER BY id LIMIT 200"
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns).
+		AddRow(1, "grab", 0, `[{"field":"title","contains":"grab"}]`, `[{"type":"add_tag","tag":"transport"},{"type":"set_category","category_id":3}]`))
+	mock.ExpectQuery(regexp.QuoteMeta(batchSQL)).WithArgs("misc", KindExpense, 0).WillReturnRows(sqlmock.NewRows(expenseRowColumns).
+		AddRow(4, "grab home", 120.0, "rainy", pq.Array([]string{"misc"}), nil, "2023-01-15", nil, nil, nil).
+		AddRow(9, "lunch", 60.0, "noodles", pq.Array([]string{"misc"}), nil, "2023-01-16", nil, nil, nil))
+	mock.ExpectBegin()
+	// Edited since the batch was read; the rules run over the new note.
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + expenseColumns + " FROM expenses WHERE id = $1 FOR UPDATE")).WithArgs(4).WillReturnRows(sqlmock.NewRows(expenseRowColumns).
+		AddRow(4, "grab home", 120.0, "stormy", pq.Array([]string{"misc"}), nil, "2023-01-15", nil, nil, nil))
+	mock.ExpectExec(regexp.QuoteMeta(reapplyRuleSQL)).WithArgs(4, "stormy", pq.Array([]string{"misc", "transport"}), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(batchSQL)).WithArgs("misc", KindExpense, 9).WillReturnRows(sqlmock.NewRows(expenseRowColumns))
+
+	err = ApplyRulesHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"checked":2,"updated":1,"failed":0}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
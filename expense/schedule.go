@@ -0,0 +1,321 @@
+package expense
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleYears bounds how far ahead occurrences are searched, so a
+// schedule that never matches, such as February 30th, cannot loop forever.
+const maxScheduleYears = 10
+
+// schedule decides which days a recurring expense falls on. Schedules work on
+// whole days; the time fields of a cron expression are accepted but ignored.
+type schedule interface {
+	matches(day time.Time) bool
+	// count is the number of occurrences, counted from the start, or 0 when
+	// the schedule does not limit them.
+	count() int
+}
+
+// parseSchedule reads an RRULE, e.g. FREQ=MONTHLY;BYMONTHDAY=1 with an
+// optional RRULE: prefix, or a five-field cron expression. start anchors the
+// intervals and defaults of an RRULE, like DTSTART.
+func parseSchedule(s string, start time.Time) (schedule, error) {
+	s = strings.TrimSpace(s)
+	if rule := strings.TrimPrefix(strings.ToUpper(s), "RRULE:"); strings.HasPrefix(rule, "FREQ=") {
+		return parseRRule(rule, start)
+	}
+	return parseCron(s)
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+type rrule struct {
+	start      time.Time
+	freq       string
+	interval   int
+	byDay      map[time.Weekday]bool
+	byMonthDay []int
+	byMonth    map[time.Month]bool
+	n          int
+	until      time.Time
+}
+
+func parseRRule(s string, start time.Time) (*rrule, error) {
+	r := &rrule{start: start, interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("rrule part %q is not KEY=VALUE", part)
+		}
+		var err error
+		switch key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.freq = value
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL":
+			if r.interval, err = strconv.Atoi(value); err != nil || r.interval < 1 {
+				return nil, fmt.Errorf("INTERVAL must be a positive integer")
+			}
+		case "COUNT":
+			if r.n, err = strconv.Atoi(value); err != nil || r.n < 1 {
+				return nil, fmt.Errorf("COUNT must be a positive integer")
+			}
+		case "UNTIL":
+			if len(value) < 8 {
+				return nil, fmt.Errorf("UNTIL must start with YYYYMMDD")
+			}
+			if r.until, err = time.Parse("20060102", value[:8]); err != nil {
+				return nil, fmt.Errorf("UNTIL must start with YYYYMMDD")
+			}
+		case "BYDAY":
+			r.byDay = map[time.Weekday]bool{}
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := weekdays[d]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY %q", d)
+				}
+				r.byDay[wd] = true
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("BYMONTHDAY must be between -31 and 31, not 0")
+				}
+				r.byMonthDay = append(r.byMonthDay, n)
+			}
+		case "BYMONTH":
+			r.byMonth = map[time.Month]bool{}
+			for _, m := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(m)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("BYMONTH must be between 1 and 12")
+				}
+				r.byMonth[time.Month(n)] = true
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rrule part %s", key)
+		}
+	}
+	if r.freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if r.n > 0 && !r.until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL cannot be combined")
+	}
+
+	// Like RFC 5545, parts left out are taken from the start date.
+	switch r.freq {
+	case "WEEKLY":
+		if r.byDay == nil {
+			r.byDay = map[time.Weekday]bool{start.Weekday(): true}
+		}
+	case "MONTHLY":
+		if r.byDay == nil && r.byMonthDay == nil {
+			r.byMonthDay = []int{start.Day()}
+		}
+	case "YEARLY":
+		if r.byMonth == nil {
+			r.byMonth = map[time.Month]bool{start.Month(): true}
+		}
+		if r.byDay == nil && r.byMonthDay == nil {
+			r.byMonthDay = []int{start.Day()}
+		}
+	}
+	return r, nil
+}
+
+func (r *rrule) count() int {
+	return r.n
+}
+
+func (r *rrule) matches(day time.Time) bool {
+	if day.Before(r.start) || (!r.until.IsZero() && day.After(r.until)) {
+		return false
+	}
+
+	switch r.freq {
+	case "DAILY":
+		if int(day.Sub(r.start).Hours()/24)%r.interval != 0 {
+			return false
+		}
+	case "WEEKLY":
+		weeks := int(weekStart(day).Sub(weekStart(r.start)).Hours() / 24 / 7)
+		if weeks%r.interval != 0 {
+			return false
+		}
+	case "MONTHLY":
+		months := (day.Year()-r.start.Year())*12 + int(day.Month()) - int(r.start.Month())
+		if months%r.interval != 0 {
+			return false
+		}
+	case "YEARLY":
+		if (day.Year()-r.start.Year())%r.interval != 0 {
+			return false
+		}
+	}
+
+	if r.byMonth != nil && !r.byMonth[day.Month()] {
+		return false
+	}
+	if r.byDay != nil && !r.byDay[day.Weekday()] {
+		return false
+	}
+	if r.byMonthDay != nil {
+		last := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		found := false
+		for _, d := range r.byMonthDay {
+			if d == day.Day() || (d < 0 && last+d+1 == day.Day()) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func weekStart(day time.Time) time.Time {
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+// cron matches the day of month, month and day of week fields. As in cron,
+// when both day fields are restricted a day matching either one is enough.
+type cron struct {
+	dom, month, dow map[int]bool
+}
+
+func parseCron(s string) (*cron, error) {
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return nil, errors.New("cron expression must have five fields")
+	}
+	if _, err := parseCronField(f[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if _, err := parseCronField(f[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+
+	c := &cron{}
+	var err error
+	if c.dom, err = parseCronField(f[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(f[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(f[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	if c.dow != nil && c.dow[7] {
+		c.dow[0] = true
+	}
+	return c, nil
+}
+
+// parseCronField returns the allowed values, or nil for *.
+func parseCronField(s string, min, max int) (map[int]bool, error) {
+	if s == "*" {
+		return nil, nil
+	}
+	values := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return nil, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return nil, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func (c *cron) count() int {
+	return 0
+}
+
+func (c *cron) matches(day time.Time) bool {
+	if c.month != nil && !c.month[int(day.Month())] {
+		return false
+	}
+	dom := c.dom == nil || c.dom[day.Day()]
+	dow := c.dow == nil || c.dow[int(day.Weekday())]
+	if c.dom != nil && c.dow != nil {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// occurrences returns up to limit days after the given day, through the given
+// day, on which the schedule falls between start and end. A zero end or limit
+// means no bound.
+func occurrences(s schedule, start, end, after, through time.Time, limit int) []time.Time {
+	horizon := after.AddDate(maxScheduleYears, 0, 0)
+	if through.IsZero() || through.After(horizon) {
+		through = horizon
+	}
+	if !end.IsZero() && end.Before(through) {
+		through = end
+	}
+
+	var days []time.Time
+	n := 0
+	// Counting starts at the start date, so walk from there even when only
+	// later occurrences are wanted.
+	for day := start; !day.After(through); day = day.AddDate(0, 0, 1) {
+		if !s.matches(day) {
+			continue
+		}
+		n++
+		if s.count() > 0 && n > s.count() {
+			break
+		}
+		if day.After(after) {
+			days = append(days, day)
+			if limit > 0 && len(days) == limit {
+				break
+			}
+		}
+	}
+	return days
+}
@@ -0,0 +1,130 @@
+//go:build unit
+
+package expense
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func dates(days []time.Time) []string {
+	s := make([]string, len(days))
+	for i, day := range days {
+		s[i] = day.Format(dateLayout)
+	}
+	return s
+}
+
+func TestScheduleOccurrences(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule string
+		start    string
+		end      string
+		after    string
+		want     []string
+	}{
+		{
+			name:     "Test case for a monthly rrule defaulting to the start day",
+			schedule: "FREQ=MONTHLY",
+			start:    "2023-01-15",
+			want:     []string{"2023-01-15", "2023-02-15", "2023-03-15", "2023-04-15"},
+		},
+		{
+			name:     "Test case for the last day of every month",
+			schedule: "RRULE:FREQ=MONTHLY;BYMONTHDAY=-1",
+			start:    "2023-01-01",
+			want:     []string{"2023-01-31", "2023-02-28", "2023-03-31", "2023-04-30"},
+		},
+		{
+			name:     "Test case for skipping months without the day",
+			schedule: "FREQ=MONTHLY;BYMONTHDAY=31",
+			start:    "2023-01-01",
+			want:     []string{"2023-01-31", "2023-03-31", "2023-05-31", "2023-07-31"},
+		},
+		{
+			name:     "Test case for every other week on two days",
+			schedule: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+			start:    "2023-01-02",
+			want:     []string{"2023-01-02", "2023-01-06", "2023-01-16", "2023-01-20"},
+		},
+		{
+			name:     "Test case for counting from the start",
+			schedule: "FREQ=DAILY;INTERVAL=3;COUNT=3",
+			start:    "2023-01-01",
+			after:    "2023-01-03",
+			want:     []string{"2023-01-04", "2023-01-07"},
+		},
+		{
+			name:     "Test case for an rrule until a day",
+			schedule: "FREQ=YEARLY;UNTIL=20250101",
+			start:    "2023-02-01",
+			want:     []string{"2023-02-01", "2024-02-01"},
+		},
+		{
+			name:     "Test case for a cron expression with an end date",
+			schedule: "0 9 1,15 * *",
+			start:    "2023-01-10",
+			end:      "2023-02-14",
+			want:     []string{"2023-01-15", "2023-02-01"},
+		},
+		{
+			name:     "Test case for cron matching either day field",
+			schedule: "0 0 1 * 0",
+			start:    "2023-01-01",
+			want:     []string{"2023-01-01", "2023-01-08", "2023-01-15", "2023-01-22"},
+		},
+		{
+			name:     "Test case for a schedule that never falls",
+			schedule: "0 0 30 2 *",
+			start:    "2023-01-01",
+			want:     []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := RecurringExpense{Schedule: test.schedule, Start: test.start, End: test.end}
+			after := date(test.start).AddDate(0, 0, -1)
+			if test.after != "" {
+				after = date(test.after)
+			}
+
+			days, err := r.occurrences(after, time.Time{}, 4)
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.want, dates(days))
+			}
+		})
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	start := date("2023-01-01")
+	tests := []struct {
+		schedule string
+		err      string
+	}{
+		{schedule: "FREQ=HOURLY", err: `unsupported FREQ "HOURLY"`},
+		{schedule: "FREQ=DAILY;COUNT=2;UNTIL=20230101", err: "COUNT and UNTIL cannot be combined"},
+		{schedule: "FREQ=WEEKLY;BYDAY=1MO", err: `unsupported BYDAY "1MO"`},
+		{schedule: "FREQ=MONTHLY;BYSETPOS=1", err: "unsupported rrule part BYSETPOS"},
+		{schedule: "0 0 * *", err: "cron expression must have five fields"},
+		{schedule: "0 0 32 * *", err: `day of month: "32" is out of range 1-31`},
+		{schedule: "0 0 */2 * 1-5"},
+		{schedule: "30 8 * 1,7 0"},
+	}
+
+	for _, test := range tests {
+		t.Run("Test case for "+test.schedule, func(t *testing.T) {
+			_, err := parseSchedule(test.schedule, start)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
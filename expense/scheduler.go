@@ -0,0 +1,197 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+)
+
+// dueRecurringSQL finds recurring expenses with days left to create.
+const dueRecurringSQL = `SELECT id FROM recurring_expenses
+	WHERE materialized_through < $1 AND (end_date IS NULL OR materialized_through < end_date) ORDER BY id`
+
+// claimRecurringSQL locks one recurring expense. SKIP LOCKED lets several
+// instances run the scheduler without waiting on each other.
+const claimRecurringSQL = "SELECT " + recurringColumns + " FROM recurring_expenses WHERE id = $1 AND materialized_through < $2 FOR UPDATE SKIP LOCKED"
+
+// materializeSQL creates one occurrence. The unique index on recurring_id and
+// spent_on makes it a no-op when the occurrence already exists.
+const materializeSQL = `WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($4::text[]) ON CONFLICT DO NOTHING)
+	INSERT INTO expenses (title, amount, note, tags, category_id, spent_on, recurring_id, merchant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (recurring_id, spent_on) WHERE recurring_id IS NOT NULL DO NOTHING RETURNING id`
+
+type recurringScheduler struct {
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+var scheduler *recurringScheduler
+
+// StartScheduler creates due recurring expenses in the background until
+// StopScheduler is called. The first run happens right away, so days missed
+// while the server was down are caught up on start.
+func StartScheduler(cfg config.Recurring) {
+	s := &recurringScheduler{
+		interval: cfg.Interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	scheduler = s
+	go s.run()
+}
+
+// StopScheduler waits for the current run to finish.
+func StopScheduler(ctx context.Context) error {
+	s := scheduler
+	if s == nil {
+		return nil
+	}
+	scheduler = nil
+
+	close(s.stop)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *recurringScheduler) run() {
+	defer close(s.done)
+	for {
+		if _, err := materializeDue(context.Background(), today()); err != nil {
+			log.Printf("materialize recurring expenses error: %v", err)
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// materializeDue creates the expenses due through the given day for every
+// recurring expense and returns how many it created.
+func materializeDue(ctx context.Context, through time.Time) (int, error) {
+	rows, err := db.QueryContext(ctx, dueRecurringSQL, through.Format(dateLayout))
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A failure only leaves the rules out, as it does for a manual POST.
+	rules, err := loadRules(ctx)
+	if err != nil {
+		log.Printf("load rules error: %v", err)
+	}
+
+	total := 0
+	for _, id := range ids {
+		n, err := materialize(ctx, id, through, rules)
+		if err != nil {
+			log.Printf("materialize recurring expense %d error: %v", id, err)
+			continue
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// materialize creates the missing occurrences of one recurring expense through
+// the given day and records how far it got in the same transaction, so a crash
+// or a restart never creates an occurrence twice. Each occurrence gets the
+// merchant guess and the rules an identical manual POST would.
+func materialize(ctx context.Context, id int, through time.Time, rules []Rule) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	r := RecurringExpense{}
+	err = scanRecurring(tx.QueryRowContext(ctx, claimRecurringSQL, id, through.Format(dateLayout)), &r)
+	if err == sql.ErrNoRows {
+		// Deleted, caught up or being handled by another instance.
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	after, err := time.Parse(dateLayout, r.MaterializedThrough)
+	if err != nil {
+		return 0, err
+	}
+	days, err := r.occurrences(after, through, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	t := r.Template
+	var merchant *int
+	if len(days) > 0 {
+		// A failed guess only leaves the occurrences without a merchant.
+		if merchant, err = suggestMerchant(ctx, t.Title); err != nil {
+			log.Printf("suggest merchant error: %v", err)
+		}
+	}
+	occurrence := func(day time.Time) Expense {
+		return Expense{Title: t.Title, Amount: t.Amount, Note: t.Note, Tags: append([]string(nil), t.Tags...), CategoryID: t.CategoryID,
+			Date: day.Format(dateLayout), MerchantID: merchant}
+	}
+
+	var created []Expense
+	for _, day := range days {
+		e := occurrence(day)
+		if len(runRules(rules, &e)) > 0 {
+			// A rule may leave the expense invalid, say with a tag too many;
+			// the occurrence is still due, so it is created as scheduled.
+			if err := e.Validate(); err != nil {
+				log.Printf("invalid recurring expense %d after rules error: %v", r.ID, err)
+				e = occurrence(day)
+			}
+		}
+		err = tx.QueryRowContext(ctx, materializeSQL, e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.CategoryID, e.Date, r.ID, e.MerchantID).Scan(&e.ID)
+		if err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+		if err = enqueueEvent(ctx, tx, EventExpenseCreated, e); err != nil {
+			return 0, err
+		}
+		created = append(created, e)
+	}
+
+	if _, err = tx.ExecContext(ctx, "UPDATE recurring_expenses SET materialized_through = $2 WHERE id = $1", r.ID, through.Format(dateLayout)); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, e := range created {
+		if err := checkBudgetAlerts(ctx, e); err != nil {
+			log.Printf("check budget alerts error: %v", err)
+		}
+	}
+	return len(created), nil
+}
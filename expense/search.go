@@ -0,0 +1,104 @@
+package expense
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// SearchResult is an expense matching a search, with its relevance and an
+// excerpt of its title and note. Matches in Snippet are wrapped in <mark>
+// and the rest of the text is HTML-escaped.
+type SearchResult struct {
+	Expense Expense `json:"expense"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// searchQuery turns a search box query into tsquery text. Words match as
+// prefixes, so "gra" finds "grab", while double-quoted phrases match whole
+// words next to each other. Every word and phrase must match. Words are split
+// the way the simple text search parser splits them, on anything that is not
+// a letter, mark or digit.
+func searchQuery(q string) string {
+	var terms []string
+	for i, part := range strings.Split(q, `"`) {
+		words := strings.FieldsFunc(strings.ToLower(part), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsMark(r) && !unicode.IsDigit(r)
+		})
+		if len(words) == 0 {
+			continue
+		}
+		// Odd parts sit between quotes; an unterminated quote runs to the end.
+		if i%2 == 1 {
+			terms = append(terms, "'"+strings.Join(words, "' <-> '")+"'")
+			continue
+		}
+		for _, w := range words {
+			terms = append(terms, "'"+w+"':*")
+		}
+	}
+	return strings.Join(terms, " & ")
+}
+
+// snippetSQL highlights matches of $1 in the escaped title and note.
+const snippetSQL = `ts_headline('simple', replace(replace(replace(title || ' - ' || note, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'),
+	to_tsquery('simple', $1), 'StartSel=<mark>, StopSel=</mark>, MinWords=5, MaxWords=20, MaxFragments=2')`
+
+// SearchExpensesHandler finds expenses by the words in their title, note and
+// tags, best matches first. The usual filters narrow the search further.
+func SearchExpensesHandler(c echo.Context) error {
+	tsquery := searchQuery(c.QueryParam("q"))
+	if tsquery == "" {
+		return ErrInvalidRequest.WithDetailKey("detail.search.q.required", nil)
+	}
+
+	limit := defaultSearchLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return ErrInvalidRequest.WithDetailKey("detail.limit.range", i18n.Params{"max": maxSearchLimit})
+		}
+		limit = n
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	q := &query{}
+	q.add("search @@ to_tsquery('simple', ?)", tsquery)
+	FilterFromQuery(c).apply(q)
+
+	rows, err := db.QueryContext(ctx, "SELECT "+expenseColumns+", ts_rank(search, to_tsquery('simple', $1)) AS rank, "+snippetSQL+
+		" FROM expenses"+q.where()+" ORDER BY rank DESC, spent_on DESC, id DESC LIMIT "+strconv.Itoa(limit), q.args...)
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	rs := []SearchResult{}
+	for rows.Next() {
+		r := SearchResult{}
+		if err = scanExpense(rows, &r.Expense, &r.Rank, &r.Snippet); err != nil {
+			c.Logger().Error("scan expense error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+		rs = append(rs, r)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate expenses error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, rs)
+}
@@ -0,0 +1,82 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "Grab", want: "'grab':*"},
+		{q: "grab ride", want: "'grab':* & 'ride':*"},
+		{q: `"central world" grab`, want: "'central' <-> 'world' & 'grab':*"},
+		{q: `taxi "to the airport`, want: "'taxi':* & 'to' <-> 'the' <-> 'airport'"},
+		{q: "grab-ride's: a&b | !c", want: "'grab':* & 'ride':* & 's':* & 'a':* & 'b':* & 'c':*"},
+		{q: "ข้าวมันไก่ 50", want: "'ข้าวมันไก่':* & '50':*"},
+		{q: ` "" - ! `, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run("Test case for "+test.q, func(t *testing.T) {
+			assert.Equal(t, test.want, searchQuery(test.q))
+		})
+	}
+}
+
+func TestSearchExpensesHandler(t *testing.T) {
+	t.Run("Test case for ranked results with snippets", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/search?q=gra&tag=transport&limit=5", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT "+expenseColumns+", ts_rank(search, to_tsquery('simple', $1)) AS rank, ")+
+			".*"+regexp.QuoteMeta(" FROM expenses WHERE search @@ to_tsquery('simple', $1) AND tags @> ARRAY[$2] AND kind = $3 ORDER BY rank DESC, spent_on DESC, id DESC LIMIT 5")).
+			WithArgs("'gra':*", "transport", KindExpense).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id", "rank", "snippet"}).
+				AddRow(1, "grab", 120.0, "rainy evening", pq.Array([]string{"transport"}), nil, "2023-03-10", nil, nil, nil, 0.6, "<mark>grab</mark> - rainy evening"))
+
+		err = SearchExpensesHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `[{"expense":{"id":1,"title":"grab","amount":120,"note":"rainy evening","tags":["transport"],"date":"2023-03-10"},"rank":0.6,"snippet":"\u003cmark\u003egrab\u003c/mark\u003e - rainy evening"}]`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a query without words", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/search?q=%22%22", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := SearchExpensesHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("Test case for a limit out of range", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/search?q=grab&limit=1000", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := SearchExpensesHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
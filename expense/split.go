@@ -0,0 +1,163 @@
+package expense
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+const (
+	SplitEqual   = "equal"
+	SplitAmount  = "amount"
+	SplitPercent = "percent"
+)
+
+// Split shares an expense paid by one person between participants. With the
+// equal method each share is left out, with amount it is the money owed and
+// with percent a percentage of the expense. Amount is always worked out by
+// the server.
+type Split struct {
+	PaidBy string  `json:"paid_by"`
+	Method string  `json:"method"`
+	Shares []Share `json:"shares"`
+}
+
+type Share struct {
+	Participant string  `json:"participant"`
+	Share       float64 `json:"share,omitempty"`
+	Amount      float64 `json:"amount"`
+}
+
+// Scan reads the split stored as JSON.
+func (s *Split) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	}
+	return fmt.Errorf("cannot scan %T into split", src)
+}
+
+func (s *Split) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(s)
+	return string(b), err
+}
+
+func (s *Split) normalize() {
+	if s == nil {
+		return
+	}
+	s.PaidBy = strings.TrimSpace(s.PaidBy)
+	for i := range s.Shares {
+		s.Shares[i].Participant = strings.TrimSpace(s.Shares[i].Participant)
+	}
+}
+
+// validateSplit reports the split rules broken by an expense of the given
+// amount through add, the way Validator.Validate does for other fields.
+func validateSplit(s *Split, amount float64, add func(pointer, field, rule string, params i18n.Params)) {
+	if s.PaidBy == "" {
+		add("/split/paid_by", "split.paid_by", "required", nil)
+	}
+	switch s.Method {
+	case SplitEqual, SplitAmount, SplitPercent:
+	default:
+		add("/split/method", "split.method", "enum", nil)
+	}
+	if len(s.Shares) == 0 {
+		add("/split/shares", "split.shares", "minItems", nil)
+		return
+	}
+
+	seen := map[string]bool{}
+	var total float64
+	for i, share := range s.Shares {
+		pointer := fmt.Sprintf("/split/shares/%d", i)
+		if share.Participant == "" {
+			add(pointer+"/participant", "split.participant", "required", nil)
+		} else if seen[share.Participant] {
+			add(pointer+"/participant", "split.participant", "uniqueItems", i18n.Params{"participant": share.Participant})
+		}
+		seen[share.Participant] = true
+		if (s.Method == SplitAmount || s.Method == SplitPercent) && share.Share <= 0 {
+			add(pointer+"/share", "split.share", "exclusiveMinimum", nil)
+		}
+		total += share.Share
+	}
+
+	switch s.Method {
+	case SplitAmount:
+		if cents(total) != cents(amount) {
+			add("/split/shares", "split.shares", "amountSum", i18n.Params{"amount": amount})
+		}
+	case SplitPercent:
+		if math.Abs(total-100) > 1e-6 {
+			add("/split/shares", "split.shares", "percentSum", nil)
+		}
+	}
+}
+
+// allocate works out the amount of every share of a validated split in whole
+// cents, so the shares always add up to the expense exactly. Cents left over
+// by rounding go to the shares that lost the most, earlier shares first.
+func (s *Split) allocate(amount float64) {
+	if s == nil {
+		return
+	}
+	if s.Method == SplitAmount {
+		for i := range s.Shares {
+			s.Shares[i].Amount = float64(cents(s.Shares[i].Share)) / 100
+		}
+		return
+	}
+
+	total := cents(amount)
+	weights := make([]float64, len(s.Shares))
+	var sum float64
+	for i, share := range s.Shares {
+		weights[i] = 1
+		if s.Method == SplitPercent {
+			weights[i] = share.Share
+		} else {
+			s.Shares[i].Share = 0
+		}
+		sum += weights[i]
+	}
+
+	allocated := make([]int64, len(s.Shares))
+	lost := make([]float64, len(s.Shares))
+	left := total
+	for i, w := range weights {
+		exact := float64(total) * w / sum
+		allocated[i] = int64(math.Floor(exact))
+		lost[i] = exact - float64(allocated[i])
+		left -= allocated[i]
+	}
+	order := make([]int, len(s.Shares))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return lost[order[a]] > lost[order[b]] })
+	for i := 0; left > 0; i = (i + 1) % len(order) {
+		allocated[order[i]]++
+		left--
+	}
+
+	for i := range s.Shares {
+		s.Shares[i].Amount = float64(allocated[i]) / 100
+	}
+}
+
+// cents rounds an amount of money to whole cents.
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
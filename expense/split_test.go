@@ -0,0 +1,147 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split Split
+		want  []string
+	}{
+		{
+			name:  "Test case for equal parts",
+			split: Split{PaidBy: "alice", Method: SplitEqual, Shares: []Share{{Participant: "alice"}, {Participant: "bob"}}},
+		},
+		{
+			name:  "Test case for amounts adding up to the expense",
+			split: Split{PaidBy: "alice", Method: SplitAmount, Shares: []Share{{Participant: "bob", Share: 60}, {Participant: "carol", Share: 40}}},
+		},
+		{
+			name:  "Test case for amounts not adding up to the expense",
+			split: Split{PaidBy: "alice", Method: SplitAmount, Shares: []Share{{Participant: "bob", Share: 60}, {Participant: "carol", Share: 30}}},
+			want:  []string{"/split/shares amountSum"},
+		},
+		{
+			name:  "Test case for percentages not adding up to 100",
+			split: Split{PaidBy: "alice", Method: SplitPercent, Shares: []Share{{Participant: "bob", Share: 50}, {Participant: "carol", Share: 0}}},
+			want:  []string{"/split/shares/1/share exclusiveMinimum", "/split/shares percentSum"},
+		},
+		{
+			name:  "Test case for missing and duplicated participants",
+			split: Split{Method: "shares", Shares: []Share{{Participant: "bob"}, {Participant: ""}, {Participant: "bob"}}},
+			want:  []string{"/split/paid_by required", "/split/method enum", "/split/shares/1/participant required", "/split/shares/2/participant uniqueItems"},
+		},
+		{
+			name:  "Test case for a split without shares",
+			split: Split{PaidBy: "alice", Method: SplitEqual},
+			want:  []string{"/split/shares minItems"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			split := test.split
+			e := Expense{Title: "dinner", Amount: 100, Note: "group dinner", Tags: []string{"food"}, Split: &split}
+
+			err := e.Validate()
+
+			if test.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var pe *problem.Error
+			if assert.ErrorAs(t, err, &pe) {
+				var got []string
+				for _, f := range pe.Fields {
+					got = append(got, f.Pointer+" "+f.Rule)
+				}
+				assert.Equal(t, test.want, got)
+			}
+		})
+	}
+}
+
+func TestSplitAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount float64
+		split  Split
+		want   []float64
+	}{
+		{
+			name:   "Test case for equal parts with leftover cents",
+			amount: 100,
+			split:  Split{Method: SplitEqual, Shares: []Share{{Participant: "a"}, {Participant: "b"}, {Participant: "c"}}},
+			want:   []float64{33.34, 33.33, 33.33},
+		},
+		{
+			name:   "Test case for percentages",
+			amount: 99.99,
+			split:  Split{Method: SplitPercent, Shares: []Share{{Participant: "a", Share: 50}, {Participant: "b", Share: 25}, {Participant: "c", Share: 25}}},
+			want:   []float64{49.99, 25, 25},
+		},
+		{
+			name:   "Test case for amounts",
+			amount: 100,
+			split:  Split{Method: SplitAmount, Shares: []Share{{Participant: "a", Share: 70.5}, {Participant: "b", Share: 29.5}}},
+			want:   []float64{70.5, 29.5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.split.allocate(test.amount)
+
+			var got []float64
+			for _, share := range test.split.Shares {
+				got = append(got, share.Amount)
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestCreateExpenseHandlerWithSplit(t *testing.T) {
+	body := `{"title":"dinner","amount":90,"note":"group dinner","tags":["food"],"split":{"paid_by":" alice ","method":"equal","shares":[{"participant":"alice"},{"participant":"bob"},{"participant":"carol"}]}}`
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	split := `{"paid_by":"alice","method":"equal","shares":[{"participant":"alice","amount":30},{"participant":"bob","amount":30},{"participant":"carol","amount":30}]}`
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("dinner", 90.0, "group dinner", pq.Array([]string{"food"}), nil, nil, split, nil, nil, "expense", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-15"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = CreateExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"title":"dinner","amount":90,"note":"group dinner","tags":["food"],"date":"2023-01-15","split":`+split+`}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,338 @@
+package expense
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnwsitgod/assessment/i18n"
+	"golang.org/x/text/encoding/charmap"
+)
+
+const (
+	FormatOFX = "ofx"
+	FormatQIF = "qif"
+)
+
+// Day and month order of QIF dates, which carry no hint of their own.
+const (
+	DateOrderDMY = "dmy"
+	DateOrderMDY = "mdy"
+)
+
+// StatementLine is one transaction of a bank statement. Amount is negative
+// for money out of the account.
+type StatementLine struct {
+	FITID  string
+	Date   string
+	Amount float64
+	Payee  string
+	Memo   string
+}
+
+// transaction maps the line to the expense or income it records on account.
+func (l StatementLine) transaction(account int, tag string) Expense {
+	e := Expense{Title: l.Payee, Amount: math.Abs(l.Amount), Note: l.Memo, Tags: []string{tag}, Date: l.Date, AccountID: &account, Kind: KindExpense}
+	if l.Amount > 0 {
+		e.Kind = KindIncome
+	}
+	if e.Title == "" {
+		e.Title = l.Memo
+	}
+	if e.Note == "" {
+		e.Note = e.Title
+	}
+	return e
+}
+
+// importKeys identifies each line so that importing it again is caught. The
+// FITID the bank gave a transaction is used when there is one; otherwise the
+// key is a fingerprint of the date, amount and payee, numbered so that two
+// identical lines in one statement both count.
+func importKeys(lines []StatementLine) []string {
+	keys := make([]string, len(lines))
+	seen := map[string]int{}
+	for i, l := range lines {
+		if l.FITID != "" {
+			keys[i] = "fitid:" + l.FITID
+			continue
+		}
+		payee := l.Payee
+		if payee == "" {
+			payee = l.Memo
+		}
+		key := fmt.Sprintf("fp:%s:%d:%s", l.Date, cents(l.Amount), merchantKey(payee))
+		seen[key]++
+		if n := seen[key]; n > 1 {
+			key = fmt.Sprintf("%s:%d", key, n)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// decodeStatement returns the statement as text. Files that are not UTF-8
+// are taken to be in windows-874, the Thai code page most bank exports use.
+func decodeStatement(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	s, err := charmap.Windows874.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(s)
+}
+
+// detectFormat guesses the format of a statement from its contents, or
+// returns "" when it is neither.
+func detectFormat(s string) string {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "!"):
+		return FormatQIF
+	case strings.HasPrefix(s, "OFXHEADER"), strings.Contains(strings.ToUpper(s), "<OFX>"):
+		return FormatOFX
+	}
+	return ""
+}
+
+func parseStatement(s, format, dateOrder string) ([]StatementLine, error) {
+	switch format {
+	case FormatOFX:
+		return parseOFX(s)
+	case FormatQIF:
+		return parseQIF(s, dateOrder)
+	}
+	return nil, fmt.Errorf("unsupported statement format %q", format)
+}
+
+var (
+	ofxTransaction    = regexp.MustCompile(`(?i)<STMTTRN>`)
+	ofxTransactionEnd = regexp.MustCompile(`(?i)</STMTTRN>|</BANKTRANLIST>`)
+	// ofxElement matches an element and its text. OFX 1.x is SGML and leaves
+	// the end tags of elements out, so the text runs up to the next tag.
+	ofxElement = regexp.MustCompile(`<([A-Za-z0-9.]+)>([^<]*)`)
+)
+
+// statementError points at the part of a statement that cannot be read: a
+// line of a QIF file or a transaction of an OFX one, and the field at fault
+// ("incomplete" when the date or amount is missing).
+type statementError struct {
+	at    string
+	n     int
+	field string
+	value string
+}
+
+func (e *statementError) key() string {
+	return "detail.statement." + e.at + "." + e.field
+}
+
+func (e *statementError) params() i18n.Params {
+	return i18n.Params{e.at: e.n, "value": e.value}
+}
+
+func (e *statementError) Error() string {
+	return i18n.T(i18n.Default, e.key(), e.params())
+}
+
+// parseOFX reads the STMTTRN aggregates of an OFX statement, either SGML
+// (1.x) or XML (2.x). Every bank and credit card statement in the file is
+// read.
+func parseOFX(s string) ([]StatementLine, error) {
+	var lines []StatementLine
+	starts := ofxTransaction.FindAllStringIndex(s, -1)
+	for i, start := range starts {
+		block := s[start[1]:]
+		if i+1 < len(starts) {
+			block = s[start[1]:starts[i+1][0]]
+		}
+		if end := ofxTransactionEnd.FindStringIndex(block); end != nil {
+			block = block[:end[0]]
+		}
+
+		fields := map[string]string{}
+		for _, m := range ofxElement.FindAllStringSubmatch(block, -1) {
+			tag := strings.ToUpper(m[1])
+			if _, ok := fields[tag]; !ok {
+				fields[tag] = html.UnescapeString(strings.TrimSpace(m[2]))
+			}
+		}
+
+		n := i + 1
+		posted := fields["DTPOSTED"]
+		if len(posted) < 8 {
+			return nil, &statementError{at: "transaction", n: n, field: "date", value: posted}
+		}
+		date, err := time.Parse("20060102", posted[:8])
+		if err != nil {
+			return nil, &statementError{at: "transaction", n: n, field: "date", value: posted}
+		}
+		amount, err := parseStatementAmount(fields["TRNAMT"])
+		if err != nil {
+			return nil, &statementError{at: "transaction", n: n, field: "amount", value: fields["TRNAMT"]}
+		}
+		payee := fields["NAME"]
+		if payee == "" {
+			payee = fields["PAYEE"]
+		}
+		lines = append(lines, StatementLine{FITID: fields["FITID"], Date: date.Format(dateLayout), Amount: amount, Payee: payee, Memo: fields["MEMO"]})
+	}
+	return lines, nil
+}
+
+// qifTransactionTypes are the QIF sections holding transactions; lists of
+// categories, classes and the like are skipped.
+var qifTransactionTypes = map[string]bool{
+	"bank":  true,
+	"cash":  true,
+	"ccard": true,
+	"oth a": true,
+	"oth l": true,
+}
+
+// parseQIF reads the transactions of a QIF file. Records are ended by a ^
+// line; only the date, amount, payee and memo of each are read.
+func parseQIF(s, dateOrder string) ([]StatementLine, error) {
+	var lines []StatementLine
+	var l StatementLine
+	skip, started, hasDate, hasAmount := false, false, false, false
+	end := func(n int) error {
+		if started && !skip {
+			if !hasDate || !hasAmount {
+				return &statementError{at: "line", n: n, field: "incomplete"}
+			}
+			lines = append(lines, l)
+		}
+		l, started, hasDate, hasAmount = StatementLine{}, false, false, false
+		return nil
+	}
+
+	rows := strings.Split(s, "\n")
+	for i, row := range rows {
+		n := i + 1
+		row = strings.TrimSpace(row)
+		if row == "" {
+			continue
+		}
+		if row[0] == '!' {
+			header := strings.ToLower(row)
+			switch {
+			case strings.HasPrefix(header, "!type:"):
+				skip = !qifTransactionTypes[strings.TrimSpace(header[len("!type:"):])]
+			case header == "!account":
+				// The account block describes the account itself.
+				skip = true
+			}
+			continue
+		}
+
+		value := strings.TrimSpace(row[1:])
+		switch row[0] {
+		case '^':
+			if err := end(n); err != nil {
+				return nil, err
+			}
+			continue
+		case 'D':
+			if !skip {
+				date, err := parseQIFDate(value, dateOrder)
+				if err != nil {
+					return nil, &statementError{at: "line", n: n, field: "date", value: value}
+				}
+				l.Date, hasDate = date, true
+			}
+		case 'T', 'U':
+			// U repeats T in newer exports.
+			if !skip && !hasAmount {
+				amount, err := parseStatementAmount(value)
+				if err != nil {
+					return nil, &statementError{at: "line", n: n, field: "amount", value: value}
+				}
+				l.Amount, hasAmount = amount, true
+			}
+		case 'P':
+			l.Payee = value
+		case 'M':
+			l.Memo = value
+		}
+		started = true
+	}
+	if err := end(len(rows)); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+var decimalComma = regexp.MustCompile(`^[-+]?[0-9]*,[0-9]{1,2}$`)
+
+// parseStatementAmount reads amounts written with thousands separators, or
+// with a decimal comma as in "-12,50".
+func parseStatementAmount(s string) (float64, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	if decimalComma.MatchString(s) {
+		s = strings.Replace(s, ",", ".", 1)
+	} else {
+		s = strings.ReplaceAll(s, ",", "")
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
+
+var qifDateSeparators = strings.NewReplacer("'", "/", "-", "/", ".", "/", " ", "")
+
+// parseQIFDate reads a QIF date as YYYY-MM-DD. Dates are written as
+// day/month/year or month/day/year depending on the bank, with two or four
+// digit years; a year in the Buddhist era is converted. A two-digit year that
+// would fall after next year is taken as Buddhist too, so 66 is 2566, which
+// is 2023.
+func parseQIFDate(s, dateOrder string) (string, error) {
+	parts := strings.Split(qifDateSeparators.Replace(s), "/")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid date %q", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return "", fmt.Errorf("invalid date %q", s)
+		}
+		nums[i] = v
+	}
+
+	var year, month, day int
+	switch {
+	case len(parts[0]) == 4:
+		year, month, day = nums[0], nums[1], nums[2]
+	case dateOrder == DateOrderMDY:
+		month, day, year = nums[0], nums[1], nums[2]
+	default:
+		day, month, year = nums[0], nums[1], nums[2]
+	}
+	if year < 100 {
+		year += 2000
+		if year > now().Year()+1 {
+			year += 500
+		}
+	}
+	if year >= 2400 {
+		year -= 543
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Month() != time.Month(month) || t.Day() != day {
+		return "", fmt.Errorf("invalid date %q", s)
+	}
+	return t.Format(dateLayout), nil
+}
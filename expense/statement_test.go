@@ -0,0 +1,150 @@
+//go:build unit
+
+package expense
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sgmlStatement = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+ENCODING:USASCII
+CHARSET:1252
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>THB
+<BANKTRANLIST>
+<DTSTART>20230101
+<DTEND>20230131
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20230115120000[+7:ICT]
+<TRNAMT>-1,250.50
+<FITID>20230115001
+<NAME>7-Eleven
+<MEMO>Card purchase
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20230125
+<TRNAMT>45000.00
+<FITID>20230125001
+<NAME>ACME &amp; Co
+</STMTTRN>
+</BANKTRANLIST>
+<LEDGERBAL><BALAMT>43749.50<DTASOF>20230131
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const xmlStatement = `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="220"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS><BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20230201</DTPOSTED><TRNAMT>-89.00</TRNAMT><MEMO>Grab ride</MEMO></STMTTRN>
+</BANKTRANLIST></CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>
+`
+
+func TestParseOFX(t *testing.T) {
+	t.Run("Test case for an SGML statement", func(t *testing.T) {
+		lines, err := parseOFX(sgmlStatement)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []StatementLine{
+				{FITID: "20230115001", Date: "2023-01-15", Amount: -1250.5, Payee: "7-Eleven", Memo: "Card purchase"},
+				{FITID: "20230125001", Date: "2023-01-25", Amount: 45000, Payee: "ACME & Co"},
+			}, lines)
+		}
+	})
+
+	t.Run("Test case for an XML credit card statement", func(t *testing.T) {
+		lines, err := parseOFX(xmlStatement)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []StatementLine{{Date: "2023-02-01", Amount: -89, Memo: "Grab ride"}}, lines)
+		}
+	})
+
+	t.Run("Test case for a transaction without an amount", func(t *testing.T) {
+		_, err := parseOFX("<OFX><STMTTRN><DTPOSTED>20230115</STMTTRN></OFX>")
+
+		assert.EqualError(t, err, `transaction 1: invalid TRNAMT ""`)
+	})
+}
+
+func TestParseQIF(t *testing.T) {
+	t.Run("Test case for a bank statement", func(t *testing.T) {
+		qif := "!Account\r\nNSavings\r\nTBank\r\n^\r\n!Type:Bank\r\nD15/01/2566\r\nT-1,250.50\r\nP7-Eleven\r\nMCard purchase\r\n^\r\nD25/01'23\r\nU45,000.00\r\nT45,000.00\r\nPACME\r\n"
+
+		lines, err := parseQIF(qif, DateOrderDMY)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []StatementLine{
+				{Date: "2023-01-15", Amount: -1250.5, Payee: "7-Eleven", Memo: "Card purchase"},
+				{Date: "2023-01-25", Amount: 45000, Payee: "ACME"},
+			}, lines)
+		}
+	})
+
+	t.Run("Test case for month first dates", func(t *testing.T) {
+		lines, err := parseQIF("!Type:CCard\nD1/25/2023\nT-12,50\nPCafe\n^\n", DateOrderMDY)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, []StatementLine{{Date: "2023-01-25", Amount: -12.5, Payee: "Cafe"}}, lines)
+		}
+	})
+
+	t.Run("Test case for two-digit Buddhist era years", func(t *testing.T) {
+		defer func() { now = time.Now }()
+		now = func() time.Time { return time.Date(2023, 3, 31, 9, 0, 0, 0, time.Local) }
+
+		lines, err := parseQIF("!Type:Bank\nD15/01/66\nT-1\n^\nD15/01/24\nT-2\n^\n", DateOrderDMY)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "2023-01-15", lines[0].Date)
+			assert.Equal(t, "2024-01-15", lines[1].Date)
+		}
+	})
+
+	t.Run("Test case for a list of categories", func(t *testing.T) {
+		lines, err := parseQIF("!Type:Cat\nNFood\nDFood and drinks\nE\n^\n", DateOrderDMY)
+
+		assert.NoError(t, err)
+		assert.Empty(t, lines)
+	})
+
+	t.Run("Test case for an invalid date", func(t *testing.T) {
+		_, err := parseQIF("!Type:Bank\nD31/02/2023\nT-1\n^\n", DateOrderDMY)
+
+		assert.EqualError(t, err, `line 2: invalid date "31/02/2023"`)
+	})
+}
+
+func TestImportKeys(t *testing.T) {
+	lines := []StatementLine{
+		{FITID: "A1", Date: "2023-01-15", Amount: -60},
+		{Date: "2023-01-15", Amount: -60, Payee: "Noodle Shop"},
+		{Date: "2023-01-15", Amount: -60, Payee: "noodle shop"},
+		{Date: "2023-01-15", Amount: 60, Memo: "Noodle Shop"},
+	}
+
+	keys := importKeys(lines)
+
+	assert.Equal(t, []string{"fitid:A1", "fp:2023-01-15:-6000:noodleshop", "fp:2023-01-15:-6000:noodleshop:2", "fp:2023-01-15:6000:noodleshop"}, keys)
+}
+
+func TestDecodeStatement(t *testing.T) {
+	assert.Equal(t, "PSHELL ปตท", decodeStatement([]byte("PSHELL \xbb\xb5\xb7")))
+	assert.Equal(t, "PSHELL ปตท", decodeStatement([]byte("\xef\xbb\xbfPSHELL ปตท")))
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatOFX, detectFormat(sgmlStatement))
+	assert.Equal(t, FormatOFX, detectFormat(xmlStatement))
+	assert.Equal(t, FormatQIF, detectFormat("\n!Type:Bank\n"))
+	assert.Equal(t, "", detectFormat("date,amount\n"))
+}
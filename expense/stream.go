@@ -0,0 +1,249 @@
+package expense
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+// outboxChannel is notified by a trigger on every outbox insert, once the
+// inserting transaction commits.
+const outboxChannel = "outbox"
+
+const HeaderLastEventID = "Last-Event-ID"
+
+// outboxSequenceLock is the advisory lock serializing sequenceOutbox.
+const outboxSequenceLock = 0x6f7574626f78
+
+// sequenceOutboxSQL stamps the committed events that have no sequence yet.
+const sequenceOutboxSQL = `UPDATE outbox SET seq = nextval('outbox_seq')
+	WHERE id IN (SELECT id FROM outbox WHERE seq IS NULL ORDER BY id FOR UPDATE)`
+
+var (
+	streamHeartbeat = 15 * time.Second
+	streamBatchSize = 100
+)
+
+// streamHub wakes every open stream when the outbox changes. Wake-ups are
+// coalesced; a woken stream reads whatever it has not sent yet.
+type streamHub struct {
+	mu       sync.Mutex
+	subs     map[chan struct{}]struct{}
+	closed   bool
+	listener *pq.Listener
+}
+
+var hub = newStreamHub()
+
+func newStreamHub() *streamHub {
+	return &streamHub{subs: map[chan struct{}]struct{}{}}
+}
+
+func (h *streamHub) subscribe() (<-chan struct{}, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *streamHub) broadcast() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close ends every open stream and refuses new ones.
+func (h *streamHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// StartStream listens for outbox notifications so open streams see changes
+// as soon as they commit.
+func StartStream(cfg config.Database) error {
+	l := pq.NewListener(cfg.URL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("outbox listener error: %v", err)
+		}
+	})
+	if err := l.Listen(outboxChannel); err != nil {
+		l.Close()
+		return fmt.Errorf("listen for outbox notifications: %w", err)
+	}
+	hub.listener = l
+
+	go func() {
+		// A nil notification follows a reconnect, when notifications may
+		// have been missed, so it wakes the streams too.
+		for range l.Notify {
+			if err := sequenceOutbox(context.Background()); err != nil {
+				log.Printf("sequence outbox error: %v", err)
+			}
+			hub.broadcast()
+		}
+	}()
+	return nil
+}
+
+// sequenceOutbox gives committed events the sequence streams read them by.
+// Outbox ids are taken when a transaction inserts, not when it commits, so a
+// stream reading by id would skip an event whose transaction commits after a
+// later one. Stamping holds an advisory lock until it commits, so sequences
+// become visible in order and a cursor never passes an event still to come.
+// Stamped events are notified so that streams on every instance wake up.
+func sequenceOutbox(ctx context.Context) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", outboxSequenceLock); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, sequenceOutboxSQL)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		if _, err = tx.ExecContext(ctx, "SELECT pg_notify($1, '')", outboxChannel); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// StopStream closes every open stream. It must run before the HTTP server
+// shuts down, which otherwise waits for the streams to end.
+func StopStream() error {
+	hub.close()
+	if hub.listener == nil {
+		return nil
+	}
+	return hub.listener.Close()
+}
+
+// StreamExpensesHandler sends expense events as server-sent events. The event
+// id is the sequence stamped by sequenceOutbox, which follows commit order, so
+// a client reconnecting with Last-Event-ID receives every event it missed.
+// The API has a single token granting access to every expense, so a caller
+// allowed to open the stream is allowed to see every event on it; there is no
+// narrower permission to scope it by.
+func StreamExpensesHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var last int64
+	if id := c.Request().Header.Get(HeaderLastEventID); id != "" {
+		var err error
+		if last, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return ErrInvalidRequest.WithDetailKey("detail.lastEventId.format", i18n.Params{"header": HeaderLastEventID}).Wrap(err)
+		}
+	} else if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM outbox").Scan(&last); err != nil {
+		c.Logger().Error("query outbox error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+
+	wake, unsubscribe := hub.subscribe()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		if last, err = writeStreamEvents(ctx, res, last); err != nil {
+			c.Logger().Error("stream expenses error: ", err)
+			return nil
+		}
+		res.Flush()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-wake:
+			if !ok {
+				return nil
+			}
+		case <-heartbeat.C:
+			// Keeps proxies from closing an idle stream and catches up on
+			// notifications lost while the listener reconnected.
+			if _, err := io.WriteString(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// streamEventsSQL reads expense events only; income, transfers and budget
+// alerts share the outbox but are not expenses.
+const streamEventsSQL = "SELECT seq, type, payload FROM outbox WHERE seq > $1 AND type LIKE 'expense.%' ORDER BY seq LIMIT $2"
+
+// writeStreamEvents writes the events sequenced after last and returns the
+// sequence of the last one written.
+func writeStreamEvents(ctx context.Context, w io.Writer, last int64) (int64, error) {
+	for {
+		rows, err := db.QueryContext(ctx, streamEventsSQL, last, streamBatchSize)
+		if err != nil {
+			return last, err
+		}
+		n := 0
+		for rows.Next() {
+			var event string
+			var payload []byte
+			if err = rows.Scan(&last, &event, &payload); err != nil {
+				rows.Close()
+				return last, err
+			}
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", last, event, payload); err != nil {
+				rows.Close()
+				return last, err
+			}
+			n++
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return last, err
+		}
+		if n < streamBatchSize {
+			return last, nil
+		}
+	}
+}
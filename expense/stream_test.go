@@ -0,0 +1,144 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamHub(t *testing.T) {
+	h := newStreamHub()
+	wake, unsubscribe := h.subscribe()
+
+	h.broadcast()
+	h.broadcast()
+	_, ok := <-wake
+	assert.True(t, ok)
+	assert.Len(t, wake, 0, "wake-ups should be coalesced")
+
+	h.close()
+	_, ok = <-wake
+	assert.False(t, ok)
+	unsubscribe()
+
+	closed, _ := h.subscribe()
+	_, ok = <-closed
+	assert.False(t, ok)
+}
+
+func TestStreamExpensesHandler(t *testing.T) {
+	defer func() { hub = newStreamHub() }()
+	streamSQL := "SELECT seq, type, payload FROM outbox WHERE seq > $1 AND type LIKE 'expense.%' ORDER BY seq LIMIT $2"
+
+	t.Run("Test case for resuming after the last event id", func(t *testing.T) {
+		// A closed hub ends the stream once the backlog is written.
+		hub = newStreamHub()
+		hub.close()
+
+		req := httptest.NewRequest(http.MethodGet, "/expenses/stream", nil)
+		req.Header.Set(HeaderLastEventID, "41")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(streamSQL)).WithArgs(int64(41), streamBatchSize).
+			WillReturnRows(sqlmock.NewRows([]string{"seq", "type", "payload"}).
+				AddRow(42, EventExpenseCreated, []byte(`{"id": "e1"}`)).
+				AddRow(44, EventExpenseDeleted, []byte(`{"id": "e2"}`)))
+
+		err = StreamExpensesHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, "text/event-stream", rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, "id: 42\nevent: expense.created\ndata: {\"id\": \"e1\"}\n\n"+
+				"id: 44\nevent: expense.deleted\ndata: {\"id\": \"e2\"}\n\n", rec.Body.String())
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for starting at the end of the outbox", func(t *testing.T) {
+		hub = newStreamHub()
+		hub.close()
+
+		req := httptest.NewRequest(http.MethodGet, "/expenses/stream", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(MAX(seq), 0) FROM outbox")).WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(50))
+		mock.ExpectQuery(regexp.QuoteMeta(streamSQL)).WithArgs(int64(50), streamBatchSize).WillReturnRows(sqlmock.NewRows([]string{"seq", "type", "payload"}))
+
+		err = StreamExpensesHandler(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "", rec.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an invalid last event id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/stream", nil)
+		req.Header.Set(HeaderLastEventID, "abc")
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := StreamExpensesHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestSequenceOutbox(t *testing.T) {
+	t.Run("Test case for stamping committed events", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WithArgs(outboxSequenceLock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(sequenceOutboxSQL)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_notify($1, '')")).WithArgs(outboxChannel).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err = sequenceOutbox(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for nothing to stamp", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_xact_lock($1)")).WithArgs(outboxSequenceLock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(sequenceOutboxSQL)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		err = sequenceOutbox(context.Background())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,226 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+	"golang.org/x/text/unicode/norm"
+)
+
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TagRename struct {
+	Name string `json:"name"`
+}
+
+// NormalizeTag trims, lowercases and NFC-normalizes a tag so that "Food",
+// "food " and a decomposed "food" are stored as the same tag.
+func NormalizeTag(tag string) string {
+	return norm.NFC.String(strings.ToLower(strings.TrimSpace(tag)))
+}
+
+// NormalizeTags normalizes every tag and drops the duplicates that
+// normalization produces, keeping the first occurrence.
+func NormalizeTags(tags []string) []string {
+	if tags == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+func GetTagsHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT t.name, COUNT(e.id) FROM tags t LEFT JOIN expenses e ON e.tags @> ARRAY[t.name] GROUP BY t.name ORDER BY t.name")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrTagQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	ts := []Tag{}
+	for rows.Next() {
+		t := Tag{}
+		if err = rows.Scan(&t.Name, &t.Count); err != nil {
+			c.Logger().Error("scan tag error: ", err)
+			return ErrTagQuery.Wrap(err)
+		}
+		ts = append(ts, t)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate tags error: ", err)
+		return ErrTagQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, ts)
+}
+
+// RenameTagHandler renames a tag on every expense. Renaming onto an existing
+// tag merges the two, without leaving an expense tagged twice.
+func RenameTagHandler(c echo.Context) error {
+	from := NormalizeTag(c.Param("name"))
+
+	r := TagRename{}
+	if err := c.Bind(&r); err != nil {
+		c.Logger().Error("invalid request binding to struct tag error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	to := NormalizeTag(r.Name)
+	if !validator.tagPattern.MatchString(to) {
+		return ErrInvalidTag.WithFields(problem.NewFieldError("body", "/name", "pattern", "expense.tags.pattern", i18n.Params{"tag": to}))
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	if err = deleteTag(ctx, tx, c, from); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, "INSERT INTO tags (name) VALUES ($1) ON CONFLICT DO NOTHING", to); err != nil {
+		c.Logger().Error("insert tag error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+
+	es, err := retag(ctx, tx, renameTagSQL, from, to)
+	if err != nil {
+		c.Logger().Error("rename tag error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	if err = enqueueUpdated(ctx, tx, es); err != nil {
+		c.Logger().Error("enqueue events error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+
+	t := Tag{Name: to}
+	if err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM expenses WHERE tags @> ARRAY[$1]", to).Scan(&t.Count); err != nil {
+		c.Logger().Error("count tag error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+// DeleteTagHandler removes a tag and strips it from every expense. A tag that
+// is the only one on some expense is kept, as the expense would be left
+// without the tag it must have.
+func DeleteTagHandler(c echo.Context) error {
+	name := NormalizeTag(c.Param("name"))
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	if err = deleteTag(ctx, tx, c, name); err != nil {
+		return err
+	}
+	es, err := retag(ctx, tx, removeTagSQL, name)
+	if err != nil {
+		c.Logger().Error("remove tag error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	untagged := 0
+	for _, e := range es {
+		if len(e.Tags) == 0 {
+			untagged++
+		}
+	}
+	if untagged > 0 {
+		return ErrTagInUse.WithDetailKey("detail.tag.inUse", i18n.Params{"name": name, "count": untagged})
+	}
+	if err = enqueueUpdated(ctx, tx, es); err != nil {
+		c.Logger().Error("enqueue events error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// renameTagSQL replaces $1 with $2, dropping the copy when an expense
+// already has both.
+const renameTagSQL = `UPDATE expenses SET tags = ARRAY(
+		SELECT tag FROM unnest(array_replace(tags, $1, $2)) WITH ORDINALITY AS u(tag, i) GROUP BY tag ORDER BY MIN(i)
+	) WHERE tags @> ARRAY[$1] RETURNING ` + transactionColumns
+
+const removeTagSQL = "UPDATE expenses SET tags = array_remove(tags, $1) WHERE tags @> ARRAY[$1] RETURNING " + transactionColumns
+
+// retag runs an update of tags and returns the transactions it changed.
+func retag(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]Expense, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var es []Expense
+	for rows.Next() {
+		e := Expense{}
+		if err = scanTransaction(rows, &e); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return es, rows.Err()
+}
+
+// enqueueUpdated publishes an update event for each of es.
+func enqueueUpdated(ctx context.Context, tx *sql.Tx, es []Expense) error {
+	evs := make([]Event, len(es))
+	for i, e := range es {
+		evs[i] = newEvent(eventFor(e, EventExpenseUpdated), e)
+	}
+	return enqueueEvents(ctx, tx, evs)
+}
+
+func deleteTag(ctx context.Context, tx *sql.Tx, c echo.Context, name string) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM tags WHERE name = $1", name)
+	if err != nil {
+		c.Logger().Error("delete tag error: ", err)
+		return ErrTagUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrTagUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrTagNotFound.WithDetailKey("detail.tag.notFound", i18n.Params{"name": name})
+	}
+	return nil
+}
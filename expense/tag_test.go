@@ -0,0 +1,245 @@
+//go:build unit
+
+package expense
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{name: "Test case for trimming and lowercasing", tags: []string{" Food", "BEVERAGE "}, want: []string{"food", "beverage"}},
+		{name: "Test case for composing decomposed characters", tags: []string{"cafe\u0301"}, want: []string{"caf\u00e9"}},
+		{name: "Test case for dropping duplicates after normalization", tags: []string{"Food", "food ", "drink", "food"}, want: []string{"food", "drink"}},
+		{name: "Test case for keeping thai tags", tags: []string{" อาหาร "}, want: []string{"อาหาร"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, NormalizeTags(test.tags))
+		})
+	}
+}
+
+func TestCreateExpenseHandlerNormalizesTags(t *testing.T) {
+	body := `{"title":"title","amount":100,"note":"note","tags":["Food","food ","Drink"]}`
+	req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("title", 100.0, "note", pq.Array([]string{"food", "drink"}), nil, nil, nil, nil, nil, "expense", nil).WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-15"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = CreateExpenseHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"id":1,"title":"title","amount":100,"note":"note","tags":["food","drink"],"date":"2023-01-15"}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTagsHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/tags", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT t.name, COUNT(e.id) FROM tags t")).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "count"}).AddRow("drink", 0).AddRow("food", 2))
+
+	err = GetTagsHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `[{"name":"drink","count":0},{"name":"food","count":2}]`, strings.TrimSpace(rec.Body.String()))
+	}
+}
+
+func TestRenameTagHandler(t *testing.T) {
+	newContext := func(name, body string) (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodPut, "/tags/"+name, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/tags/:name")
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		return c, rec
+	}
+
+	t.Run("Test case for merging a tag in one transaction", func(t *testing.T) {
+		c, rec := newContext("Food", `{"name":" Meal "}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tags WHERE name = $1")).WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tags (name) VALUES ($1) ON CONFLICT DO NOTHING")).WithArgs("meal").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET tags = ARRAY(")).WithArgs("food", "meal").
+			WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+				AddRow(1, "Lunch", 120.0, "", pq.Array([]string{"meal"}), nil, "2023-01-20", nil, nil, 1, KindExpense, nil, nil, nil).
+				AddRow(2, "Salary", 900.0, "", pq.Array([]string{"meal", "work"}), nil, "2023-01-25", nil, nil, 1, KindIncome, nil, nil, nil))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox (event_id, type, payload) SELECT * FROM unnest($1::text[], $2::text[], $3::jsonb[])")).
+			WithArgs(sqlmock.AnyArg(), pq.Array([]string{EventExpenseUpdated, EventTransactionUpdated}), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 2))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM expenses WHERE tags @> ARRAY[$1]")).WithArgs("meal").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+		mock.ExpectCommit()
+
+		err = RenameTagHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `{"name":"meal","count":5}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for renaming an unknown tag", func(t *testing.T) {
+		c, _ := newContext("missing", `{"name":"meal"}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tags WHERE name = $1")).WithArgs("missing").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err = RenameTagHandler(c)
+
+		assert.ErrorIs(t, err, ErrTagNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for rolling back a failed rename", func(t *testing.T) {
+		c, _ := newContext("food", `{"name":"meal"}`)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tags WHERE name = $1")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO tags (name) VALUES ($1) ON CONFLICT DO NOTHING")).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET tags = ARRAY(")).WillReturnError(errors.New("database error"))
+		mock.ExpectRollback()
+
+		err = RenameTagHandler(c)
+
+		assert.ErrorIs(t, err, ErrTagUpdate)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for invalid new name", func(t *testing.T) {
+		c, _ := newContext("food", `{"name":"#meal"}`)
+
+		err := RenameTagHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidTag)
+	})
+}
+
+func TestDeleteTagHandler(t *testing.T) {
+	newContext := func() (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodDelete, "/tags/food", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/tags/:name")
+		c.SetParamNames("name")
+		c.SetParamValues("Food")
+		return c, rec
+	}
+
+	t.Run("Test case for stripping a tag from its expenses", func(t *testing.T) {
+		c, rec := newContext()
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tags WHERE name = $1")).WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET tags = array_remove(tags, $1) WHERE tags @> ARRAY[$1] RETURNING " + transactionColumns)).WithArgs("food").
+			WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+				AddRow(1, "Lunch", 120.0, "", pq.Array([]string{"work"}), nil, "2023-01-20", nil, nil, 1, KindExpense, nil, nil, nil))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox (event_id, type, payload) SELECT * FROM unnest($1::text[], $2::text[], $3::jsonb[])")).
+			WithArgs(sqlmock.AnyArg(), pq.Array([]string{EventExpenseUpdated}), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		err = DeleteTagHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for keeping the only tag of an expense", func(t *testing.T) {
+		c, _ := newContext()
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM tags WHERE name = $1")).WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(regexp.QuoteMeta("UPDATE expenses SET tags = array_remove(tags, $1)")).WithArgs("food").
+			WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+				AddRow(1, "Lunch", 120.0, "", pq.Array([]string{"work"}), nil, "2023-01-20", nil, nil, 1, KindExpense, nil, nil, nil).
+				AddRow(2, "Dinner", 300.0, "", pq.Array([]string{}), nil, "2023-01-21", nil, nil, 1, KindExpense, nil, nil, nil))
+		mock.ExpectRollback()
+
+		err = DeleteTagHandler(c)
+
+		assert.ErrorIs(t, err, ErrTagInUse)
+		var p *problem.Error
+		if assert.ErrorAs(t, err, &p) {
+			assert.Equal(t, `tag "food" is the only tag on 1 transactions; retag them first`, p.Detail)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
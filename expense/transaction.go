@@ -0,0 +1,420 @@
+package expense
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const (
+	KindExpense  = "expense"
+	KindIncome   = "income"
+	KindTransfer = "transfer"
+
+	// anyKind lifts the kind filter of list queries.
+	anyKind = "*"
+)
+
+// TransferLink is the transfer a transaction is a leg of. The leg on
+// FromAccountID is the money leaving and the one on ToAccountID the money
+// coming in.
+type TransferLink struct {
+	ID            int `json:"id"`
+	FromAccountID int `json:"from_account_id"`
+	ToAccountID   int `json:"to_account_id"`
+}
+
+// transactionColumns are expenseColumns followed by the kind and the
+// transfer, if any.
+const transactionColumns = expenseColumns + ", kind, transfer_id, " +
+	"(SELECT from_account_id FROM transfers WHERE transfers.id = transfer_id), " +
+	"(SELECT to_account_id FROM transfers WHERE transfers.id = transfer_id)"
+
+// scanTransaction reads a row selected with transactionColumns, followed by
+// any more columns into more.
+func scanTransaction(s scanner, e *Expense, more ...interface{}) error {
+	var id, from, to *int
+	if err := scanExpense(s, e, append([]interface{}{&e.Kind, &id, &from, &to}, more...)...); err != nil {
+		return err
+	}
+	if id != nil {
+		e.Transfer = &TransferLink{ID: *id, FromAccountID: *from, ToAccountID: *to}
+	}
+	return nil
+}
+
+// kindOf treats an expense without a kind, as the /expenses endpoints bind
+// them, as KindExpense.
+func kindOf(e Expense) string {
+	if e.Kind == "" {
+		return KindExpense
+	}
+	return e.Kind
+}
+
+var transactionEvents = map[string]string{
+	EventExpenseCreated: EventTransactionCreated,
+	EventExpenseUpdated: EventTransactionUpdated,
+	EventExpenseDeleted: EventTransactionDeleted,
+}
+
+// eventFor turns an expense event into the matching transaction event for
+// anything but an expense.
+func eventFor(e Expense, event string) string {
+	if kindOf(e) == KindExpense {
+		return event
+	}
+	return transactionEvents[event]
+}
+
+// validateKind checks what the validator leaves to the transaction
+// endpoints: that the kind is written on its own, as transfers come in pairs,
+// and that only expenses are split.
+func validateKind(e Expense) error {
+	var fields []problem.FieldError
+	switch e.Kind {
+	case KindExpense, KindIncome:
+	default:
+		fields = append(fields, problem.NewFieldError("body", "/kind", "enum", "transaction.kind.enum", nil))
+	}
+	if e.Kind == KindIncome && e.Split != nil {
+		fields = append(fields, problem.NewFieldError("body", "/split", "expenseOnly", "transaction.split.expenseOnly", nil))
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidTransaction.WithFields(fields...)
+	}
+	return nil
+}
+
+func bindTransaction(c echo.Context) (Expense, error) {
+	e := Expense{}
+	if err := c.Bind(&e); err != nil {
+		c.Logger().Error("invalid request binding to struct transaction error: ", err)
+		return e, ErrInvalidRequest.Wrap(err)
+	}
+	e.Transfer = nil
+	return e, validateKind(e)
+}
+
+// CreateTransactionHandler records an expense or income. Expenses go through
+// the same merchant guess, rules and budget alerts as with POST /expenses.
+func CreateTransactionHandler(c echo.Context) error {
+	e, err := bindTransaction(c)
+	if err != nil {
+		return err
+	}
+	return createExpense(c, e)
+}
+
+func UpdateTransactionHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	e, err := bindTransaction(c)
+	if err != nil {
+		return err
+	}
+	err = updateExpense(c, id, e)
+	if errors.Is(err, ErrNotFound) {
+		return ErrTransactionNotFound.WithDetailKey("detail.transaction.kindNotFound", i18n.Params{"kind": e.Kind, "id": id}).Wrap(err)
+	}
+	return err
+}
+
+// GetTransactionsHandler lists transactions of every kind, newest first, or
+// of the one asked for with ?kind=. Both legs of a transfer are listed unless
+// ?account= picks one side.
+func GetTransactionsHandler(c echo.Context) error {
+	f := FilterFromQuery(c)
+	switch f.Kind = c.QueryParam("kind"); f.Kind {
+	case "":
+		f.Kind = anyKind
+	case KindExpense, KindIncome, KindTransfer:
+	default:
+		return ErrInvalidRequest.WithDetailKey("detail.kind.enum", nil)
+	}
+	embed, err := embedded(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	q := &query{}
+	f.apply(q)
+
+	rows, err := db.QueryContext(ctx, "SELECT "+transactionColumns+" FROM expenses"+q.where()+orderBy["-date"], q.args...)
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	ts := []Expense{}
+	for rows.Next() {
+		e := Expense{}
+		if err = scanTransaction(rows, &e); err != nil {
+			c.Logger().Error("scan transaction error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+		ts = append(ts, e)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate transactions error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+
+	if embed {
+		if err = embedAttachments(ctx, ts); err != nil {
+			c.Logger().Error("query attachments error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, ts)
+}
+
+func GetTransactionHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	e := Expense{}
+	err = scanTransaction(db.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM expenses WHERE id = $1", id), &e)
+	if err == sql.ErrNoRows {
+		return ErrTransactionNotFound.WithDetailKey("detail.transaction.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan transaction error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, e)
+}
+
+// DeleteTransactionHandler deletes a transaction, or the whole transfer when
+// it is one of its legs, as a lone leg would leave the accounts off balance.
+func DeleteTransactionHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	var transferID *int
+	err = tx.QueryRowContext(ctx, "SELECT transfer_id FROM expenses WHERE id = $1 FOR UPDATE", id).Scan(&transferID)
+	if err == sql.ErrNoRows {
+		return ErrTransactionNotFound.WithDetailKey("detail.transaction.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("query transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	where, arg := "id = $1", id
+	if transferID != nil {
+		where, arg = "transfer_id = $1", *transferID
+	}
+
+	keys, err := attachmentKeys(ctx, tx, where, arg)
+	if err != nil {
+		c.Logger().Error("query attachments error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	var deleted []Expense
+	rows, err := tx.QueryContext(ctx, "DELETE FROM expenses WHERE "+where+" RETURNING "+transactionColumns, arg)
+	if err != nil {
+		c.Logger().Error("delete data error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	for rows.Next() {
+		e := Expense{}
+		if err = scanTransaction(rows, &e); err != nil {
+			rows.Close()
+			return ErrUpdate.Wrap(err)
+		}
+		deleted = append(deleted, e)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return ErrUpdate.Wrap(err)
+	}
+	if transferID != nil {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM transfers WHERE id = $1", *transferID); err != nil {
+			c.Logger().Error("delete transfer error: ", err)
+			return ErrUpdate.Wrap(err)
+		}
+	}
+
+	for _, e := range deleted {
+		if err = enqueueEvent(ctx, tx, eventFor(e, EventExpenseDeleted), e); err != nil {
+			c.Logger().Error("enqueue event error: ", err)
+			return ErrUpdate.Wrap(err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	deleteBlobs(ctx, c.Logger(), keys...)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CashFlowTotals is the money in and out over a span of time. Transfers
+// between two accounts cancel out unless ?account= keeps only one side.
+type CashFlowTotals struct {
+	Income       float64 `json:"income"`
+	Expenses     float64 `json:"expenses"`
+	TransfersIn  float64 `json:"transfers_in"`
+	TransfersOut float64 `json:"transfers_out"`
+	Net          float64 `json:"net"`
+}
+
+type CashFlowPeriod struct {
+	// Start is the first day of the period.
+	Start string `json:"start"`
+	CashFlowTotals
+}
+
+// CashFlow sums transactions in one currency, or in none when they all lack
+// an account.
+type CashFlow struct {
+	Period   string `json:"period"`
+	Currency string `json:"currency,omitempty"`
+	CashFlowTotals
+	Periods []CashFlowPeriod `json:"periods"`
+}
+
+// cashFlowPeriods are the ?period= values, written into the query as the
+// date_trunc field once checked against this list. Weeks start on Monday.
+var cashFlowPeriods = map[string]bool{"day": true, "week": true, "month": true, "year": true}
+
+const defaultCashFlowPeriod = "month"
+
+// cashFlowSQL sums each kind of transaction by period, with the currencies
+// of their accounts. The period is filled in and the WHERE clause and
+// grouping appended.
+const cashFlowSQL = `SELECT to_char(date_trunc('%s', e.spent_on::timestamp), 'YYYY-MM-DD'),
+	array_remove(array_agg(DISTINCT a.currency), NULL),
+	COALESCE(SUM(e.amount) FILTER (WHERE e.kind = 'income'), 0),
+	COALESCE(SUM(e.amount) FILTER (WHERE e.kind = 'expense'), 0),
+	COALESCE(SUM(e.amount) FILTER (WHERE t.to_account_id = e.account_id), 0),
+	COALESCE(SUM(e.amount) FILTER (WHERE t.from_account_id = e.account_id), 0)
+	FROM expenses e LEFT JOIN transfers t ON t.id = e.transfer_id LEFT JOIN accounts a ON a.id = e.account_id`
+
+// GetCashFlowHandler reports the net flow of money per ?period=, narrowed by
+// the usual filters or by the saved ?view= as it stands today. Only periods
+// with transactions are listed. Amounts in different currencies are not
+// added up: such a summary is refused until ?account= narrows it to one.
+func GetCashFlowHandler(c echo.Context) error {
+	period := c.QueryParam("period")
+	if period == "" {
+		period = defaultCashFlowPeriod
+	}
+	if !cashFlowPeriods[period] {
+		return ErrInvalidRequest.WithDetailKey("detail.period.enum", nil)
+	}
+
+	f := FilterFromQuery(c)
+	if s := c.QueryParam("view"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return ErrInvalidRequest.WithDetailKey("detail.view.id", nil).Wrap(err)
+		}
+		v, err := loadView(c, id)
+		if err != nil {
+			return err
+		}
+		f = v.Filter(today())
+	}
+	f.Kind = anyKind
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	q := &query{}
+	f.apply(q)
+
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(cashFlowSQL, period)+q.where()+" GROUP BY 1 ORDER BY 1", q.args...)
+	if err != nil {
+		c.Logger().Error("query cash flow error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	flow := CashFlow{Period: period, Periods: []CashFlowPeriod{}}
+	var total [4]int64
+	currencies := map[string]bool{}
+	for rows.Next() {
+		p := CashFlowPeriod{}
+		var cs []string
+		if err = rows.Scan(&p.Start, pq.Array(&cs), &p.Income, &p.Expenses, &p.TransfersIn, &p.TransfersOut); err != nil {
+			c.Logger().Error("scan cash flow error: ", err)
+			return ErrQuery.Wrap(err)
+		}
+		for _, cur := range cs {
+			currencies[cur] = true
+		}
+		sums := [4]int64{cents(p.Income), cents(p.Expenses), cents(p.TransfersIn), cents(p.TransfersOut)}
+		for i := range total {
+			total[i] += sums[i]
+		}
+		p.CashFlowTotals = cashFlowTotals(sums)
+		flow.Periods = append(flow.Periods, p)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate cash flow error: ", err)
+		return ErrQuery.Wrap(err)
+	}
+	if len(currencies) > 1 {
+		cs := make([]string, 0, len(currencies))
+		for cur := range currencies {
+			cs = append(cs, cur)
+		}
+		sort.Strings(cs)
+		return ErrInvalidRequest.WithDetailKey("detail.cashFlow.currencies", i18n.Params{"currencies": strings.Join(cs, ", ")})
+	}
+	for cur := range currencies {
+		flow.Currency = cur
+	}
+	flow.CashFlowTotals = cashFlowTotals(total)
+
+	return c.JSON(http.StatusOK, flow)
+}
+
+// cashFlowTotals builds totals from income, expenses, transfers in and
+// transfers out in cents.
+func cashFlowTotals(sums [4]int64) CashFlowTotals {
+	return CashFlowTotals{
+		Income:       float64(sums[0]) / 100,
+		Expenses:     float64(sums[1]) / 100,
+		TransfersIn:  float64(sums[2]) / 100,
+		TransfersOut: float64(sums[3]) / 100,
+		Net:          float64(sums[0]-sums[1]+sums[2]-sums[3]) / 100,
+	}
+}
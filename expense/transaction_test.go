@@ -0,0 +1,374 @@
+//go:build unit
+
+package expense
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+var transactionRowColumns = append(append([]string{}, expenseRowColumns...), "kind", "transfer_id", "from_account_id", "to_account_id")
+
+func TestValidateKind(t *testing.T) {
+	tests := []struct {
+		name string
+		e    Expense
+		keys []string
+	}{
+		{name: "an income", e: Expense{Kind: KindIncome}},
+		{name: "a missing kind", e: Expense{}, keys: []string{"transaction.kind.enum"}},
+		{name: "a transfer", e: Expense{Kind: KindTransfer}, keys: []string{"transaction.kind.enum"}},
+		{name: "a split income", e: Expense{Kind: KindIncome, Split: &Split{}}, keys: []string{"transaction.split.expenseOnly"}},
+	}
+
+	for _, test := range tests {
+		t.Run("Test case for "+test.name, func(t *testing.T) {
+			err := validateKind(test.e)
+
+			if test.keys == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var pe *problem.Error
+			if assert.ErrorAs(t, err, &pe) {
+				var keys []string
+				for _, f := range pe.Fields {
+					keys = append(keys, f.Key)
+				}
+				assert.Equal(t, test.keys, keys)
+			}
+		})
+	}
+}
+
+func TestCreateTransactionHandler(t *testing.T) {
+	body := `{"kind":"income","title":"salary","amount":45000,"note":"January","tags":["salary"],"account_id":2}`
+	req := httptest.NewRequest(http.MethodPost, "/transactions", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta(suggestMerchantSQL)).WillReturnRows(sqlmock.NewRows([]string{"id", "key"}))
+	mock.ExpectQuery(regexp.QuoteMeta(loadRulesSQL)).WillReturnRows(sqlmock.NewRows(ruleRowColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("salary", 45000.0, "January", pq.Array([]string{"salary"}), nil, nil, nil, nil, 2, KindIncome, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(1, "2023-01-25"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WithArgs(sqlmock.AnyArg(), EventTransactionCreated, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = CreateTransactionHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"title":"salary","amount":45000,"note":"January","tags":["salary"],"date":"2023-01-25","account_id":2,"kind":"income"}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTransactionsHandler(t *testing.T) {
+	t.Run("Test case for listing one kind", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions?kind=transfer&account=1", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT "+transactionColumns+" FROM expenses WHERE account_id IN (")+".*"+regexp.QuoteMeta("AND kind = $2 ORDER BY spent_on DESC, id DESC")).
+			WithArgs("1", KindTransfer).
+			WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+				AddRow(7, "Transfer", 500.0, "top up", pq.Array([]string{"cash"}), nil, "2023-01-20", nil, nil, 1, KindTransfer, 3, 2, 1))
+
+		err = GetTransactionsHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `[{"id":7,"title":"Transfer","amount":500,"note":"top up","tags":["cash"],"date":"2023-01-20","account_id":1,"kind":"transfer",`+
+				`"transfer":{"id":3,"from_account_id":2,"to_account_id":1}}]`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an unknown kind", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions?kind=refund", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		err := GetTransactionsHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestCreateTransferHandler(t *testing.T) {
+	t.Run("Test case for writing both legs together", func(t *testing.T) {
+		body := `{"from_account_id":2,"to_account_id":1,"amount":500,"note":"top up","tags":["Cash"],"date":"2023-01-20"}`
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfers", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO transfers (from_account_id, to_account_id) VALUES ($1, $2) RETURNING id")).WithArgs(2, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(transferCurrenciesSQL)).WithArgs(2, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "currency"}).AddRow("THB", "THB"))
+		for i, account := range []int{2, 1} {
+			mock.ExpectQuery(regexp.QuoteMeta(createExpenseSQL)).WithArgs("Transfer", 500.0, "top up", pq.Array([]string{"cash"}), nil, "2023-01-20", nil, nil, account, KindTransfer, 3).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "date"}).AddRow(7+i, "2023-01-20"))
+			mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WithArgs(sqlmock.AnyArg(), EventTransactionCreated, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		mock.ExpectCommit()
+
+		err = CreateTransferHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			leg := `"title":"Transfer","amount":500,"note":"top up","tags":["cash"],"date":"2023-01-20","account_id":%d,"kind":"transfer","transfer":{"id":3,"from_account_id":2,"to_account_id":1}`
+			assert.Equal(t, `{"id":3,"from_account_id":2,"to_account_id":1,"title":"Transfer","amount":500,"note":"top up","tags":["cash"],"date":"2023-01-20","transactions":[`+
+				`{"id":7,`+fmt.Sprintf(leg, 2)+`},{"id":8,`+fmt.Sprintf(leg, 1)+`}]}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for an unknown account", func(t *testing.T) {
+		body := `{"from_account_id":2,"to_account_id":99,"amount":500,"note":"top up","tags":["cash"]}`
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfers", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO transfers")).
+			WillReturnError(&pq.Error{Code: foreignKeyViolation, Constraint: "transfers_to_account_id_fkey"})
+		mock.ExpectRollback()
+
+		err = CreateTransferHandler(c)
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.ErrorIs(t, err, ErrInvalidTransfer)
+			assert.Equal(t, "transfer.to_account_id.exists", pe.Fields[0].Key)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for accounts in different currencies", func(t *testing.T) {
+		body := `{"from_account_id":2,"to_account_id":4,"amount":500,"note":"top up","tags":["cash"]}`
+		req := httptest.NewRequest(http.MethodPost, "/transactions/transfers", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO transfers")).WithArgs(2, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery(regexp.QuoteMeta(transferCurrenciesSQL)).WithArgs(2, 4).
+			WillReturnRows(sqlmock.NewRows([]string{"currency", "currency"}).AddRow("THB", "USD"))
+		mock.ExpectRollback()
+
+		err = CreateTransferHandler(c)
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.ErrorIs(t, err, ErrInvalidTransfer)
+			assert.Equal(t, "transfer.to_account_id.currency", pe.Fields[0].Key)
+			assert.Equal(t, "to_account_id uses USD but from_account_id uses THB; a transfer needs both in one currency", pe.Fields[0].Message)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a transfer to the same account", func(t *testing.T) {
+		t2 := AccountTransfer{FromAccountID: 1, ToAccountID: 1, Title: "Transfer", Amount: 10, Note: "n", Tags: []string{"cash"}}
+
+		err := t2.Validate()
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.Equal(t, "transfer.to_account_id.distinct", pe.Fields[0].Key)
+		}
+	})
+}
+
+func TestDeleteTransactionHandlerDeletesWholeTransfer(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "/transactions/8", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("8")
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT transfer_id FROM expenses WHERE id = $1 FOR UPDATE")).WithArgs(8).
+		WillReturnRows(sqlmock.NewRows([]string{"transfer_id"}).AddRow(3))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT storage_key FROM attachments WHERE expense_id IN (SELECT id FROM expenses WHERE transfer_id = $1)")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}))
+	mock.ExpectQuery(regexp.QuoteMeta("DELETE FROM expenses WHERE transfer_id = $1 RETURNING " + transactionColumns)).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(transactionRowColumns).
+			AddRow(7, "Transfer", 500.0, "top up", pq.Array([]string{"cash"}), nil, "2023-01-20", nil, nil, 2, KindTransfer, 3, 2, 1).
+			AddRow(8, "Transfer", 500.0, "top up", pq.Array([]string{"cash"}), nil, "2023-01-20", nil, nil, 1, KindTransfer, 3, 2, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM transfers WHERE id = $1")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WithArgs(sqlmock.AnyArg(), EventTransactionDeleted, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectCommit()
+
+	err = DeleteTransactionHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetCashFlowHandler(t *testing.T) {
+	cashFlowColumns := []string{"start", "currencies", "income", "expenses", "transfers_in", "transfers_out"}
+
+	t.Run("Test case for net flow per month", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/summary?from=2023-01-01", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT to_char(date_trunc('month', e.spent_on::timestamp), 'YYYY-MM-DD')") + ".*" +
+			regexp.QuoteMeta("WHERE spent_on >= $1::date GROUP BY 1 ORDER BY 1")).WithArgs("2023-01-01").
+			WillReturnRows(sqlmock.NewRows(cashFlowColumns).
+				AddRow("2023-01-01", pq.Array([]string{"THB"}), 45000.0, 12000.1, 500.0, 500.0).
+				AddRow("2023-02-01", pq.Array([]string{}), 0.0, 300.2, 0.0, 0.0))
+
+		err = GetCashFlowHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `{"period":"month","currency":"THB","income":45000,"expenses":12300.3,"transfers_in":500,"transfers_out":500,"net":32699.7,"periods":[`+
+				`{"start":"2023-01-01","income":45000,"expenses":12000.1,"transfers_in":500,"transfers_out":500,"net":32999.9},`+
+				`{"start":"2023-02-01","income":0,"expenses":300.2,"transfers_in":0,"transfers_out":0,"net":-300.2}]}`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for the transactions of a saved view", func(t *testing.T) {
+		defer func() { now = time.Now }()
+		now = func() time.Time { return time.Date(2023, 3, 31, 9, 0, 0, 0, time.Local) }
+
+		req := httptest.NewRequest(http.MethodGet, "/transactions/summary?view=1&tag=ignored", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + viewColumns + " FROM views WHERE id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(viewRowColumns).AddRow(1, "coffee", pq.Array([]string{"coffee"}), "", nil, nil, "30d", "-date"))
+		mock.ExpectQuery(regexp.QuoteMeta("WHERE tags @> $1 AND spent_on >= $2::date AND spent_on <= $3::date GROUP BY 1 ORDER BY 1")).
+			WithArgs(pq.Array([]string{"coffee"}), "2023-03-02", "2023-03-31").
+			WillReturnRows(sqlmock.NewRows(cashFlowColumns).
+				AddRow("2023-03-01", pq.Array([]string{"THB"}), 0.0, 180.0, 0.0, 0.0))
+
+		err = GetCashFlowHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Body.String(), `"expenses":180,`)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for refusing to add up currencies", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/summary", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("LEFT JOIN accounts a ON a.id = e.account_id GROUP BY 1 ORDER BY 1")).
+			WillReturnRows(sqlmock.NewRows(cashFlowColumns).
+				AddRow("2023-01-01", pq.Array([]string{"THB"}), 100.0, 0.0, 0.0, 0.0).
+				AddRow("2023-02-01", pq.Array([]string{"THB", "USD"}), 0.0, 20.0, 0.0, 0.0))
+
+		err = GetCashFlowHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+		var p *problem.Error
+		if assert.ErrorAs(t, err, &p) {
+			assert.Equal(t, "transactions are in THB, USD; narrow the summary to one currency with ?account=", p.Detail)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a view that is not an id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/summary?view=coffee", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+
+		err := GetCashFlowHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("Test case for an unknown period", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/transactions/summary?period=quarter", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+
+		err := GetCashFlowHandler(c)
+
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
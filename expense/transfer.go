@@ -0,0 +1,154 @@
+package expense
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const defaultTransferTitle = "Transfer"
+
+// AccountTransfer moves money from one account to another. It is saved as a
+// pair of transactions of KindTransfer, one on each account, written in one
+// database transaction so neither leg exists without the other.
+type AccountTransfer struct {
+	ID            int      `json:"id"`
+	FromAccountID int      `json:"from_account_id"`
+	ToAccountID   int      `json:"to_account_id"`
+	Title         string   `json:"title"`
+	Amount        float64  `json:"amount"`
+	Note          string   `json:"note"`
+	Tags          []string `json:"tags"`
+	Date          string   `json:"date,omitempty"`
+	// Transactions are the legs out of FromAccountID and into ToAccountID.
+	Transactions []Expense `json:"transactions,omitempty"`
+}
+
+// leg is the transaction of the transfer on account.
+func (t *AccountTransfer) leg(account int) Expense {
+	return Expense{Title: t.Title, Amount: t.Amount, Note: t.Note, Tags: t.Tags, Date: t.Date, AccountID: &account, Kind: KindTransfer}
+}
+
+// Validate checks the legs against the same rules as any other transaction.
+func (t *AccountTransfer) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "transfer."+field+"."+rule, nil))
+	}
+
+	if t.FromAccountID < 1 {
+		add("/from_account_id", "from_account_id", "required")
+	}
+	switch {
+	case t.ToAccountID < 1:
+		add("/to_account_id", "to_account_id", "required")
+	case t.ToAccountID == t.FromAccountID:
+		add("/to_account_id", "to_account_id", "distinct")
+	}
+	var pe *problem.Error
+	if err := validator.Validate(t.leg(t.FromAccountID)); errors.As(err, &pe) {
+		fields = append(fields, pe.Fields...)
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidTransfer.WithFields(fields...)
+	}
+	return nil
+}
+
+// transferReferences maps the foreign keys of transfers to the field holding
+// them.
+var transferReferences = map[string]string{
+	"transfers_from_account_id_fkey": "from_account_id",
+	"transfers_to_account_id_fkey":   "to_account_id",
+}
+
+func transferWriteError(err error) error {
+	var pqErr *pq.Error
+	if pqErrorCode(err) == foreignKeyViolation && errors.As(err, &pqErr) && transferReferences[pqErr.Constraint] != "" {
+		field := transferReferences[pqErr.Constraint]
+		return ErrInvalidTransfer.WithFields(problem.NewFieldError("body", "/"+field, "exists", "transfer."+field+".exists", nil)).Wrap(err)
+	}
+	return ErrCreate.Wrap(err)
+}
+
+const transferCurrenciesSQL = "SELECT f.currency, t.currency FROM accounts f, accounts t WHERE f.id = $1 AND t.id = $2 FOR SHARE"
+
+// CreateTransferHandler records a transfer between two accounts of the same
+// currency. The title defaults to "Transfer"; rules and merchants are left out, as the money
+// stays with the owner.
+func CreateTransferHandler(c echo.Context) error {
+	t := AccountTransfer{}
+	if err := c.Bind(&t); err != nil {
+		c.Logger().Error("invalid request binding to struct transfer error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	if t.Title = strings.TrimSpace(t.Title); t.Title == "" {
+		t.Title = defaultTransferTitle
+	}
+	t.Tags = NormalizeTags(t.Tags)
+	t.Transactions = nil
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO transfers (from_account_id, to_account_id) VALUES ($1, $2) RETURNING id",
+		t.FromAccountID, t.ToAccountID).Scan(&t.ID)
+	if err != nil {
+		c.Logger().Error("insert transfer error: ", err)
+		return transferWriteError(err)
+	}
+
+	// Both legs carry the same amount, which only means the same thing in
+	// the same currency. The rows are locked so neither currency changes
+	// before the legs are written.
+	var from, to string
+	err = tx.QueryRowContext(ctx, transferCurrenciesSQL, t.FromAccountID, t.ToAccountID).Scan(&from, &to)
+	if err != nil {
+		c.Logger().Error("query account currencies error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+	if from != to {
+		return ErrInvalidTransfer.WithFields(problem.NewFieldError("body", "/to_account_id", "currency", "transfer.to_account_id.currency", i18n.Params{"from": from, "to": to}))
+	}
+
+	link := &TransferLink{ID: t.ID, FromAccountID: t.FromAccountID, ToAccountID: t.ToAccountID}
+	for _, account := range []int{t.FromAccountID, t.ToAccountID} {
+		e := t.leg(account)
+		e.Transfer = link
+		err = tx.QueryRowContext(ctx, createExpenseSQL, e.Title, e.Amount, e.Note, pq.Array(e.Tags), nil, nullIfEmpty(e.Date), nil, nil, e.AccountID, KindTransfer, t.ID).
+			Scan(&e.ID, &e.Date)
+		if err != nil {
+			c.Logger().Error("insert transfer leg error: ", err)
+			return ErrCreate.Wrap(err)
+		}
+		if err = enqueueEvent(ctx, tx, EventTransactionCreated, e); err != nil {
+			c.Logger().Error("enqueue event error: ", err)
+			return ErrCreate.Wrap(err)
+		}
+		t.Transactions = append(t.Transactions, e)
+	}
+	t.Date = t.Transactions[0].Date
+
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrCreate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, t)
+}
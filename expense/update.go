@@ -1,38 +1,87 @@
 package expense
 
 import (
-	"errors"
+	"database/sql"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
 )
 
+const updateExpenseSQL = "WITH new_tags AS (INSERT INTO tags (name) SELECT unnest($5::text[]) ON CONFLICT DO NOTHING) UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5, category_id = $6, spent_on = COALESCE($7::date, spent_on), split = $8, merchant_id = $9, account_id = $10 WHERE id = $1 AND kind = $11 RETURNING to_char(spent_on, 'YYYY-MM-DD')"
+
 func UpdateExpenseHandler(c echo.Context) error {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.Logger().Error("cast id error: ", err)
-		return c.JSON(http.StatusBadRequest, Err{Message: errors.New("invalid request").Error()})
+		return ErrInvalidRequest.Wrap(err)
 	}
 
 	e := Expense{}
 	err = c.Bind(&e)
 	if err != nil {
 		c.Logger().Error("invalid request binding to struct exepnse error: ", err)
-		return c.JSON(http.StatusBadRequest, Err{Message: errors.New("invalid request").Error()})
+		return ErrInvalidRequest.Wrap(err)
+	}
+	e.Kind, e.Transfer = "", nil
+
+	return updateExpense(c, id, e)
+}
+
+// updateExpense saves an expense or income over the one with the same id and
+// kind. Kinds are never changed, so an id of another kind is not found.
+func updateExpense(c echo.Context, id int, e Expense) error {
+	e.Tags = NormalizeTags(e.Tags)
+	e.Split.normalize()
+	if err := e.Validate(); err != nil {
+		c.Logger().Error("invalid request error: ", err)
+		return err
+	}
+	e.Split.allocate(e.Amount)
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Logger().Error("begin transaction error: ", err)
+		return ErrUpdate.Wrap(err)
 	}
+	defer tx.Rollback()
 
-	stmt, err := db.Prepare("UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1")
+	stmt, err := tx.PrepareContext(ctx, updateExpenseSQL)
 	if err != nil {
 		c.Logger().Error("prepare statment error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("cannot prepare query expense statment").Error()})
+		return ErrQuery.Wrap(err)
 	}
 
-	if _, err = stmt.Exec(id, e.Title, e.Amount, e.Note, pq.Array(e.Tags)); err != nil {
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, id, e.Title, e.Amount, e.Note, pq.Array(e.Tags), e.CategoryID, nullIfEmpty(e.Date), e.Split, e.MerchantID, e.AccountID, kindOf(e)).Scan(&e.Date)
+	if err == sql.ErrNoRows {
+		return ErrNotFound.WithDetailKey("detail.transaction.kindNotFound", i18n.Params{"kind": kindOf(e), "id": id}).Wrap(err)
+	} else if err != nil {
 		c.Logger().Error("update data error: ", err)
-		return c.JSON(http.StatusInternalServerError, Err{Message: errors.New("cannot update data").Error()})
+		return expenseWriteError(err, ErrUpdate)
 	}
 	e.ID = id
+
+	if err = enqueueEvent(ctx, tx, eventFor(e, EventExpenseUpdated), e); err != nil {
+		c.Logger().Error("enqueue event error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+	if err = tx.Commit(); err != nil {
+		c.Logger().Error("commit transaction error: ", err)
+		return ErrUpdate.Wrap(err)
+	}
+
+	if kindOf(e) == KindExpense {
+		if err := checkBudgetAlerts(ctx, e); err != nil {
+			c.Logger().Error("check budget alerts error: ", err)
+		}
+	}
+
 	return c.JSON(http.StatusOK, e)
 }
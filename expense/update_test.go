@@ -26,11 +26,14 @@ func TestUpdateExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1"
+		mockSql := updateExpenseSQL
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
-		mock.ExpectPrepare(regexp.QuoteMeta(mockSql)).ExpectExec().WithArgs(1, "update title", 99.9, "note update", pq.Array([]string{"update1", "update2"})).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(mockSql)).ExpectQuery().WithArgs(1, "update title", 99.9, "note update", pq.Array([]string{"update1", "update2"}), nil, nil, nil, nil, nil, "expense").WillReturnRows(sqlmock.NewRows([]string{"date"}).AddRow("2023-01-15"))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO outbox")).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -40,7 +43,7 @@ func TestUpdateExpenseHandler(t *testing.T) {
 
 		if assert.NoError(t, err) {
 			assert.Equal(t, http.StatusOK, rec.Code)
-			assert.Equal(t, `{"id":1,"title":"update title","amount":99.9,"note":"note update","tags":["update1","update2"]}`, strings.TrimSpace(rec.Body.String()))
+			assert.Equal(t, `{"id":1,"title":"update title","amount":99.9,"note":"note update","tags":["update1","update2"],"date":"2023-01-15"}`, strings.TrimSpace(rec.Body.String()))
 		}
 
 		if err := mock.ExpectationsWereMet(); err != nil {
@@ -48,6 +51,29 @@ func TestUpdateExpenseHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Test case for updating a missing expense", func(t *testing.T) {
+		body := `{"title":"update title","amount":99.9,"note":"note update","tags":["update1"]}`
+		req := httptest.NewRequest(http.MethodPut, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(updateExpenseSQL)).ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"date"}))
+
+		err = UpdateExpenseHandler(c)
+
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
 	t.Run("Test case for failed update expense when casting id", func(t *testing.T) {
 		body := `{"title":"update title","amount":99.9,"note":"note update","tags":["update1", "update2"]}`
 		req := httptest.NewRequest(http.MethodPut, "/expenses", strings.NewReader(body))
@@ -60,10 +86,7 @@ func TestUpdateExpenseHandler(t *testing.T) {
 
 		err := UpdateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"invalid request"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrInvalidRequest)
 	})
 
 	t.Run("Test case for failed update when bind value to struct expense", func(t *testing.T) {
@@ -78,9 +101,23 @@ func TestUpdateExpenseHandler(t *testing.T) {
 
 		err := UpdateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
-			assert.Equal(t, `{"message":"invalid request"}`, strings.TrimSpace(rec.Body.String()))
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("Test case for invalid expense on update", func(t *testing.T) {
+		body := `{"title":"","amount":0,"note":"note update","tags":["update1"]}`
+		req := httptest.NewRequest(http.MethodPut, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		err := UpdateExpenseHandler(c)
+
+		if assert.ErrorIs(t, err, ErrInvalidExpense) {
+			assert.EqualError(t, err, "title is required; amount is required and must be greater than 0")
 		}
 	})
 
@@ -94,10 +131,11 @@ func TestUpdateExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1"
+		mockSql := updateExpenseSQL
 		mockDB, mock, err := sqlmock.New()
 
 		db = mockDB
+		mock.ExpectBegin()
 		mock.ExpectQuery(regexp.QuoteMeta(mockSql)).WithArgs(1)
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -106,10 +144,7 @@ func TestUpdateExpenseHandler(t *testing.T) {
 
 		err = UpdateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"cannot prepare query expense statment"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrQuery)
 	})
 
 	t.Run("Test case for database error during update of expense", func(t *testing.T) {
@@ -122,11 +157,12 @@ func TestUpdateExpenseHandler(t *testing.T) {
 		c.SetParamNames("id")
 		c.SetParamValues("1")
 
-		mockSql := "UPDATE expenses SET title = $2, amount = $3, note = $4, tags = $5 WHERE id = $1"
+		mockSql := updateExpenseSQL
 		mockDB, mock, err := sqlmock.New()
 		db = mockDB
 
-		mock.ExpectPrepare(regexp.QuoteMeta(mockSql)).ExpectExec().WithArgs("1", "update title", 99.9, "note update", pq.Array([]string{"update1", "update2"})).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectBegin()
+		mock.ExpectPrepare(regexp.QuoteMeta(mockSql)).ExpectQuery().WithArgs("1", "update title", 99.9, "note update", pq.Array([]string{"update1", "update2"}), nil, nil, nil, nil, nil, "expense").WillReturnRows(sqlmock.NewRows([]string{"date"}).AddRow("2023-01-15"))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -134,9 +170,6 @@ func TestUpdateExpenseHandler(t *testing.T) {
 
 		err = UpdateExpenseHandler(c)
 
-		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusInternalServerError, rec.Code)
-			assert.Equal(t, `{"message":"cannot update data"}`, strings.TrimSpace(rec.Body.String()))
-		}
+		assert.ErrorIs(t, err, ErrUpdate)
 	})
 }
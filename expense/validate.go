@@ -0,0 +1,109 @@
+package expense
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+// Validator checks an expense against the business rules of a deployment and
+// reports every violation at once. Create, update and import share it.
+type Validator struct {
+	rules      config.Validation
+	tagPattern *regexp.Regexp
+}
+
+var validator = mustValidator(config.Default().Validation)
+
+func NewValidator(rules config.Validation) (*Validator, error) {
+	re, err := regexp.Compile(rules.TagPattern)
+	if err != nil {
+		return nil, fmt.Errorf("compile tag pattern: %w", err)
+	}
+	return &Validator{rules: rules, tagPattern: re}, nil
+}
+
+func mustValidator(rules config.Validation) *Validator {
+	v, err := NewValidator(rules)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func SetValidationRules(rules config.Validation) error {
+	v, err := NewValidator(rules)
+	if err != nil {
+		return err
+	}
+	validator = v
+	return nil
+}
+
+func (v *Validator) Validate(e Expense) error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		key := "expense." + field + "." + rule
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, key, params))
+	}
+
+	switch {
+	case e.Title == "":
+		add("/title", "title", "required", nil)
+	case utf8.RuneCountInString(e.Title) > v.rules.MaxTitleLength:
+		add("/title", "title", "maxLength", i18n.Params{"max": v.rules.MaxTitleLength})
+	}
+
+	switch {
+	case e.Amount <= 0:
+		add("/amount", "amount", "exclusiveMinimum", nil)
+	case e.Amount > v.rules.MaxAmount:
+		add("/amount", "amount", "maximum", i18n.Params{"max": v.rules.MaxAmount})
+	}
+
+	switch {
+	case e.Note == "":
+		add("/note", "note", "required", nil)
+	case utf8.RuneCountInString(e.Note) > v.rules.MaxNoteLength:
+		add("/note", "note", "maxLength", i18n.Params{"max": v.rules.MaxNoteLength})
+	}
+
+	switch {
+	case len(e.Tags) == 0:
+		add("/tags", "tags", "minItems", nil)
+	case len(e.Tags) > v.rules.MaxTags:
+		add("/tags", "tags", "maxItems", i18n.Params{"max": v.rules.MaxTags})
+	}
+
+	if e.Date != "" {
+		if _, err := time.Parse(dateLayout, e.Date); err != nil {
+			add("/date", "date", "format", nil)
+		}
+	}
+
+	if e.Split != nil {
+		validateSplit(e.Split, e.Amount, add)
+	}
+
+	seen := map[string]bool{}
+	for i, tag := range e.Tags {
+		pointer := fmt.Sprintf("/tags/%d", i)
+		if !v.tagPattern.MatchString(tag) {
+			add(pointer, "tags", "pattern", i18n.Params{"tag": tag})
+		}
+		if seen[tag] {
+			add(pointer, "tags", "uniqueItems", i18n.Params{"tag": tag})
+		}
+		seen[tag] = true
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidExpense.WithFields(fields...)
+	}
+	return nil
+}
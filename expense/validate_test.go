@@ -0,0 +1,69 @@
+//go:build unit
+
+package expense
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	rules := config.Default().Validation
+	rules.MaxTitleLength = 5
+	rules.MaxAmount = 1000
+	rules.MaxTags = 2
+	v, err := NewValidator(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Test case for collecting every violation", func(t *testing.T) {
+		e := Expense{Title: "too long title", Amount: 5000, Note: "", Tags: []string{"food", "#bad", "food"}}
+
+		err := v.Validate(e)
+
+		var pe *problem.Error
+		if assert.ErrorAs(t, err, &pe) {
+			assert.Equal(t, "expense_invalid", pe.Code)
+			assert.Equal(t, []problem.FieldError{
+				{In: "body", Pointer: "/title", Rule: "maxLength", Message: "title must be at most 5 characters", Key: "expense.title.maxLength", Params: i18n.Params{"max": 5}},
+				{In: "body", Pointer: "/amount", Rule: "maximum", Message: "amount must be at most 1000", Key: "expense.amount.maximum", Params: i18n.Params{"max": 1000.0}},
+				{In: "body", Pointer: "/note", Rule: "required", Message: "note is required", Key: "expense.note.required"},
+				{In: "body", Pointer: "/tags", Rule: "maxItems", Message: "at most 2 tags are allowed", Key: "expense.tags.maxItems", Params: i18n.Params{"max": 2}},
+				{In: "body", Pointer: "/tags/1", Rule: "pattern", Message: `tag "#bad" has an invalid format`, Key: "expense.tags.pattern", Params: i18n.Params{"tag": "#bad"}},
+				{In: "body", Pointer: "/tags/2", Rule: "uniqueItems", Message: `tag "food" is duplicated`, Key: "expense.tags.uniqueItems", Params: i18n.Params{"tag": "food"}},
+			}, pe.Fields)
+		}
+	})
+
+	t.Run("Test case for counting title length in characters", func(t *testing.T) {
+		e := Expense{Title: "กาแฟ", Amount: 50, Note: "เย็น", Tags: []string{"เครื่องดื่ม"}}
+
+		assert.NoError(t, v.Validate(e))
+	})
+
+	t.Run("Test case for invalid tag pattern", func(t *testing.T) {
+		rules := config.Default().Validation
+		rules.TagPattern = "("
+
+		_, err := NewValidator(rules)
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Test case for replacing the deployment rules", func(t *testing.T) {
+		defer SetValidationRules(config.Default().Validation)
+		e := Expense{Title: "title", Amount: 100, Note: "note", Tags: []string{"tag1"}}
+
+		assert.NoError(t, SetValidationRules(rules))
+		assert.NoError(t, e.Validate())
+
+		e.Title = strings.Repeat("t", 6)
+		assert.ErrorIs(t, e.Validate(), ErrInvalidExpense)
+	})
+}
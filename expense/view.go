@@ -0,0 +1,268 @@
+package expense
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+// orderBy maps the sort orders a view may use to their ORDER BY clause. A
+// leading minus sorts descending.
+var orderBy = map[string]string{
+	"date":    " ORDER BY spent_on, id",
+	"-date":   " ORDER BY spent_on DESC, id DESC",
+	"amount":  " ORDER BY amount, id",
+	"-amount": " ORDER BY amount DESC, id DESC",
+}
+
+const defaultViewSort = "-date"
+
+// windowPattern matches a date window such as 30d, 4w, 3m or 1y.
+var windowPattern = regexp.MustCompile(`^([1-9][0-9]{0,3})([dwmy])$`)
+
+// View is a saved filter. Window is relative to the day the view is run: 30d
+// covers the last 30 days including today, and 3m the last three months.
+type View struct {
+	ID        int      `json:"id"`
+	Name      string   `json:"name"`
+	Tags      []string `json:"tags,omitempty"`
+	Category  string   `json:"category,omitempty"`
+	MinAmount *float64 `json:"min_amount,omitempty"`
+	MaxAmount *float64 `json:"max_amount,omitempty"`
+	Window    string   `json:"window,omitempty"`
+	Sort      string   `json:"sort"`
+}
+
+const viewColumns = "id, name, tags, COALESCE(category, ''), min_amount, max_amount, COALESCE(date_window, ''), sort"
+
+func scanView(s scanner, v *View) error {
+	return s.Scan(&v.ID, &v.Name, pq.Array(&v.Tags), &v.Category, &v.MinAmount, &v.MaxAmount, &v.Window, &v.Sort)
+}
+
+func (v *View) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string, params i18n.Params) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "view."+field+"."+rule, params))
+	}
+
+	if v.Name == "" {
+		add("/name", "name", "required", nil)
+	}
+	for i, tag := range v.Tags {
+		if !validator.tagPattern.MatchString(tag) {
+			fields = append(fields, problem.NewFieldError("body", fmt.Sprintf("/tags/%d", i), "pattern", "expense.tags.pattern", i18n.Params{"tag": tag}))
+		}
+	}
+	if v.MinAmount != nil && v.MaxAmount != nil && *v.MaxAmount < *v.MinAmount {
+		add("/max_amount", "max_amount", "belowMin", nil)
+	}
+	if v.Window != "" && !windowPattern.MatchString(v.Window) {
+		add("/window", "window", "format", nil)
+	}
+	if _, ok := orderBy[v.Sort]; !ok {
+		add("/sort", "sort", "enum", nil)
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidView.WithFields(fields...)
+	}
+	return nil
+}
+
+// Filter returns the filter the view stands for when run on the given day,
+// so anything that lists expenses can be scoped by a view.
+func (v *View) Filter(day time.Time) Filter {
+	f := Filter{Tags: v.Tags, Category: v.Category, MinAmount: v.MinAmount, MaxAmount: v.MaxAmount}
+	if m := windowPattern.FindStringSubmatch(v.Window); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		from := day
+		switch m[2] {
+		case "d":
+			from = day.AddDate(0, 0, -n)
+		case "w":
+			from = day.AddDate(0, 0, -7*n)
+		case "m":
+			from = monthsBefore(day, n)
+		case "y":
+			from = monthsBefore(day, 12*n)
+		}
+		f.From = from.AddDate(0, 0, 1).Format(dateLayout)
+		f.To = day.Format(dateLayout)
+	}
+	return f
+}
+
+// monthsBefore goes back n calendar months, landing on the last day of the
+// month when it is shorter, so a month before March 31 is February 28.
+func monthsBefore(day time.Time, n int) time.Time {
+	first := time.Date(day.Year(), day.Month()-time.Month(n), 1, 0, 0, 0, 0, day.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if d := day.Day(); d < last {
+		last = d
+	}
+	return first.AddDate(0, 0, last-1)
+}
+
+func bindView(c echo.Context) (View, error) {
+	v := View{}
+	if err := c.Bind(&v); err != nil {
+		c.Logger().Error("invalid request binding to struct view error: ", err)
+		return v, ErrInvalidRequest.Wrap(err)
+	}
+	v.Name = strings.TrimSpace(v.Name)
+	v.Tags = NormalizeTags(v.Tags)
+	if v.Sort == "" {
+		v.Sort = defaultViewSort
+	}
+	return v, v.Validate()
+}
+
+func CreateViewHandler(c echo.Context) error {
+	v, err := bindView(c)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err = db.QueryRowContext(ctx, "INSERT INTO views (name, tags, category, min_amount, max_amount, date_window, sort) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		v.Name, pq.Array(v.Tags), nullIfEmpty(v.Category), v.MinAmount, v.MaxAmount, nullIfEmpty(v.Window), v.Sort).Scan(&v.ID)
+	if err != nil {
+		c.Logger().Error("insert view error: ", err)
+		return ErrViewUpdate.Wrap(err)
+	}
+
+	return c.JSON(http.StatusCreated, v)
+}
+
+func GetViewsHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT "+viewColumns+" FROM views ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrViewQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	vs := []View{}
+	for rows.Next() {
+		v := View{}
+		if err = scanView(rows, &v); err != nil {
+			c.Logger().Error("scan view error: ", err)
+			return ErrViewQuery.Wrap(err)
+		}
+		vs = append(vs, v)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate views error: ", err)
+		return ErrViewQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, vs)
+}
+
+func getView(c echo.Context) (View, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return View{}, ErrInvalidRequest.Wrap(err)
+	}
+	return loadView(c, id)
+}
+
+func loadView(c echo.Context, id int) (View, error) {
+	v := View{}
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err := scanView(db.QueryRowContext(ctx, "SELECT "+viewColumns+" FROM views WHERE id = $1", id), &v)
+	if err == sql.ErrNoRows {
+		return v, ErrViewNotFound.WithDetailKey("detail.view.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("scan view error: ", err)
+		return v, ErrViewQuery.Wrap(err)
+	}
+	return v, nil
+}
+
+func GetViewHandler(c echo.Context) error {
+	v, err := getView(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+func UpdateViewHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	v, err := bindView(c)
+	if err != nil {
+		return err
+	}
+	v.ID = id
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "UPDATE views SET name = $2, tags = $3, category = $4, min_amount = $5, max_amount = $6, date_window = $7, sort = $8 WHERE id = $1",
+		id, v.Name, pq.Array(v.Tags), nullIfEmpty(v.Category), v.MinAmount, v.MaxAmount, nullIfEmpty(v.Window), v.Sort)
+	if err != nil {
+		c.Logger().Error("update view error: ", err)
+		return ErrViewUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrViewUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrViewNotFound.WithDetailKey("detail.view.notFound", i18n.Params{"id": id})
+	}
+
+	return c.JSON(http.StatusOK, v)
+}
+
+func DeleteViewHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM views WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete view error: ", err)
+		return ErrViewUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrViewUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrViewNotFound.WithDetailKey("detail.view.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetViewExpensesHandler lists the expenses a view selects today, in the
+// order of the view.
+func GetViewExpensesHandler(c echo.Context) error {
+	v, err := getView(c)
+	if err != nil {
+		return err
+	}
+	return listExpenses(c, v.Filter(today()), v.Sort)
+}
@@ -0,0 +1,145 @@
+//go:build unit
+
+package expense
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+var viewRowColumns = []string{"id", "name", "tags", "category", "min_amount", "max_amount", "window", "sort"}
+
+func TestViewValidate(t *testing.T) {
+	min, max := 100.0, 50.0
+	v := View{Tags: []string{"#bad"}, MinAmount: &min, MaxAmount: &max, Window: "30days", Sort: "title"}
+
+	err := v.Validate()
+
+	var pe *problem.Error
+	if assert.ErrorAs(t, err, &pe) {
+		var got []string
+		for _, f := range pe.Fields {
+			got = append(got, f.Pointer+" "+f.Rule)
+		}
+		assert.Equal(t, []string{"/name required", "/tags/0 pattern", "/max_amount belowMin", "/window format", "/sort enum"}, got)
+	}
+}
+
+func TestViewFilter(t *testing.T) {
+	day := time.Date(2023, 3, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		window string
+		from   string
+	}{
+		{window: "1d", from: "2023-03-31"},
+		{window: "30d", from: "2023-03-02"},
+		{window: "2w", from: "2023-03-18"},
+		{window: "1m", from: "2023-03-01"},
+		{window: "13m", from: "2022-03-01"},
+		{window: "1y", from: "2022-04-01"},
+	}
+
+	for _, test := range tests {
+		t.Run("Test case for a window of "+test.window, func(t *testing.T) {
+			v := View{Tags: []string{"coffee"}, Window: test.window}
+
+			f := v.Filter(day)
+
+			assert.Equal(t, Filter{Tags: []string{"coffee"}, From: test.from, To: "2023-03-31"}, f)
+		})
+	}
+
+	t.Run("Test case for a view without a window", func(t *testing.T) {
+		v := View{Category: "food"}
+
+		assert.Equal(t, Filter{Category: "food"}, v.Filter(day))
+	})
+}
+
+func TestCreateViewHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/views", strings.NewReader(`{"name":" coffee ","tags":["Coffee"],"min_amount":50,"window":"30d"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO views (name, tags, category, min_amount, max_amount, date_window, sort)")).
+		WithArgs("coffee", pq.Array([]string{"coffee"}), nil, 50.0, nil, "30d", "-date").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	err = CreateViewHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"name":"coffee","tags":["coffee"],"min_amount":50,"window":"30d","sort":"-date"}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetViewExpensesHandler(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2023, 3, 31, 9, 0, 0, 0, time.Local) }
+
+	t.Run("Test case for running a view through the expense filter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/views/1/expenses", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + viewColumns + " FROM views WHERE id = $1")).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(viewRowColumns).AddRow(1, "coffee", pq.Array([]string{"coffee"}), "", 50.0, 200.0, "30d", "-amount"))
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT "+expenseColumns+" FROM expenses WHERE tags @> $1 AND amount >= $2 AND amount <= $3 AND spent_on >= $4::date AND spent_on <= $5::date AND kind = $6 ORDER BY amount DESC, id DESC")).
+			WithArgs(pq.Array([]string{"coffee"}), 50.0, 200.0, "2023-03-02", "2023-03-31", KindExpense).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags", "category_id", "date", "split", "merchant_id", "account_id"}).
+				AddRow(2, "latte", 120.0, "big", pq.Array([]string{"coffee"}), nil, "2023-03-30", nil, nil, nil).
+				AddRow(1, "espresso", 60.0, "small", pq.Array([]string{"coffee"}), nil, "2023-03-10", nil, nil, nil))
+
+		err = GetViewExpensesHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, `[{"id":2,"title":"latte","amount":120,"note":"big","tags":["coffee"],"date":"2023-03-30"},{"id":1,"title":"espresso","amount":60,"note":"small","tags":["coffee"],"date":"2023-03-10"}]`, strings.TrimSpace(rec.Body.String()))
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a missing view", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/views/9/expenses", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetParamNames("id")
+		c.SetParamValues("9")
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT " + viewColumns + " FROM views WHERE id = $1")).WithArgs(9).WillReturnRows(sqlmock.NewRows(viewRowColumns))
+
+		err = GetViewExpensesHandler(c)
+
+		assert.ErrorIs(t, err, ErrViewNotFound)
+	})
+}
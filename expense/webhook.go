@@ -0,0 +1,392 @@
+package expense
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+const (
+	EventExpenseCreated = "expense.created"
+	EventExpenseUpdated = "expense.updated"
+	EventExpenseDeleted = "expense.deleted"
+
+	// Income and transfers have events of their own, so expense subscribers
+	// keep seeing only expenses.
+	EventTransactionCreated = "transaction.created"
+	EventTransactionUpdated = "transaction.updated"
+	EventTransactionDeleted = "transaction.deleted"
+)
+
+const (
+	HeaderWebhookEvent     = "X-Webhook-Event"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+var webhookEvents = map[string]bool{
+	EventExpenseCreated: true, EventExpenseUpdated: true, EventExpenseDeleted: true,
+	EventTransactionCreated: true, EventTransactionUpdated: true, EventTransactionDeleted: true,
+}
+
+// Webhook subscribes a URL to expense events. The secret signs every payload
+// and is never returned.
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Event is the payload posted to webhooks. Receivers can use the ID to
+// ignore an event delivered twice, e.g. after a replay.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// DeadLetter is an event that could not be delivered to a webhook within the
+// configured attempts.
+type DeadLetter struct {
+	ID        int             `json:"id"`
+	WebhookID int             `json:"webhook_id"`
+	Event     string          `json:"event"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+var webhookDelivery = config.Default().Webhooks
+
+func SetWebhookDelivery(cfg config.Webhooks) {
+	webhookDelivery = cfg
+}
+
+func (w *Webhook) Validate() error {
+	var fields []problem.FieldError
+	add := func(pointer, field, rule string) {
+		fields = append(fields, problem.NewFieldError("body", pointer, rule, "webhook."+field+"."+rule, nil))
+	}
+
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("/url", "url", "format")
+	}
+	if w.Secret == "" {
+		add("/secret", "secret", "required")
+	}
+	if len(w.Events) == 0 {
+		add("/events", "events", "required")
+	}
+	for i, event := range w.Events {
+		if !webhookEvents[event] {
+			add(fmt.Sprintf("/events/%d", i), "events", "enum")
+		}
+	}
+
+	if len(fields) > 0 {
+		return ErrInvalidWebhook.WithFields(fields...)
+	}
+	return nil
+}
+
+// sign returns the value of the signature header, the hex HMAC-SHA256 of the
+// body keyed by the webhook secret.
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return &statusError{status: res.StatusCode}
+	}
+	return nil
+}
+
+// statusError is a webhook answering with a status other than 2xx.
+type statusError struct {
+	status int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", e.status)
+}
+
+func sendWebhook(w Webhook, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDelivery.Timeout)
+	defer cancel()
+
+	header := http.Header{}
+	header.Set(HeaderWebhookEvent, event)
+	header.Set(HeaderWebhookSignature, sign(w.Secret, body))
+	return postJSON(ctx, http.DefaultClient, w.URL, body, header)
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// webhookSink delivers outbox messages to every webhook subscribed to them,
+// with one attempt per dispatch. A failed delivery fails the message, so the
+// outbox retries it with backoff; the webhooks that already have it are
+// skipped. Once the webhooks have been tried the configured number of times,
+// those still failing get a dead letter instead, so one failing webhook never
+// holds a message back from the others for good. Failures of other sinks do
+// not count towards these attempts.
+type webhookSink struct{}
+
+// webhookRecipientsSQL selects the webhooks subscribed to event type $1 that
+// have not had outbox message $2 yet.
+const webhookRecipientsSQL = `SELECT id, url, secret FROM webhooks w WHERE $1 = ANY(events)
+	AND NOT EXISTS (SELECT 1 FROM webhook_deliveries d WHERE d.outbox_id = $2 AND d.webhook_id = w.id)`
+
+func (webhookSink) Publish(ctx context.Context, m OutboxMessage) error {
+	rows, err := db.QueryContext(ctx, webhookRecipientsSQL, m.Type, m.ID)
+	if err != nil {
+		return err
+	}
+	var ws []Webhook
+	for rows.Next() {
+		w := Webhook{}
+		if err = rows.Scan(&w.ID, &w.URL, &w.Secret); err != nil {
+			rows.Close()
+			return err
+		}
+		ws = append(ws, w)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	errs := make([]error, len(ws))
+	var wg sync.WaitGroup
+	for i, w := range ws {
+		wg.Add(1)
+		go func(i int, w Webhook) {
+			defer wg.Done()
+			errs[i] = sendWebhook(w, m.Type, m.Payload)
+		}(i, w)
+	}
+	wg.Wait()
+
+	attempts := m.WebhookAttempts + 1
+	var failed error
+	for i, w := range ws {
+		switch {
+		case errs[i] == nil:
+			_, err = db.ExecContext(ctx, "INSERT INTO webhook_deliveries (outbox_id, webhook_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", m.ID, w.ID)
+		case attempts >= webhookDelivery.MaxAttempts:
+			log.Printf("deliver %s to webhook %d failed after %d attempts: %v", m.Type, w.ID, attempts, errs[i])
+			_, err = db.ExecContext(ctx, "INSERT INTO webhook_dead_letters (webhook_id, event, payload, attempts, last_error) VALUES ($1, $2, $3, $4, $5)",
+				w.ID, m.Type, string(m.Payload), attempts, errs[i].Error())
+		default:
+			if failed == nil {
+				failed = fmt.Errorf("deliver to webhook %d: %w", w.ID, errs[i])
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if failed != nil {
+		if _, err = db.ExecContext(ctx, "UPDATE outbox SET webhook_attempts = webhook_attempts + 1 WHERE id = $1", m.ID); err != nil {
+			return err
+		}
+		return &retryError{err: failed, backoff: webhookDelivery.Backoff}
+	}
+	return nil
+}
+
+func (webhookSink) Close() error {
+	return nil
+}
+
+func CreateWebhookHandler(c echo.Context) error {
+	w := Webhook{}
+	if err := c.Bind(&w); err != nil {
+		c.Logger().Error("invalid request binding to struct webhook error: ", err)
+		return ErrInvalidRequest.Wrap(err)
+	}
+	w.URL = strings.TrimSpace(w.URL)
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	err := db.QueryRowContext(ctx, "INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at",
+		w.URL, w.Secret, pq.Array(w.Events)).Scan(&w.ID, &w.CreatedAt)
+	if err != nil {
+		c.Logger().Error("insert webhook error: ", err)
+		return ErrWebhookUpdate.Wrap(err)
+	}
+
+	w.Secret = ""
+	return c.JSON(http.StatusCreated, w)
+}
+
+func GetWebhooksHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, url, events, created_at FROM webhooks ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrWebhookQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	ws := []Webhook{}
+	for rows.Next() {
+		w := Webhook{}
+		if err = rows.Scan(&w.ID, &w.URL, pq.Array(&w.Events), &w.CreatedAt); err != nil {
+			c.Logger().Error("scan webhook error: ", err)
+			return ErrWebhookQuery.Wrap(err)
+		}
+		ws = append(ws, w)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate webhooks error: ", err)
+		return ErrWebhookQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, ws)
+}
+
+func DeleteWebhookHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "DELETE FROM webhooks WHERE id = $1", id)
+	if err != nil {
+		c.Logger().Error("delete webhook error: ", err)
+		return ErrWebhookUpdate.Wrap(err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return ErrWebhookUpdate.Wrap(err)
+	} else if n == 0 {
+		return ErrWebhookNotFound.WithDetailKey("detail.webhook.notFound", i18n.Params{"id": id})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func GetDeadLettersHandler(c echo.Context) error {
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT id, webhook_id, event, payload, attempts, last_error, created_at FROM webhook_dead_letters ORDER BY id")
+	if err != nil {
+		c.Logger().Error("query statment error: ", err)
+		return ErrWebhookQuery.Wrap(err)
+	}
+	defer rows.Close()
+
+	ds := []DeadLetter{}
+	for rows.Next() {
+		d := DeadLetter{}
+		if err = rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.LastError, &d.CreatedAt); err != nil {
+			c.Logger().Error("scan dead letter error: ", err)
+			return ErrWebhookQuery.Wrap(err)
+		}
+		ds = append(ds, d)
+	}
+	if err = rows.Err(); err != nil {
+		c.Logger().Error("iterate dead letters error: ", err)
+		return ErrWebhookQuery.Wrap(err)
+	}
+
+	return c.JSON(http.StatusOK, ds)
+}
+
+// ReplayDeadLetterHandler makes one more delivery attempt with the original
+// payload. The dead letter is removed once the webhook accepts it.
+func ReplayDeadLetterHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return ErrInvalidRequest.Wrap(err)
+	}
+
+	ctx, cancel := queryContext(c)
+	defer cancel()
+
+	w := Webhook{}
+	var event string
+	var payload []byte
+	err = db.QueryRowContext(ctx, `SELECT w.id, w.url, w.secret, d.event, d.payload FROM webhook_dead_letters d
+		JOIN webhooks w ON w.id = d.webhook_id WHERE d.id = $1`, id).Scan(&w.ID, &w.URL, &w.Secret, &event, &payload)
+	if err == sql.ErrNoRows {
+		return ErrDeadLetterNotFound.WithDetailKey("detail.deadLetter.notFound", i18n.Params{"id": id}).Wrap(err)
+	} else if err != nil {
+		c.Logger().Error("query dead letter error: ", err)
+		return ErrWebhookQuery.Wrap(err)
+	}
+
+	if err := sendWebhook(w, event, payload); err != nil {
+		if _, uerr := db.ExecContext(ctx, "UPDATE webhook_dead_letters SET attempts = attempts + 1, last_error = $2 WHERE id = $1", id, err.Error()); uerr != nil {
+			c.Logger().Error("update dead letter error: ", uerr)
+		}
+		var se *statusError
+		if errors.As(err, &se) {
+			return ErrWebhookDelivery.WithDetailKey("detail.webhook.status", i18n.Params{"status": se.status}).Wrap(err)
+		}
+		return ErrWebhookDelivery.WithDetailKey("detail.webhook.unreachable", nil).Wrap(err)
+	}
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM webhook_dead_letters WHERE id = $1", id); err != nil {
+		c.Logger().Error("delete dead letter error: ", err)
+		return ErrWebhookUpdate.Wrap(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,213 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		w    Webhook
+		err  string
+	}{
+		{
+			name: "Test case for valid webhook",
+			w:    Webhook{URL: "https://example.com/hooks", Secret: "s3cret", Events: []string{EventExpenseCreated}},
+		},
+		{
+			name: "Test case for collecting every violation",
+			w:    Webhook{URL: "/hooks", Events: []string{EventExpenseCreated, "expense.archived"}},
+			err:  "url must be an absolute http or https url; secret is required; event must be one of expense.created, expense.updated, expense.deleted, transaction.created, transaction.updated or transaction.deleted",
+		},
+		{
+			name: "Test case for webhook without events",
+			w:    Webhook{URL: "http://example.com", Secret: "s3cret"},
+			err:  "at least one event is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.w.Validate()
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else if assert.ErrorIs(t, err, ErrInvalidWebhook) {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=6146142a2ce0159e84c0767881e4ec80bc397da62526e7d19f70795eb79460c0", sign("secret", []byte(`{"id":"1"}`)))
+}
+
+func TestWebhookSinkFailure(t *testing.T) {
+	defer func() { webhookDelivery = config.Default().Webhooks }()
+	webhookDelivery = config.Webhooks{Timeout: time.Second, MaxAttempts: 2, Backoff: time.Millisecond}
+	body := []byte(`{"id":"1"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, EventExpenseCreated, r.Header.Get(HeaderWebhookEvent))
+		assert.Equal(t, sign("secret", body), r.Header.Get(HeaderWebhookSignature))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	t.Run("Test case for leaving a failed delivery to the outbox", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(webhookRecipientsSQL)).WithArgs(EventExpenseCreated, int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow(1, srv.URL, "secret"))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE outbox SET webhook_attempts = webhook_attempts + 1 WHERE id = $1")).WithArgs(3).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// Failures of the other sinks leave the webhook its attempts.
+		err = webhookSink{}.Publish(context.Background(), OutboxMessage{ID: 3, Type: EventExpenseCreated, Payload: body, Attempts: 4})
+
+		var re *retryError
+		if assert.ErrorAs(t, err, &re) {
+			assert.Equal(t, time.Millisecond, re.backoff)
+			assert.EqualError(t, err, "deliver to webhook 1: webhook responded with status 500")
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for dead-lettering after the last attempt", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(webhookRecipientsSQL)).WithArgs(EventExpenseCreated, int64(3)).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret"}).AddRow(1, srv.URL, "secret"))
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO webhook_dead_letters")).
+			WithArgs(1, EventExpenseCreated, string(body), 2, "webhook responded with status 500").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		err = webhookSink{}.Publish(context.Background(), OutboxMessage{ID: 3, Type: EventExpenseCreated, Payload: body, Attempts: 1, WebhookAttempts: 1})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestCreateWebhookHandler(t *testing.T) {
+	body := `{"url":" https://example.com/hooks ","secret":"s3cret","events":["expense.created","expense.deleted"]}`
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer mockDB.Close()
+	db = mockDB
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO webhooks (url, secret, events) VALUES ($1, $2, $3) RETURNING id, created_at")).
+		WithArgs("https://example.com/hooks", "s3cret", pq.Array([]string{EventExpenseCreated, EventExpenseDeleted})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Date(2023, 1, 15, 9, 0, 0, 0, time.UTC)))
+
+	err = CreateWebhookHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":1,"url":"https://example.com/hooks","events":["expense.created","expense.deleted"],"created_at":"2023-01-15T09:00:00Z"}`, strings.TrimSpace(rec.Body.String()))
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayDeadLetterHandler(t *testing.T) {
+	replaySQL := "SELECT w.id, w.url, w.secret, d.event, d.payload FROM webhook_dead_letters d"
+	newContext := func() echo.Context {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/dead-letters/5/replay", nil)
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		c.SetPath("/webhooks/dead-letters/:id/replay")
+		c.SetParamNames("id")
+		c.SetParamValues("5")
+		return c
+	}
+
+	t.Run("Test case for a successful replay", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer srv.Close()
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(replaySQL)).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret", "event", "payload"}).AddRow(1, srv.URL, "secret", EventExpenseUpdated, []byte(`{"id":"1"}`)))
+		mock.ExpectExec(regexp.QuoteMeta("DELETE FROM webhook_dead_letters WHERE id = $1")).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = ReplayDeadLetterHandler(newContext())
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a webhook rejecting the replay", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer srv.Close()
+
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(replaySQL)).WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret", "event", "payload"}).AddRow(1, srv.URL, "secret", EventExpenseUpdated, []byte(`{"id":"1"}`)))
+		mock.ExpectExec(regexp.QuoteMeta("UPDATE webhook_dead_letters SET attempts = attempts + 1")).
+			WithArgs(5, "webhook responded with status 400").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err = ReplayDeadLetterHandler(newContext())
+
+		assert.ErrorIs(t, err, ErrWebhookDelivery)
+		var p *problem.Error
+		if assert.ErrorAs(t, err, &p) {
+			assert.Equal(t, "the webhook responded with status 400", p.Detail)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Test case for a missing dead letter", func(t *testing.T) {
+		mockDB, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer mockDB.Close()
+		db = mockDB
+		mock.ExpectQuery(regexp.QuoteMeta(replaySQL)).WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "secret", "event", "payload"}))
+
+		err = ReplayDeadLetterHandler(newContext())
+
+		assert.ErrorIs(t, err, ErrDeadLetterNotFound)
+	})
+}
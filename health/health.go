@@ -1,11 +1,132 @@
 package health
 
 import (
+	"context"
 	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
-func GetHealthHandler(c echo.Context) error {
-	return c.String(http.StatusOK, "healthy")
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Checker reports the state of a single component. A non-nil error marks the
+// component as down; details are included in the readiness response as-is.
+type Checker func(ctx context.Context) (map[string]interface{}, error)
+
+type Component struct {
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status     string               `json:"status"`
+	Components map[string]Component `json:"components,omitempty"`
+}
+
+var (
+	mu       sync.RWMutex
+	checkers = map[string]Checker{}
+
+	shuttingDown atomic.Bool
+
+	CheckTimeout = 2 * time.Second
+)
+
+func Register(name string, c Checker) {
+	mu.Lock()
+	defer mu.Unlock()
+	checkers[name] = c
+}
+
+func Unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(checkers, name)
+}
+
+func MarkShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+func GetLivenessHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, Report{Status: StatusUp})
+}
+
+func GetReadinessHandler(c echo.Context) error {
+	if shuttingDown.Load() {
+		return c.JSON(http.StatusServiceUnavailable, Report{
+			Status:     StatusDown,
+			Components: map[string]Component{"server": {Status: StatusDown, Error: "shutting down"}},
+		})
+	}
+
+	report := check(c.Request().Context())
+	if report.Status != StatusUp {
+		return c.JSON(http.StatusServiceUnavailable, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+func check(ctx context.Context) Report {
+	mu.RLock()
+	names := make([]string, 0, len(checkers))
+	for name := range checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fns := make([]Checker, len(names))
+	for i, name := range names {
+		fns[i] = checkers[name]
+	}
+	mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	results := make([]Component, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn Checker) {
+			defer wg.Done()
+			results[i] = run(ctx, fn)
+		}(i, fn)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusUp, Components: make(map[string]Component, len(names))}
+	for i, name := range names {
+		report.Components[name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	return report
+}
+
+func run(ctx context.Context, fn Checker) Component {
+	done := make(chan Component, 1)
+	go func() {
+		details, err := fn(ctx)
+		if err != nil {
+			done <- Component{Status: StatusDown, Error: err.Error(), Details: details}
+			return
+		}
+		done <- Component{Status: StatusUp, Details: details}
+	}()
+
+	select {
+	case comp := <-done:
+		return comp
+	case <-ctx.Done():
+		return Component{Status: StatusDown, Error: ctx.Err().Error()}
+	}
 }
@@ -18,12 +18,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestIntegrationGetHealthHandler(t *testing.T) {
+func TestIntegrationGetLivenessHandler(t *testing.T) {
 	// Setup server
 	eh := echo.New()
 	go func(e *echo.Echo) {
 
-		e.GET("/health", GetHealthHandler)
+		e.GET("/health/live", GetLivenessHandler)
 		e.Start(serverPort())
 	}(eh)
 	for {
@@ -38,7 +38,7 @@ func TestIntegrationGetHealthHandler(t *testing.T) {
 	}
 	// Arrange
 	reqBody := ``
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost%s/health", serverPort()), strings.NewReader(reqBody))
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost%s/health/live", serverPort()), strings.NewReader(reqBody))
 	assert.NoError(t, err)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	client := http.Client{}
@@ -54,7 +54,7 @@ func TestIntegrationGetHealthHandler(t *testing.T) {
 	// Assertions
 	if assert.NoError(t, err) {
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
-		assert.Equal(t, "healthy", string(byteBody))
+		assert.Equal(t, `{"status":"up"}`, strings.TrimSpace(string(byteBody)))
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -3,26 +3,110 @@
 package health
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetHealthHandler(t *testing.T) {
-	req, err := http.NewRequest(http.MethodGet, "/health", nil)
+func TestGetLivenessHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/health/live", nil)
 	assert.NoError(t, err)
 
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	rec := httptest.NewRecorder()
 	c := echo.New().NewContext(req, rec)
 
-	err = GetHealthHandler(c)
+	err = GetLivenessHandler(c)
 
 	if assert.NoError(t, err) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		assert.Equal(t, "healthy", rec.Body.String())
+		assert.Equal(t, `{"status":"up"}`, strings.TrimSpace(rec.Body.String()))
 	}
 }
+
+func TestGetReadinessHandler(t *testing.T) {
+	newContext := func() (echo.Context, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(http.MethodGet, "/health/ready", nil)
+		rec := httptest.NewRecorder()
+		return echo.New().NewContext(req, rec), rec
+	}
+	reset := func() {
+		mu.Lock()
+		checkers = map[string]Checker{}
+		mu.Unlock()
+		shuttingDown.Store(false)
+	}
+
+	t.Run("Test case for all components up", func(t *testing.T) {
+		reset()
+		Register("database", func(ctx context.Context) (map[string]interface{}, error) {
+			return map[string]interface{}{"open_connections": 1}, nil
+		})
+		c, rec := newContext()
+
+		err := GetReadinessHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `{"status":"up","components":{"database":{"status":"up","details":{"open_connections":1}}}}`, strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Test case for a component down", func(t *testing.T) {
+		reset()
+		Register("database", func(ctx context.Context) (map[string]interface{}, error) {
+			return nil, nil
+		})
+		Register("migrations", func(ctx context.Context) (map[string]interface{}, error) {
+			return nil, errors.New("pending migrations")
+		})
+		c, rec := newContext()
+
+		err := GetReadinessHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, `{"status":"down","components":{"database":{"status":"up"},"migrations":{"status":"down","error":"pending migrations"}}}`, strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Test case for a component timing out", func(t *testing.T) {
+		reset()
+		timeout := CheckTimeout
+		CheckTimeout = 10 * time.Millisecond
+		defer func() { CheckTimeout = timeout }()
+		Register("database", func(ctx context.Context) (map[string]interface{}, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		})
+		c, rec := newContext()
+
+		err := GetReadinessHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, `{"status":"down","components":{"database":{"status":"down","error":"context deadline exceeded"}}}`, strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Test case for shutting down", func(t *testing.T) {
+		reset()
+		MarkShuttingDown()
+		defer reset()
+		c, rec := newContext()
+
+		err := GetReadinessHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, `{"status":"down","components":{"server":{"status":"down","error":"shutting down"}}}`, strings.TrimSpace(rec.Body.String()))
+		}
+	})
+}
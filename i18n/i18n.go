@@ -0,0 +1,107 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const Default = "en"
+
+//go:embed locales/*.json
+var locales embed.FS
+
+type Params map[string]interface{}
+
+var catalogues = map[string]map[string]string{}
+
+func init() {
+	if err := load(); err != nil {
+		panic(err)
+	}
+}
+
+func load() error {
+	entries, err := locales.ReadDir("locales")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		b, err := locales.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			return err
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return fmt.Errorf("parse locale %s: %w", entry.Name(), err)
+		}
+		catalogues[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+	if _, ok := catalogues[Default]; !ok {
+		return fmt.Errorf("default locale %s is missing", Default)
+	}
+	return nil
+}
+
+func Languages() []string {
+	langs := make([]string, 0, len(catalogues))
+	for lang := range catalogues {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Negotiate picks the best supported language from an Accept-Language header,
+// honouring quality values and falling back to the default language.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		lang := strings.SplitN(tag, "-", 2)[0]
+		if _, ok := catalogues[lang]; ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// T renders the message for key in lang, falling back to the default language
+// and finally to the key itself. Placeholders are written as {name}.
+func T(lang, key string, params Params) string {
+	msg, ok := catalogues[lang][key]
+	if !ok {
+		msg, ok = catalogues[Default][key]
+	}
+	if !ok {
+		return key
+	}
+	for name, v := range params {
+		msg = strings.ReplaceAll(msg, "{"+name+"}", fmt.Sprint(v))
+	}
+	return msg
+}
+
+func Has(key string) bool {
+	_, ok := catalogues[Default][key]
+	return ok
+}
@@ -0,0 +1,50 @@
+//go:build unit
+
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		header string
+		lang   string
+	}{
+		{header: "", lang: "en"},
+		{header: "th", lang: "th"},
+		{header: "th-TH,th;q=0.9,en;q=0.8", lang: "th"},
+		{header: "en-US,en;q=0.9,th;q=0.8", lang: "en"},
+		{header: "fr-FR,th;q=0.5", lang: "th"},
+		{header: "fr-FR,de;q=0.5", lang: "en"},
+		{header: "en;q=0.2,th;q=0.7", lang: "th"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			assert.Equal(t, test.lang, Negotiate(test.header))
+		})
+	}
+}
+
+func TestT(t *testing.T) {
+	assert.Equal(t, "title must be at most 200 characters", T("en", "expense.title.maxLength", Params{"max": 200}))
+	assert.Equal(t, "เรื่องต้องมีความยาวไม่เกิน 200 ตัวอักษร", T("th", "expense.title.maxLength", Params{"max": 200}))
+	assert.Equal(t, "invalid request", T("fr", "problem.invalid_request", nil))
+	assert.Equal(t, "unknown.key", T("th", "unknown.key", nil))
+}
+
+func TestCataloguesHaveTheSameKeys(t *testing.T) {
+	for _, lang := range Languages() {
+		for key := range catalogues[Default] {
+			_, ok := catalogues[lang][key]
+			assert.True(t, ok, "locale %s is missing %s", lang, key)
+		}
+		for key := range catalogues[lang] {
+			_, ok := catalogues[Default][key]
+			assert.True(t, ok, "locale %s has unknown key %s", lang, key)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package openapi
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+)
+
+type Options struct {
+	// ValidateResponses checks every response body against the spec and
+	// replaces non-conforming responses with a 500. Meant for tests.
+	ValidateResponses bool
+}
+
+var (
+	ErrRequestValidation  = problem.New(http.StatusBadRequest, "request_validation_failed")
+	ErrResponseValidation = problem.New(http.StatusInternalServerError, "response_validation_failed")
+)
+
+func Validator(opts Options) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			item, op := spec.Operation(c.Request().Method, c.Path())
+			if op == nil {
+				return next(c)
+			}
+
+			violations, err := spec.validateRequest(c, item, op)
+			if err != nil {
+				return err
+			}
+			if len(violations) > 0 {
+				return ErrRequestValidation.WithFields(violations...)
+			}
+
+			if !opts.ValidateResponses || spec.streams(op) {
+				return next(c)
+			}
+			return validateResponse(c, op, next)
+		}
+	}
+}
+
+func (d *Document) validateRequest(c echo.Context, item *PathItem, op *Operation) ([]problem.FieldError, error) {
+	var violations []problem.FieldError
+
+	params := append(append([]*Parameter{}, item.Parameters...), op.Parameters...)
+	for _, p := range params {
+		p = d.parameter(p)
+		if p == nil {
+			continue
+		}
+
+		var raw string
+		var ok bool
+		switch p.In {
+		case "path":
+			raw = c.Param(p.Name)
+			ok = raw != ""
+		case "query":
+			ok = c.QueryParams().Has(p.Name)
+			raw = c.QueryParam(p.Name)
+		case "header":
+			raw = c.Request().Header.Get(p.Name)
+			ok = raw != ""
+		default:
+			continue
+		}
+
+		v := &validator{doc: d, in: p.In}
+		if !ok {
+			if p.Required {
+				v.add("/"+escape(p.Name), "required", nil)
+			}
+		} else {
+			v.validate(p.Schema, d.parseParam(d.schema(p.Schema), raw), "/"+escape(p.Name))
+		}
+		violations = append(violations, v.violations...)
+	}
+
+	if op.RequestBody == nil {
+		return violations, nil
+	}
+	// Other media types, such as uploads, are left to the handler and are
+	// not buffered here.
+	media, ok := op.RequestBody.Content[echo.MIMEApplicationJSON]
+	if !ok {
+		return violations, nil
+	}
+
+	req := c.Request()
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(b))
+
+	v := &validator{doc: d, in: "body"}
+	if len(bytes.TrimSpace(b)) == 0 {
+		if op.RequestBody.Required {
+			v.addKey("", "required", "schema.bodyRequired", nil)
+		}
+		return append(violations, v.violations...), nil
+	}
+
+	if media.Schema == nil {
+		return violations, nil
+	}
+	value, err := decodeJSON(b)
+	if err != nil {
+		v.add("", "json", nil)
+		return append(violations, v.violations...), nil
+	}
+	v.validate(media.Schema, value, "")
+	return append(violations, v.violations...), nil
+}
+
+func (d *Document) parseParam(s *Schema, raw string) interface{} {
+	if s == nil {
+		return raw
+	}
+	switch s.Type {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(raw, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(raw); err == nil {
+			return b
+		}
+	case "array":
+		items := []interface{}{}
+		for _, item := range strings.Split(raw, ",") {
+			items = append(items, d.parseParam(d.schema(s.Items), item))
+		}
+		return items
+	}
+	return raw
+}
+
+func validateResponse(c echo.Context, op *Operation, next echo.HandlerFunc) error {
+	res := c.Response()
+	buf := &bufferedWriter{ResponseWriter: res.Writer, status: http.StatusOK}
+	res.Writer = buf
+	err := next(c)
+	res.Writer = buf.ResponseWriter
+	if err != nil {
+		return err
+	}
+
+	violations := spec.validateResponseBody(op, buf.status, res.Header().Get(echo.HeaderContentType), buf.body.Bytes())
+	if len(violations) > 0 {
+		// discard the buffered response so the error handler can write the problem
+		res.Committed = false
+		res.Size = 0
+		res.Header().Del(echo.HeaderContentLength)
+		return ErrResponseValidation.WithFields(violations...)
+	}
+
+	res.Writer.WriteHeader(buf.status)
+	_, err = res.Writer.Write(buf.body.Bytes())
+	return err
+}
+
+func (d *Document) validateResponseBody(op *Operation, status int, contentType string, body []byte) []problem.FieldError {
+	v := &validator{doc: d, in: "response"}
+
+	r, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		r, ok = op.Responses["default"]
+	}
+	if !ok {
+		v.add("", "status", i18n.Params{"status": status})
+		return v.violations
+	}
+
+	r = d.response(r)
+	if len(r.Content) == 0 {
+		return nil
+	}
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	media, ok := mediaRange(r.Content, mediaType)
+	if !ok {
+		v.add("", "contentType", i18n.Params{"type": mediaType})
+		return v.violations
+	}
+	if media.Schema == nil || !strings.HasSuffix(mediaType, "json") {
+		return nil
+	}
+
+	value, err := decodeJSON(body)
+	if err != nil {
+		v.add("", "json", nil)
+		return v.violations
+	}
+	v.validate(media.Schema, value, "")
+	return v.violations
+}
+
+// mediaRange finds the content for a media type, falling back to a range
+// such as image/* or */*.
+func mediaRange(content map[string]*MediaType, mediaType string) (*MediaType, bool) {
+	if media, ok := content[mediaType]; ok {
+		return media, true
+	}
+	if i := strings.Index(mediaType, "/"); i > 0 {
+		if media, ok := content[mediaType[:i]+"/*"]; ok {
+			return media, true
+		}
+	}
+	media, ok := content["*/*"]
+	return media, ok
+}
+
+// streams reports whether the operation responds with an event stream, which
+// cannot be buffered for validation.
+func (d *Document) streams(op *Operation) bool {
+	for _, r := range op.Responses {
+		if _, ok := d.response(r).Content["text/event-stream"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) Flush() {}
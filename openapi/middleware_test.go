@@ -0,0 +1,180 @@
+//go:build unit
+
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(opts Options, handler echo.HandlerFunc) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	g := e.Group("/expenses")
+	g.Use(Validator(opts))
+	g.POST("", handler)
+	g.PUT("/:id", handler)
+	g.GET("/:id", handler)
+	g.GET("/stream", handler)
+	return e
+}
+
+func TestValidator(t *testing.T) {
+	ok := func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, map[string]interface{}{"id": 1, "title": "title", "amount": 100, "note": "note", "tags": []string{"tag1"}})
+	}
+
+	t.Run("Test case for valid request body", func(t *testing.T) {
+		body := `{"title":"title","amount":100,"note":"note","tags":["tag1"]}`
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Test case for accepting a numeric string id", func(t *testing.T) {
+		body := `{"id":"1","title":"title","amount":100,"note":"note","tags":["tag1"]}`
+		req := httptest.NewRequest(http.MethodPut, "/expenses/1", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+
+	t.Run("Test case for listing every body violation", func(t *testing.T) {
+		body := `{"title":1,"amount":0,"tags":[],"colour":"red"}`
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, problem.MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `{"type":"about:blank","title":"request does not match the api schema","status":400,`+
+			`"code":"request_validation_failed","errors":[`+
+			`{"in":"body","pointer":"/note","rule":"required","message":"is required"},`+
+			`{"in":"body","pointer":"/amount","rule":"exclusiveMinimum","message":"must be greater than 0"},`+
+			`{"in":"body","pointer":"/colour","rule":"additionalProperties","message":"is not a known field"},`+
+			`{"in":"body","pointer":"/tags","rule":"minItems","message":"must contain at least 1 items"},`+
+			`{"in":"body","pointer":"/title","rule":"type","message":"must be of type string"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for malformed JSON body", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(`invalid request`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"request does not match the api schema","status":400,`+
+			`"code":"request_validation_failed","errors":[{"in":"body","pointer":"","rule":"json","message":"must be valid JSON"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for a date that does not exist", func(t *testing.T) {
+		body := `{"title":"title","amount":100,"note":"note","tags":["tag1"],"date":"2024-13-45"}`
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"request does not match the api schema","status":400,`+
+			`"code":"request_validation_failed","errors":[{"in":"body","pointer":"/date","rule":"format","message":"must be a valid date"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for invalid header parameter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/stream", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"request does not match the api schema","status":400,`+
+			`"code":"request_validation_failed","errors":[{"in":"header","pointer":"/Last-Event-ID","rule":"type","message":"must be of type integer"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for invalid path parameter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/abc", nil)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{}, ok).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"request does not match the api schema","status":400,`+
+			`"code":"request_validation_failed","errors":[{"in":"path","pointer":"/id","rule":"type","message":"must be of type integer"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for response not matching the spec", func(t *testing.T) {
+		invalid := func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{"id": 1, "title": "title"})
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{ValidateResponses: true}, invalid).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, `{"type":"about:blank","title":"response does not match the api schema","status":500,`+
+			`"code":"response_validation_failed","errors":[`+
+			`{"in":"response","pointer":"/amount","rule":"required","message":"is required"},`+
+			`{"in":"response","pointer":"/note","rule":"required","message":"is required"},`+
+			`{"in":"response","pointer":"/tags","rule":"required","message":"is required"}]}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for response matching the spec", func(t *testing.T) {
+		valid := func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{"id": 1, "title": "title", "amount": 100, "note": "note", "tags": []string{"tag1"}})
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{ValidateResponses: true}, valid).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"amount":100,"id":1,"note":"note","tags":["tag1"],"title":"title"}`, strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Test case for response omitting a required readOnly property", func(t *testing.T) {
+		invalid := func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{"title": "title", "amount": 100, "note": "note", "tags": []string{"tag1"}})
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses/1", nil)
+		rec := httptest.NewRecorder()
+
+		newTestServer(Options{ValidateResponses: true}, invalid).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Contains(t, rec.Body.String(), `{"in":"response","pointer":"/id","rule":"required","message":"is required"}`)
+	})
+
+	t.Run("Test case for response omitting a required writeOnly property", func(t *testing.T) {
+		e := echo.New()
+		e.HTTPErrorHandler = problem.HTTPErrorHandler
+		e.Use(Validator(Options{ValidateResponses: true}))
+		e.POST("/webhooks", func(c echo.Context) error {
+			return c.JSON(http.StatusCreated, map[string]interface{}{"id": 1, "url": "https://example.com", "events": []string{"expense.created"}, "created_at": "2023-01-20T09:00:00Z"})
+		})
+		req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(`{"url":"https://example.com","secret":"s","events":["expense.created"]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	})
+}
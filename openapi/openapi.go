@@ -0,0 +1,196 @@
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed openapi.yaml
+var specYAML []byte
+
+type Document struct {
+	OpenAPI    string               `yaml:"openapi"`
+	Paths      map[string]*PathItem `yaml:"paths"`
+	Components Components           `yaml:"components"`
+}
+
+type Components struct {
+	Schemas    map[string]*Schema    `yaml:"schemas"`
+	Parameters map[string]*Parameter `yaml:"parameters"`
+	Responses  map[string]*Response  `yaml:"responses"`
+}
+
+type PathItem struct {
+	Parameters []*Parameter `yaml:"parameters"`
+	Get        *Operation   `yaml:"get"`
+	Put        *Operation   `yaml:"put"`
+	Post       *Operation   `yaml:"post"`
+	Delete     *Operation   `yaml:"delete"`
+	Patch      *Operation   `yaml:"patch"`
+}
+
+type Operation struct {
+	OperationID string               `yaml:"operationId"`
+	Parameters  []*Parameter         `yaml:"parameters"`
+	RequestBody *RequestBody         `yaml:"requestBody"`
+	Responses   map[string]*Response `yaml:"responses"`
+}
+
+type Parameter struct {
+	Ref      string  `yaml:"$ref"`
+	Name     string  `yaml:"name"`
+	In       string  `yaml:"in"`
+	Required bool    `yaml:"required"`
+	Schema   *Schema `yaml:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `yaml:"required"`
+	Content  map[string]*MediaType `yaml:"content"`
+}
+
+type Response struct {
+	Ref     string                `yaml:"$ref"`
+	Content map[string]*MediaType `yaml:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `yaml:"schema"`
+}
+
+var (
+	spec     Document
+	specJSON []byte
+)
+
+func init() {
+	if err := load(specYAML); err != nil {
+		panic(err)
+	}
+}
+
+func load(b []byte) error {
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("parse openapi spec: %w", err)
+	}
+	j, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("convert openapi spec to json: %w", err)
+	}
+
+	var doc Document
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("parse openapi spec: %w", err)
+	}
+
+	spec, specJSON = doc, j
+	return nil
+}
+
+func Spec() *Document {
+	return &spec
+}
+
+func (p *PathItem) operations() map[string]*Operation {
+	ops := map[string]*Operation{}
+	for m, op := range map[string]*Operation{
+		http.MethodGet:    p.Get,
+		http.MethodPut:    p.Put,
+		http.MethodPost:   p.Post,
+		http.MethodDelete: p.Delete,
+		http.MethodPatch:  p.Patch,
+	} {
+		if op != nil {
+			ops[m] = op
+		}
+	}
+	return ops
+}
+
+// Operation looks up the operation documented for the method on an echo route
+// path such as /expenses/:id.
+func (d *Document) Operation(method, path string) (*PathItem, *Operation) {
+	item, ok := d.Paths[ToOpenAPIPath(path)]
+	if !ok {
+		return nil, nil
+	}
+	return item, item.operations()[method]
+}
+
+func (d *Document) HasOperation(method, path string) bool {
+	_, op := d.Operation(method, path)
+	return op != nil
+}
+
+func (d *Document) Operations() map[string][]string {
+	ops := map[string][]string{}
+	for path, item := range d.Paths {
+		for m := range item.operations() {
+			ops[path] = append(ops[path], m)
+		}
+	}
+	return ops
+}
+
+func (d *Document) parameter(p *Parameter) *Parameter {
+	if p.Ref == "" {
+		return p
+	}
+	return d.Components.Parameters[strings.TrimPrefix(p.Ref, "#/components/parameters/")]
+}
+
+func (d *Document) response(r *Response) *Response {
+	if r.Ref == "" {
+		return r
+	}
+	return d.Components.Responses[strings.TrimPrefix(r.Ref, "#/components/responses/")]
+}
+
+func (d *Document) schema(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+	}
+	return s
+}
+
+func ToOpenAPIPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func GetSpecHandler(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, specJSON)
+}
+
+func GetDocsHandler(c echo.Context) error {
+	return c.HTML(http.StatusOK, docsHTML)
+}
+
+const docsHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Expense tracking API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,36 @@
+//go:build unit
+
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSpecHandler(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	err := GetSpecHandler(c)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var doc map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		assert.Equal(t, "3.1.0", doc["openapi"])
+		assert.Contains(t, doc["paths"], "/expenses/{id}")
+	}
+}
+
+func TestHasOperation(t *testing.T) {
+	assert.Equal(t, "/expenses/{id}", ToOpenAPIPath("/expenses/:id"))
+	assert.True(t, Spec().HasOperation(http.MethodPut, "/expenses/:id"))
+	assert.False(t, Spec().HasOperation(http.MethodPatch, "/expenses/:id"))
+	assert.False(t, Spec().HasOperation(http.MethodGet, "/unknown"))
+}
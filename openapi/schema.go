@@ -0,0 +1,265 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/lnwsitgod/assessment/problem"
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the subset of JSON Schema used by openapi.yaml.
+type Schema struct {
+	Ref                  string             `yaml:"$ref"`
+	Type                 string             `yaml:"type"`
+	Format               string             `yaml:"format"`
+	Enum                 []interface{}      `yaml:"enum"`
+	Properties           map[string]*Schema `yaml:"properties"`
+	Required             []string           `yaml:"required"`
+	AdditionalProperties *Additional        `yaml:"additionalProperties"`
+	Items                *Schema            `yaml:"items"`
+	MinItems             *int               `yaml:"minItems"`
+	MaxItems             *int               `yaml:"maxItems"`
+	UniqueItems          bool               `yaml:"uniqueItems"`
+	MinLength            *int               `yaml:"minLength"`
+	MaxLength            *int               `yaml:"maxLength"`
+	Pattern              string             `yaml:"pattern"`
+	Minimum              *float64           `yaml:"minimum"`
+	Maximum              *float64           `yaml:"maximum"`
+	ExclusiveMinimum     *float64           `yaml:"exclusiveMinimum"`
+	ExclusiveMaximum     *float64           `yaml:"exclusiveMaximum"`
+	OneOf                []*Schema          `yaml:"oneOf"`
+	ReadOnly             bool               `yaml:"readOnly"`
+	WriteOnly            bool               `yaml:"writeOnly"`
+}
+
+// Additional holds additionalProperties, which is either a boolean or a schema.
+type Additional struct {
+	Allowed bool
+	Schema  *Schema
+}
+
+func (a *Additional) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&a.Allowed)
+	}
+	a.Allowed = true
+	return n.Decode(&a.Schema)
+}
+
+type validator struct {
+	doc        *Document
+	in         string
+	violations []problem.FieldError
+}
+
+func (v *validator) add(ptr, rule string, params i18n.Params) {
+	v.addKey(ptr, rule, "schema."+rule, params)
+}
+
+func (v *validator) addKey(ptr, rule, key string, params i18n.Params) {
+	v.violations = append(v.violations, problem.NewFieldError(v.in, ptr, rule, key, params))
+}
+
+func (v *validator) validate(s *Schema, value interface{}, ptr string) {
+	s = v.doc.schema(s)
+	if s == nil {
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		matched := 0
+		for _, alt := range s.OneOf {
+			sub := &validator{doc: v.doc, in: v.in}
+			sub.validate(alt, value, ptr)
+			if len(sub.violations) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.add(ptr, "oneOf", nil)
+		}
+		return
+	}
+
+	if s.Type != "" && !hasType(value, s.Type) {
+		v.add(ptr, "type", i18n.Params{"type": s.Type})
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		v.add(ptr, "enum", i18n.Params{"values": enumList(s.Enum)})
+	}
+
+	switch val := value.(type) {
+	case string:
+		v.validateString(s, val, ptr)
+	case float64:
+		v.validateNumber(s, val, ptr)
+	case []interface{}:
+		v.validateArray(s, val, ptr)
+	case map[string]interface{}:
+		v.validateObject(s, val, ptr)
+	}
+}
+
+func (v *validator) validateString(s *Schema, val string, ptr string) {
+	n := utf8.RuneCountInString(val)
+	if s.MinLength != nil && n < *s.MinLength {
+		v.add(ptr, "minLength", i18n.Params{"min": *s.MinLength})
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		v.add(ptr, "maxLength", i18n.Params{"max": *s.MaxLength})
+	}
+	if s.Pattern != "" {
+		if re, err := regexp.Compile(s.Pattern); err == nil && !re.MatchString(val) {
+			v.add(ptr, "pattern", i18n.Params{"pattern": s.Pattern})
+		}
+	}
+	if layout, ok := formats[s.Format]; ok {
+		if _, err := time.Parse(layout, val); err != nil {
+			v.add(ptr, "format", i18n.Params{"format": s.Format})
+		}
+	}
+}
+
+// formats are the string formats checked, by the layout that parses them.
+// Others, such as binary, are only documentation.
+var formats = map[string]string{
+	"date":      "2006-01-02",
+	"date-time": time.RFC3339,
+}
+
+func (v *validator) validateNumber(s *Schema, val float64, ptr string) {
+	if s.Minimum != nil && val < *s.Minimum {
+		v.add(ptr, "minimum", i18n.Params{"limit": *s.Minimum})
+	}
+	if s.Maximum != nil && val > *s.Maximum {
+		v.add(ptr, "maximum", i18n.Params{"limit": *s.Maximum})
+	}
+	if s.ExclusiveMinimum != nil && val <= *s.ExclusiveMinimum {
+		v.add(ptr, "exclusiveMinimum", i18n.Params{"limit": *s.ExclusiveMinimum})
+	}
+	if s.ExclusiveMaximum != nil && val >= *s.ExclusiveMaximum {
+		v.add(ptr, "exclusiveMaximum", i18n.Params{"limit": *s.ExclusiveMaximum})
+	}
+}
+
+func (v *validator) validateArray(s *Schema, val []interface{}, ptr string) {
+	if s.MinItems != nil && len(val) < *s.MinItems {
+		v.add(ptr, "minItems", i18n.Params{"min": *s.MinItems})
+	}
+	if s.MaxItems != nil && len(val) > *s.MaxItems {
+		v.add(ptr, "maxItems", i18n.Params{"max": *s.MaxItems})
+	}
+	if s.UniqueItems {
+		for i := range val {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(val[i], val[j]) {
+					v.add(fmt.Sprintf("%s/%d", ptr, i), "uniqueItems", i18n.Params{"index": j})
+				}
+			}
+		}
+	}
+	for i, item := range val {
+		v.validate(s.Items, item, fmt.Sprintf("%s/%d", ptr, i))
+	}
+}
+
+func (v *validator) validateObject(s *Schema, val map[string]interface{}, ptr string) {
+	for _, name := range s.Required {
+		// A writeOnly property is required in requests but never returned,
+		// and a readOnly one is returned but never needs sending.
+		if prop := v.doc.schema(s.Properties[name]); prop != nil && (prop.WriteOnly && v.in == "response" || prop.ReadOnly && v.in != "response") {
+			continue
+		}
+		if _, ok := val[name]; !ok {
+			v.add(ptr+"/"+escape(name), "required", nil)
+		}
+	}
+
+	keys := make([]string, 0, len(val))
+	for k := range val {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := ptr + "/" + escape(k)
+		if prop, ok := s.Properties[k]; ok {
+			v.validate(prop, val[k], p)
+			continue
+		}
+		if s.AdditionalProperties == nil {
+			continue
+		}
+		if !s.AdditionalProperties.Allowed {
+			v.add(p, "additionalProperties", nil)
+			continue
+		}
+		v.validate(s.AdditionalProperties.Schema, val[k], p)
+	}
+}
+
+func hasType(value interface{}, typ string) bool {
+	switch typ {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	s := make([]string, len(enum))
+	for i, e := range enum {
+		s[i] = fmt.Sprint(e)
+	}
+	return strings.Join(s, ", ")
+}
+
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func decodeJSON(b []byte) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,215 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+var (
+	ErrInvalidRequest = New(http.StatusBadRequest, "invalid_request")
+	ErrUnauthorized   = New(http.StatusUnauthorized, "unauthorized")
+	ErrInternal       = New(http.StatusInternalServerError, "internal_error")
+)
+
+// Error is a domain error that carries everything needed to render it as an
+// RFC 7807 problem. Errors with the same code match each other with errors.Is,
+// so handlers can return a copy decorated with detail or a cause.
+type Error struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+	Fields []FieldError
+	cause  error
+
+	detailKey    string
+	detailParams i18n.Params
+}
+
+type FieldError struct {
+	In      string      `json:"in"`
+	Pointer string      `json:"pointer"`
+	Rule    string      `json:"rule"`
+	Message string      `json:"message"`
+	Key     string      `json:"-"`
+	Params  i18n.Params `json:"-"`
+}
+
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// New creates a problem error whose title is looked up in the i18n catalogue
+// under "problem.<code>".
+func New(status int, code string) *Error {
+	return &Error{Status: status, Code: code, Title: i18n.T(i18n.Default, titleKey(code), nil)}
+}
+
+// NewFieldError creates a field error whose message is rendered from the i18n
+// catalogue, so it can be translated again when the problem is written.
+func NewFieldError(in, pointer, rule, key string, params i18n.Params) FieldError {
+	return FieldError{In: in, Pointer: pointer, Rule: rule, Message: i18n.T(i18n.Default, key, params), Key: key, Params: params}
+}
+
+func titleKey(code string) string {
+	return "problem." + code
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	if len(e.Fields) > 0 {
+		messages := make([]string, len(e.Fields))
+		for i, f := range e.Fields {
+			messages[i] = f.Message
+		}
+		return strings.Join(messages, "; ")
+	}
+	return e.Title
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func (e *Error) WithDetail(format string, args ...interface{}) *Error {
+	c := *e
+	c.Detail = fmt.Sprintf(format, args...)
+	c.detailKey, c.detailParams = "", nil
+	return &c
+}
+
+// WithDetailKey sets a detail rendered from the i18n catalogue, so it is
+// translated when the problem is written like the title and field errors.
+func (e *Error) WithDetailKey(key string, params i18n.Params) *Error {
+	c := *e
+	c.Detail = i18n.T(i18n.Default, key, params)
+	c.detailKey, c.detailParams = key, params
+	return &c
+}
+
+func (e *Error) WithFields(fields ...FieldError) *Error {
+	c := *e
+	c.Fields = append(append([]FieldError{}, e.Fields...), fields...)
+	return &c
+}
+
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	pe := From(err)
+	if pe.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	lang := i18n.Negotiate(c.Request().Header.Get("Accept-Language"))
+	p := Problem{
+		Type:     "about:blank",
+		Title:    pe.Title,
+		Status:   pe.Status,
+		Detail:   pe.Detail,
+		Instance: c.Response().Header().Get(echo.HeaderXRequestID),
+		Code:     pe.Code,
+	}
+	if i18n.Has(titleKey(pe.Code)) {
+		p.Title = i18n.T(lang, titleKey(pe.Code), nil)
+	}
+	if pe.detailKey != "" {
+		p.Detail = i18n.T(lang, pe.detailKey, pe.detailParams)
+	}
+	for _, f := range pe.Fields {
+		if f.Key != "" {
+			f.Message = i18n.T(lang, f.Key, f.Params)
+		}
+		p.Errors = append(p.Errors, f)
+	}
+	c.Response().Header().Set("Content-Language", lang)
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		var b []byte
+		b, err = json.Marshal(p)
+		if err == nil {
+			err = c.Blob(p.Status, MIMEApplicationProblemJSON, b)
+		}
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// From converts any error returned by a handler into a problem error. Errors
+// that are not problem errors become a 500 without leaking their message.
+func From(err error) *Error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		pe := New(he.Code, codeForStatus(he.Code)).Wrap(err)
+		if m, ok := he.Message.(string); ok && pe.Code == "http_error" {
+			pe.Title = strings.ToLower(m)
+		}
+		return pe
+	}
+
+	return ErrInternal.Wrap(err)
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal_error"
+	}
+	return "http_error"
+}
@@ -0,0 +1,106 @@
+//go:build unit
+
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/i18n"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	errNotFound := New(http.StatusNotFound, "expense_not_found")
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{
+			name:   "Test case for problem error with request id",
+			err:    fmt.Errorf("get expense: %w", errNotFound.WithDetail("expense 7 does not exist")),
+			status: http.StatusNotFound,
+			body:   `{"type":"about:blank","title":"expense not found","status":404,"detail":"expense 7 does not exist","instance":"req-1","code":"expense_not_found"}`,
+		},
+		{
+			name:   "Test case for field errors",
+			err:    ErrInvalidRequest.WithFields(FieldError{In: "body", Pointer: "/title", Rule: "required", Message: "is required"}),
+			status: http.StatusBadRequest,
+			body:   `{"type":"about:blank","title":"invalid request","status":400,"instance":"req-1","code":"invalid_request","errors":[{"in":"body","pointer":"/title","rule":"required","message":"is required"}]}`,
+		},
+		{
+			name:   "Test case for echo http error",
+			err:    echo.ErrMethodNotAllowed,
+			status: http.StatusMethodNotAllowed,
+			body:   `{"type":"about:blank","title":"method not allowed","status":405,"instance":"req-1","code":"method_not_allowed"}`,
+		},
+		{
+			name:   "Test case for unknown error not leaking its message",
+			err:    errors.New("pq: password authentication failed"),
+			status: http.StatusInternalServerError,
+			body:   `{"type":"about:blank","title":"internal server error","status":500,"instance":"req-1","code":"internal_error"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/expenses/7", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.Response().Header().Set(echo.HeaderXRequestID, "req-1")
+
+			HTTPErrorHandler(test.err, c)
+
+			assert.Equal(t, test.status, rec.Code)
+			assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+			assert.Equal(t, test.body, strings.TrimSpace(rec.Body.String()))
+		})
+	}
+}
+
+func TestHTTPErrorHandlerLocalization(t *testing.T) {
+	err := ErrInvalidRequest.WithFields(NewFieldError("body", "/title", "required", "expense.title.required", nil))
+	req := httptest.NewRequest(http.MethodPost, "/expenses", nil)
+	req.Header.Set("Accept-Language", "th-TH,th;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	HTTPErrorHandler(err, c)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "th", rec.Header().Get("Content-Language"))
+	assert.Equal(t, `{"type":"about:blank","title":"คำขอไม่ถูกต้อง","status":400,"code":"invalid_request","errors":[{"in":"body","pointer":"/title","rule":"required","message":"กรุณาระบุเรื่อง"}]}`, strings.TrimSpace(rec.Body.String()))
+	assert.EqualError(t, err, "title is required")
+}
+
+func TestHTTPErrorHandlerLocalizedDetail(t *testing.T) {
+	err := New(http.StatusNotFound, "expense_not_found").WithDetailKey("detail.expense.notFound", i18n.Params{"id": 7})
+	req := httptest.NewRequest(http.MethodGet, "/expenses/7", nil)
+	req.Header.Set("Accept-Language", "th")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	HTTPErrorHandler(err, c)
+
+	assert.Equal(t, `{"type":"about:blank","title":"ไม่พบรายการค่าใช้จ่าย","status":404,"detail":"ไม่พบรายการค่าใช้จ่าย 7","code":"expense_not_found"}`, strings.TrimSpace(rec.Body.String()))
+	assert.EqualError(t, err, "expense 7 does not exist")
+	assert.EqualError(t, err.WithDetail("gone"), "gone")
+}
+
+func TestErrorIs(t *testing.T) {
+	cause := errors.New("sql: no rows in result set")
+	err := ErrInvalidRequest.WithDetail("bad id").Wrap(cause)
+
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, ErrInternal)
+	assert.EqualError(t, err, "bad id")
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,46 +12,225 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/labstack/gommon/log"
+	glog "github.com/labstack/gommon/log"
+	"github.com/lnwsitgod/assessment/config"
 	"github.com/lnwsitgod/assessment/expense"
 	"github.com/lnwsitgod/assessment/health"
+	"github.com/lnwsitgod/assessment/openapi"
+	"github.com/lnwsitgod/assessment/problem"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	expense.InitDB()
+	if err := expense.SetValidationRules(cfg.Validation); err != nil {
+		log.Fatal(err)
+	}
+	if err := expense.InitDB(cfg.Database); err != nil {
+		log.Fatal(err)
+	}
 	defer expense.CloseDB()
+	expense.SetAlertWebhook(cfg.Alerts)
+	expense.SetWebhookDelivery(cfg.Webhooks)
+	if err := expense.SetAttachmentStore(cfg.Attachments); err != nil {
+		log.Fatal(err)
+	}
+	if err := expense.StartDispatcher(cfg.Outbox); err != nil {
+		log.Fatal(err)
+	}
+	if err := expense.StartStream(cfg.Database); err != nil {
+		log.Fatal(err)
+	}
+	expense.StartScheduler(cfg.Recurring)
 
+	e := newServer(cfg)
+	startServerGracefullyShutdown(e, cfg.Address(), cfg.Shutdown)
+}
+
+func newServer(cfg config.Config) *echo.Echo {
 	e := echo.New()
-	e.Logger.SetLevel(log.INFO)
+	e.Logger.SetLevel(glog.INFO)
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
-	e.GET("/health", health.GetHealthHandler)
+	e.GET("/health/live", health.GetLivenessHandler)
+	e.GET("/health/ready", health.GetReadinessHandler)
+	e.GET("/openapi.json", openapi.GetSpecHandler)
+	e.GET("/docs", openapi.GetDocsHandler)
 
 	g := e.Group("/expenses")
-	g.Use(authMiddlewareGuard)
+	g.Use(authMiddlewareGuard(cfg.AuthToken))
+	g.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
 	g.POST("", expense.CreateExpenseHandler)
 	g.GET("/:id", expense.GetExpenseHandler)
 	g.PUT("/:id", expense.UpdateExpenseHandler)
+	g.DELETE("/:id", expense.DeleteExpenseHandler)
+	g.POST("/:id/attachments", expense.CreateAttachmentHandler)
+	g.GET("/:id/attachments", expense.GetAttachmentsHandler)
+	g.GET("/:id/attachments/:attachment_id", expense.GetAttachmentHandler)
+	g.DELETE("/:id/attachments/:attachment_id", expense.DeleteAttachmentHandler)
 	g.GET("", expense.GetExpensesHandler)
+	g.GET("/stream", expense.StreamExpensesHandler)
+	g.GET("/search", expense.SearchExpensesHandler)
+
+	t := e.Group("/tags")
+	t.Use(authMiddlewareGuard(cfg.AuthToken))
+	t.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	t.GET("", expense.GetTagsHandler)
+	t.PUT("/:name", expense.RenameTagHandler)
+	t.DELETE("/:name", expense.DeleteTagHandler)
+
+	cg := e.Group("/categories")
+	cg.Use(authMiddlewareGuard(cfg.AuthToken))
+	cg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	cg.POST("", expense.CreateCategoryHandler)
+	cg.GET("", expense.GetCategoriesHandler)
+	cg.GET("/:id", expense.GetCategoryHandler)
+	cg.PUT("/:id", expense.UpdateCategoryHandler)
+	cg.DELETE("/:id", expense.DeleteCategoryHandler)
+
+	bg := e.Group("/budgets")
+	bg.Use(authMiddlewareGuard(cfg.AuthToken))
+	bg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	bg.POST("", expense.CreateBudgetHandler)
+	bg.GET("", expense.GetBudgetsHandler)
+	bg.GET("/:id", expense.GetBudgetHandler)
+	bg.PUT("/:id", expense.UpdateBudgetHandler)
+	bg.DELETE("/:id", expense.DeleteBudgetHandler)
+	bg.GET("/:id/status", expense.GetBudgetStatusHandler)
+	bg.GET("/:id/alerts", expense.GetBudgetAlertsHandler)
+
+	wg := e.Group("/webhooks")
+	wg.Use(authMiddlewareGuard(cfg.AuthToken))
+	wg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	wg.POST("", expense.CreateWebhookHandler)
+	wg.GET("", expense.GetWebhooksHandler)
+	wg.DELETE("/:id", expense.DeleteWebhookHandler)
+	wg.GET("/dead-letters", expense.GetDeadLettersHandler)
+	wg.POST("/dead-letters/:id/replay", expense.ReplayDeadLetterHandler)
+
+	rg := e.Group("/recurring-expenses")
+	rg.Use(authMiddlewareGuard(cfg.AuthToken))
+	rg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	rg.POST("", expense.CreateRecurringExpenseHandler)
+	rg.GET("", expense.GetRecurringExpensesHandler)
+	rg.GET("/:id", expense.GetRecurringExpenseHandler)
+	rg.PUT("/:id", expense.UpdateRecurringExpenseHandler)
+	rg.DELETE("/:id", expense.DeleteRecurringExpenseHandler)
+	rg.GET("/:id/preview", expense.PreviewRecurringExpenseHandler)
+
+	blg := e.Group("/balances")
+	blg.Use(authMiddlewareGuard(cfg.AuthToken))
+	blg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	blg.GET("", expense.GetBalancesHandler)
+
+	sg := e.Group("/settlements")
+	sg.Use(authMiddlewareGuard(cfg.AuthToken))
+	sg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	sg.POST("", expense.CreateSettlementHandler)
 
-	startServerGracefullyShutdown(e)
+	vg := e.Group("/views")
+	vg.Use(authMiddlewareGuard(cfg.AuthToken))
+	vg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	vg.POST("", expense.CreateViewHandler)
+	vg.GET("", expense.GetViewsHandler)
+	vg.GET("/:id", expense.GetViewHandler)
+	vg.PUT("/:id", expense.UpdateViewHandler)
+	vg.DELETE("/:id", expense.DeleteViewHandler)
+	vg.GET("/:id/expenses", expense.GetViewExpensesHandler)
+
+	mg := e.Group("/merchants")
+	mg.Use(authMiddlewareGuard(cfg.AuthToken))
+	mg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	mg.POST("", expense.CreateMerchantHandler)
+	mg.GET("", expense.GetMerchantsHandler)
+	mg.DELETE("/:id", expense.DeleteMerchantHandler)
+
+	rlg := e.Group("/rules")
+	rlg.Use(authMiddlewareGuard(cfg.AuthToken))
+	rlg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	rlg.POST("", expense.CreateRuleHandler)
+	rlg.GET("", expense.GetRulesHandler)
+	rlg.GET("/:id", expense.GetRuleHandler)
+	rlg.PUT("/:id", expense.UpdateRuleHandler)
+	rlg.DELETE("/:id", expense.DeleteRuleHandler)
+	rlg.POST("/dry-run", expense.DryRunRulesHandler)
+	rlg.POST("/apply", expense.ApplyRulesHandler)
+
+	ag := e.Group("/accounts")
+	ag.Use(authMiddlewareGuard(cfg.AuthToken))
+	ag.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	ag.POST("", expense.CreateAccountHandler)
+	ag.GET("", expense.GetAccountsHandler)
+	ag.GET("/:id", expense.GetAccountHandler)
+	ag.PUT("/:id", expense.UpdateAccountHandler)
+	ag.DELETE("/:id", expense.DeleteAccountHandler)
+	ag.GET("/:id/balance", expense.GetAccountBalanceHandler)
+
+	tg := e.Group("/transactions")
+	tg.Use(authMiddlewareGuard(cfg.AuthToken))
+	tg.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	tg.POST("", expense.CreateTransactionHandler)
+	tg.GET("", expense.GetTransactionsHandler)
+	tg.POST("/transfers", expense.CreateTransferHandler)
+	tg.GET("/summary", expense.GetCashFlowHandler)
+	tg.GET("/:id", expense.GetTransactionHandler)
+	tg.PUT("/:id", expense.UpdateTransactionHandler)
+	tg.DELETE("/:id", expense.DeleteTransactionHandler)
+
+	ig := e.Group("/imports")
+	ig.Use(authMiddlewareGuard(cfg.AuthToken))
+	ig.Use(openapi.Validator(openapi.Options{ValidateResponses: cfg.ValidateResponses}))
+	ig.POST("", expense.CreateImportHandler)
+	ig.GET("/:id", expense.GetImportHandler)
+	ig.POST("/:id/commit", expense.CommitImportHandler)
+	ig.DELETE("/:id", expense.DeleteImportHandler)
+
+	return e
 }
 
-func authMiddlewareGuard(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		token := c.Request().Header.Get("Authorization")
-		if token != os.Getenv("AUTH_TOKEN") {
-			return c.String(http.StatusUnauthorized, "Unauthorized")
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: assessment config print [flags]")
+		return 2
+	}
+
+	cfg, err := config.Load(args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func authMiddlewareGuard(authToken string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := c.Request().Header.Get("Authorization")
+			if token != authToken {
+				return problem.ErrUnauthorized
+			}
+			return next(c)
 		}
-		return next(c)
 	}
 }
 
-func startServerGracefullyShutdown(e *echo.Echo) {
+func startServerGracefullyShutdown(e *echo.Echo, address string, cfg config.Shutdown) {
 	go func() {
-		if err := e.Start(fmt.Sprintf(":%s", os.Getenv("PORT"))); err != nil && err != http.ErrServerClosed {
+		if err := e.Start(address); err != nil && err != http.ErrServerClosed {
 			e.Logger.Fatal("starting server error:", err)
 		}
 	}()
@@ -59,11 +239,31 @@ func startServerGracefullyShutdown(e *echo.Echo) {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 	e.Logger.Info("shutting down...")
+	health.MarkShuttingDown()
+	// Requests keep being served while load balancers notice the failing
+	// readiness probe.
+	time.Sleep(cfg.DrainDelay)
+	// Open streams would otherwise keep the server from shutting down.
+	if err := expense.StopStream(); err != nil {
+		e.Logger.Error("stopping expense streams error:", err)
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	// The background workers are stopped even when requests are cut off.
 	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+		e.Logger.Error("stopping http server error:", err)
 	} else {
 		e.Logger.Info("http server stopped")
 	}
+	// The scheduler enqueues events, so it stops before the dispatcher.
+	if err := expense.StopScheduler(ctx); err != nil {
+		e.Logger.Error("stopping recurring scheduler error:", err)
+	} else {
+		e.Logger.Info("recurring scheduler stopped")
+	}
+	if err := expense.StopDispatcher(ctx); err != nil {
+		e.Logger.Error("stopping outbox dispatcher error:", err)
+	} else {
+		e.Logger.Info("outbox dispatcher stopped")
+	}
 }
@@ -5,9 +5,13 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/labstack/echo/v4"
+	"github.com/lnwsitgod/assessment/config"
+	"github.com/lnwsitgod/assessment/openapi"
+	"github.com/lnwsitgod/assessment/problem"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,7 +27,7 @@ func TestAuthGuardValidToken(t *testing.T) {
 	handler := func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
 	}
-	chain := authMiddlewareGuard(handler)
+	chain := authMiddlewareGuard("November 10, 2009")(handler)
 
 	if assert.NoError(t, chain(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
@@ -43,10 +47,29 @@ func TestAuthGuardInvalidToken(t *testing.T) {
 	handler := func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
 	}
-	chain := authMiddlewareGuard(handler)
+	chain := authMiddlewareGuard("November 10, 2009")(handler)
 
-	if assert.NoError(t, chain(c)) {
-		assert.Equal(t, http.StatusUnauthorized, rec.Code)
-		assert.Equal(t, "Unauthorized", rec.Body.String())
+	assert.ErrorIs(t, chain(c), problem.ErrUnauthorized)
+}
+
+func TestRoutesDocumentedInOpenAPISpec(t *testing.T) {
+	undocumented := map[string]bool{"/openapi.json": true, "/docs": true}
+	spec := openapi.Spec()
+	e := newServer(config.Default())
+
+	routed := map[string]bool{}
+	for _, r := range e.Routes() {
+		// group middleware registers echo's own not-found handlers as catch-all routes
+		if undocumented[r.Path] || strings.HasPrefix(r.Name, "github.com/labstack/echo/") {
+			continue
+		}
+		routed[r.Method+" "+openapi.ToOpenAPIPath(r.Path)] = true
+		assert.True(t, spec.HasOperation(r.Method, r.Path), "route %s %s is missing from openapi.yaml", r.Method, r.Path)
+	}
+
+	for path, methods := range spec.Operations() {
+		for _, m := range methods {
+			assert.True(t, routed[m+" "+path], "operation %s %s in openapi.yaml has no route", m, path)
+		}
 	}
 }